@@ -18,14 +18,17 @@ package activefile
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/atc0005/go-ezproxy"
+	"github.com/atc0005/go-ezproxy/internal/ctxutils"
+	"github.com/atc0005/go-ezproxy/internal/decompress"
 )
 
 const (
@@ -90,19 +93,45 @@ type activeFileReader struct {
 	Username string
 
 	// Filename is the name of the file which will be parsed/searched for the
-	// specified username.
+	// specified username. For input not read from a file this is a
+	// descriptive name used for logging purposes.
 	Filename string
+
+	// Open opens the input which will be parsed/searched for the specified
+	// username. This is called for each read attempt.
+	Open ezproxy.OpenFunc
+
+	// Lenient indicates whether malformed records are skipped (and recorded
+	// as warnings) instead of causing the whole file to be rejected.
+	Lenient bool
 }
 
-// NewReader creates a new instance of a SessionReader that provides access to
-// a collection of user sessions for the specified username.
-func NewReader(username string, filename string) (ezproxy.SessionsReader, error) {
+// Reader is the API for retrieving values from an active users and hosts
+// file.
+type Reader interface {
+	ezproxy.SessionsReaderContext
+	ezproxy.SearchSettings
+
+	// State uses the previously provided filename to return the full
+	// contents of the active users and hosts file as a State value. The
+	// sessions included are NOT filtered to a specific username.
+	State() (*State, error)
+
+	// SetLenient is a helper method for enabling or disabling lenient
+	// parsing. In lenient mode malformed records are skipped instead of
+	// causing the whole file to be rejected; the skipped records are
+	// recorded as warnings in the State value.
+	SetLenient(lenient bool)
+}
 
-	if username == "" {
-		return nil, errors.New(
-			"func NewReader: missing username",
-		)
-	}
+// inputName is the descriptive name used for logging purposes for input not
+// read from a file.
+const inputName string = "input"
+
+// NewReader creates a new instance of a Reader that provides access to a
+// collection of user sessions for the specified username. The username is
+// optional if only the AllUserSessions or State methods are used.
+func NewReader(username string, filename string) (Reader, error) {
 
 	if filename == "" {
 		return nil, errors.New(
@@ -115,6 +144,56 @@ func NewReader(username string, filename string) (ezproxy.SessionsReader, error)
 		SearchRetries: ezproxy.DefaultSearchRetries,
 		Username:      username,
 		Filename:      filename,
+		Open:          ezproxy.FileOpener(filename),
+	}
+
+	return &reader, nil
+}
+
+// NewReaderFunc creates a new instance of a Reader that provides access to a
+// collection of user sessions for the specified username using the content
+// provided by the given OpenFunc. The OpenFunc is called for each read
+// attempt. The username is optional if only the AllUserSessions or State
+// methods are used.
+func NewReaderFunc(username string, open ezproxy.OpenFunc) (Reader, error) {
+
+	if open == nil {
+		return nil, errors.New(
+			"func NewReaderFunc: missing open function",
+		)
+	}
+
+	reader := activeFileReader{
+		SearchDelay:   ezproxy.DefaultSearchDelay,
+		SearchRetries: ezproxy.DefaultSearchRetries,
+		Username:      username,
+		Filename:      inputName,
+		Open:          open,
+	}
+
+	return &reader, nil
+}
+
+// NewReaderFrom creates a new instance of a Reader that provides access to a
+// collection of user sessions for the specified username using the content
+// provided by the given io.Reader. The content is streamed from the reader
+// on each read attempt; unless the reader can be rewound (see
+// ezproxy.ReaderOpener), only one read attempt can be made. As the content
+// does not change, search retries and the delay between search attempts are
+// disabled by default. The username is optional if only the AllUserSessions
+// or State methods are used.
+func NewReaderFrom(username string, r io.Reader) (Reader, error) {
+
+	if r == nil {
+		return nil, errors.New(
+			"func NewReaderFrom: missing reader",
+		)
+	}
+
+	reader := activeFileReader{
+		Username: username,
+		Filename: inputName,
+		Open:     ezproxy.ReaderOpener(r),
 	}
 
 	return &reader, nil
@@ -125,18 +204,40 @@ func NewReader(username string, filename string) (ezproxy.SessionsReader, error)
 // handle converting these entries to UserSession values.
 func (afr activeFileReader) filterEntries(validPrefixes []string) ([]ezproxy.FileEntry, error) {
 
+	entries, err := afr.readEntries()
+	if err != nil {
+		return nil, fmt.Errorf("func filterEntries: %w", err)
+	}
+
+	var validLines []ezproxy.FileEntry
+
+	for _, entry := range entries {
+		for _, validPrefix := range validPrefixes {
+			if strings.HasPrefix(entry.Text, validPrefix) {
+				validLines = append(validLines, entry)
+			}
+		}
+	}
+
+	return validLines, nil
+}
+
+// readEntries is a helper function that returns all non-empty entries from
+// the provided active file, with leading and trailing whitespace removed.
+func (afr activeFileReader) readEntries() ([]ezproxy.FileEntry, error) {
+
 	ezproxy.Logger.Printf(
-		"filterEntries: Request to open %q received\n",
+		"readEntries: Request to open %q received\n",
 		afr.Filename,
 	)
-	ezproxy.Logger.Printf(
-		"filterEntries: Attempting to open sanitized version of file %q\n",
-		filepath.Clean(afr.Filename),
-	)
 
-	f, err := os.Open(filepath.Clean(afr.Filename))
+	if afr.Open == nil {
+		return nil, errors.New("func readEntries: missing open function")
+	}
+
+	f, err := afr.Open()
 	if err != nil {
-		return nil, fmt.Errorf("func filterEntries: error encountered opening file %q: %w", afr.Filename, err)
+		return nil, fmt.Errorf("func readEntries: %w", err)
 	}
 
 	// #nosec G307
@@ -147,7 +248,7 @@ func (afr activeFileReader) filterEntries(validPrefixes []string) ([]ezproxy.Fil
 			// Ignore "file already closed" errors
 			if !errors.Is(err, os.ErrClosed) {
 				ezproxy.Logger.Printf(
-					"filterEntries: failed to close file %q: %s",
+					"readEntries: failed to close file %q: %s",
 					afr.Filename,
 					err.Error(),
 				)
@@ -155,11 +256,38 @@ func (afr activeFileReader) filterEntries(validPrefixes []string) ([]ezproxy.Fil
 		}
 	}()
 
-	s := bufio.NewScanner(f)
+	entries, err := scanEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("func readEntries: %w", err)
+	}
+
+	// explicitly close file, bail if failure occurs
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf(
+			"func readEntries: failed to close file %q: %w",
+			afr.Filename,
+			err,
+		)
+	}
+
+	return entries, nil
+}
+
+// scanEntries is a helper function that returns all non-empty entries from
+// the provided active file content, with leading and trailing whitespace
+// removed. Compressed content is decompressed as it is read.
+func scanEntries(input io.Reader) ([]ezproxy.FileEntry, error) {
+
+	r, err := decompress.NewReader(input)
+	if err != nil {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(r)
 
 	var lineno int
 
-	var validLines []ezproxy.FileEntry
+	var entries []ezproxy.FileEntry
 
 	// TODO: Does Scan() perform any whitespace manipulation already?
 	for s.Scan() {
@@ -172,34 +300,21 @@ func (afr activeFileReader) filterEntries(validPrefixes []string) ([]ezproxy.Fil
 		// 	lineno, filename, currentLine)
 
 		if currentLine != "" {
-			for _, validPrefix := range validPrefixes {
-				if strings.HasPrefix(currentLine, validPrefix) {
-					validLines = append(validLines, ezproxy.FileEntry{
-						Text:   currentLine,
-						Number: lineno,
-					})
-				}
-			}
+			entries = append(entries, ezproxy.FileEntry{
+				Text:   currentLine,
+				Number: lineno,
+			})
 		}
 	}
 
 	ezproxy.Logger.Printf("Exited s.Scan() loop")
 
-	// report any errors encountered while scanning the input file
+	// report any errors encountered while scanning the input
 	if err := s.Err(); err != nil {
-		return nil, fmt.Errorf("func filterEntries: errors encountered while scanning the input file: %w", err)
+		return nil, fmt.Errorf("errors encountered while scanning the input: %w", err)
 	}
 
-	// explicitly close file, bail if failure occurs
-	if err := f.Close(); err != nil {
-		return nil, fmt.Errorf(
-			"func filterEntries: failed to close file %q: %w",
-			afr.Filename,
-			err,
-		)
-	}
-
-	return validLines, nil
+	return entries, nil
 }
 
 // SetSearchRetries is a helper method for setting the number of additional
@@ -226,12 +341,53 @@ func (afr *activeFileReader) SetSearchDelay(delay int) error {
 	return nil
 }
 
+// SearchSettings returns the number of additional search attempts and the
+// delay between search attempts.
+func (afr activeFileReader) SearchSettings() (int, time.Duration) {
+	return afr.SearchRetries, afr.SearchDelay
+}
+
+// SetLenient is a helper method for enabling or disabling lenient parsing.
+// In lenient mode malformed records are skipped instead of causing the whole
+// file to be rejected; the skipped records are recorded as warnings in the
+// State value.
+func (afr *activeFileReader) SetLenient(lenient bool) {
+	afr.Lenient = lenient
+}
+
 // AllUserSessions returns a list of all session IDs along with their associated
 // IP Address in the form of a slice of UserSession values. This list of
 // session IDs is intended for further processing such as filtering to a
 // specific username or aggregating to check thresholds.
+//
+// In lenient mode, malformed records are skipped and every valid session is
+// returned. Use the State method to retrieve warnings for the skipped
+// records.
 func (afr activeFileReader) AllUserSessions() (ezproxy.UserSessions, error) {
 
+	if afr.Lenient {
+		state, err := afr.State()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to parse active file entries while generating list of user sessions: %w",
+				err,
+			)
+		}
+
+		for _, warning := range state.Warnings {
+			ezproxy.Logger.Printf("Malformed record: %s\n", warning)
+		}
+
+		userSessions := state.UserSessions()
+
+		ezproxy.Logger.Printf(
+			"Found %d active sessions\n",
+			len(userSessions),
+		)
+
+		return userSessions, nil
+	}
+
 	// Lines containing the session entries
 	validPrefixes := []string{
 		SessionLinePrefix,
@@ -295,10 +451,45 @@ func (afr activeFileReader) AllUserSessions() (ezproxy.UserSessions, error) {
 				return nil, errors.New(errMsg)
 			}
 
-			allUserSessions = append(allUserSessions, ezproxy.UserSession{
-				SessionID: activeFileEntry[1],
-				IPAddress: activeFileEntry[6],
-			})
+			// session ID as field 2, creation and last access times as
+			// fields 3 and 4, MaxLifetime as field 6, IP Address as field 7
+			if len(activeFileEntry) <= sessionFieldIPAddress {
+				errMsg := fmt.Sprintf(
+					"error: Session line %d in the active users file %q has %d fields, expected at least %d",
+					lineno,
+					afr.Filename,
+					len(activeFileEntry),
+					sessionFieldIPAddress+1,
+				)
+				ezproxy.Logger.Println(errMsg)
+				return nil, errors.New(errMsg)
+			}
+
+			// Only the session ID and IP Address are required in order to
+			// terminate a session. If the remaining fields (which only
+			// appear to be timestamps) cannot be parsed, the session is
+			// kept without the affected values.
+			session, problems, err := parseSessionLine(activeFileEntry)
+			if err != nil {
+				errMsg := fmt.Sprintf(
+					"error: Session line %d in the active users file %q: %v",
+					lineno,
+					afr.Filename,
+					err,
+				)
+				ezproxy.Logger.Println(errMsg)
+				return nil, errors.New(errMsg)
+			}
+			for _, problem := range problems {
+				ezproxy.Logger.Printf(
+					"Keeping session from line %d in the active users file %q: %s\n",
+					lineno,
+					afr.Filename,
+					problem,
+				)
+			}
+
+			allUserSessions = append(allUserSessions, session.UserSession())
 		case UsernameLinePrefix:
 			// line 2 of 2 (odd numbered idx)
 			// username as field 2
@@ -365,6 +556,36 @@ func (afr activeFileReader) AllUserSessions() (ezproxy.UserSessions, error) {
 // of all matching session IDs along with their associated IP Address in the
 // form of a slice of UserSession values.
 func (afr activeFileReader) MatchingUserSessions() (ezproxy.UserSessions, error) {
+	return afr.MatchingUserSessionsContext(context.Background())
+}
+
+// AllUserSessionsContext behaves like AllUserSessions, returning early with
+// the context error if the provided context is done.
+func (afr activeFileReader) AllUserSessionsContext(ctx context.Context) (ezproxy.UserSessions, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func AllUserSessionsContext: missing context")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("func AllUserSessionsContext: %w", err)
+	}
+
+	return afr.AllUserSessions()
+}
+
+// MatchingUserSessionsContext behaves like MatchingUserSessions, returning
+// early with the context error if the provided context is done (e.g., while
+// delaying between search attempts).
+func (afr activeFileReader) MatchingUserSessionsContext(ctx context.Context) (ezproxy.UserSessions, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func MatchingUserSessionsContext: missing context")
+	}
+
+	if afr.Username == "" {
+		return nil, errors.New("func MatchingUserSessions: missing username")
+	}
 
 	// What we will return to the the caller
 	requestedUserSessions := make([]ezproxy.UserSession, 0, ezproxy.SessionsLimit)
@@ -387,9 +608,11 @@ func (afr activeFileReader) MatchingUserSessions() (ezproxy.UserSessions, error)
 			"Intentionally delaying for %v to help avoid race condition due to delayed EZproxy writes\n",
 			afr.SearchDelay,
 		)
-		time.Sleep(afr.SearchDelay)
+		if err := ctxutils.Sleep(ctx, afr.SearchDelay); err != nil {
+			return nil, fmt.Errorf("func UserSessions: search for %q cancelled: %w", afr.Username, err)
+		}
 
-		allUserSessions, err := afr.AllUserSessions()
+		allUserSessions, err := afr.AllUserSessionsContext(ctx)
 		if err != nil {
 			return nil, fmt.Errorf(
 				"func UserSessions: failed to retrieve all user sessions in order to filter to specific username: %w",
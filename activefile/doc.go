@@ -36,7 +36,12 @@ Group (g)
 Session (S)
 Username or Login (L)
 
-Currently, only the last two types (S, L) are relevant to our purposes.
+The MatchingUserSessions and AllUserSessions methods of a Reader only make
+use of the Session and Login types (S, L). The State method of a Reader
+models the full contents of the file: Host lines are returned as Host values,
+Session lines along with their Login, Group and lowercase s lines are
+returned as Session values and all other lines are preserved verbatim as
+Line values.
 
 # Unknown Types
 
@@ -47,6 +52,9 @@ P
 M
 s (lowercase letter)
 
+Lines of these types are preserved verbatim by the State method; lowercase s
+lines are associated with the preceding Session line.
+
 # Line Ordering
 
 For our purposes, we match lines that start with a capital letter S and pair
@@ -66,8 +74,54 @@ and:
 S
 L
 
+# Lenient Parsing
+
+By default, a Reader rejects the whole file if any session record is
+malformed (e.g., an odd number of S and L lines or a pair found out of order).
+EZproxy may be part way through writing a session when the file is read, so
+a lenient mode is also available via the SetLenient method. In lenient mode,
+S lines are paired with the L line which follows them (skipping over s and g
+lines), malformed records are skipped and every valid session is returned.
+The skipped records are available as structured warnings via the Warnings
+field of the value returned by the State method.
+
+In either mode, a session line whose timestamp or lifetime fields cannot be
+parsed is not treated as malformed as long as the session ID and IP Address
+are present; the session is kept with those fields left as the zero value and
+a warning is recorded.
+
+# Watching for Changes
+
+A Watcher created via NewWatcher polls the active users and hosts file for
+changes (using the modification time and size of the file) and sends a
+SessionStarted or SessionEnded event on a channel for each session added to
+or removed from the file. Sessions are only compared when the file did not
+change while it was being read, so a file which EZproxy is part way through
+writing is read again at the next poll instead of being reported as ended
+sessions. Polling is used instead of filesystem notification APIs in order to
+avoid external dependencies. The Watcher stops when the
+provided context is cancelled.
+
+# Writing Files
+
+A Writer created via NewWriter (or the WriteFile function) writes a State
+value in the format of an active users and hosts file. This is intended for
+generating realistic files for testing purposes instead of using copies of
+production files. Reading the output with a Reader results in a State value
+equal to the one written, aside from line numbers.
+
 # Field Numbers
 
+The line for Hosts (H) has not been confirmed. The first field containing a
+URL is used as the URL of the proxied host and the first non-zero numeric
+field before the URL appears to be the local port assigned by EZproxy to the
+host.
+
+The line for Groups (g) is composed of 2 or more fields:
+
+01) Leading lowercase letter g
+02) Group name(s), one per field
+
 The line for for Logins (L) is composed of 2 fields:
 
 01) Leading capital letter L
@@ -77,10 +131,10 @@ The line for Sessions (S) is composed of 11 fields:
 
 01) Leading capital letter S
 02) Session ID
-03) unknown, appears to be a UNIX timestamp
-04) unknown, appears to be two UNIX timestamps separated by a literal dot
+03) appears to be a UNIX timestamp; used as the session creation time
+04) appears to be two UNIX timestamps separated by a literal dot; first used as last access time
 05) unknown integer; number 1 was common
-06) EZproxy "MaxLifetime" or User Session timeout value
+06) EZproxy "MaxLifetime" or User Session timeout value in minutes
 07) IP Address
 08) unknown, 0 is recorded
 09) unknown, 0 is recorded
@@ -0,0 +1,590 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activefile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// These are the remaining line prefixes observed in the active users and
+// hosts file.
+const (
+	// HostLinePrefix is a single letter prefix found at the start of all
+	// lines containing a proxied host.
+	HostLinePrefix string = "H"
+
+	// GroupLinePrefix is a single letter prefix found at the start of all
+	// lines containing the groups for the preceding session.
+	GroupLinePrefix string = "g"
+
+	// SessionDetailLinePrefix is a single lowercase letter prefix found at
+	// the start of lines which follow a session line. The purpose of this
+	// line is not known.
+	SessionDetailLinePrefix string = "s"
+
+	// PLinePrefix is a single letter prefix found at the start of lines
+	// whose purpose is not known.
+	PLinePrefix string = "P"
+
+	// MLinePrefix is a single letter prefix found at the start of lines
+	// whose purpose is not known.
+	MLinePrefix string = "M"
+)
+
+// These are the field positions (zero-based) for session lines.
+const (
+	sessionFieldSessionID int = iota + 1
+	sessionFieldCreated
+	sessionFieldLastAccessed
+	sessionFieldField5
+	sessionFieldMaxLifetime
+	sessionFieldIPAddress
+	sessionFieldTrailing
+)
+
+// activeFileFieldDelimiter is the delimiter between fields for each line in
+// the active users and hosts file.
+const activeFileFieldDelimiter string = " "
+
+// Line reflects a line in the active users and hosts file which is preserved
+// verbatim. This is used for line types without a dedicated model.
+type Line struct {
+
+	// Type is the first field in the line (e.g., P, M, s).
+	Type string
+
+	// Fields is the list of space-separated fields following the line type.
+	Fields []string
+
+	// Number is the line number within the file.
+	Number int
+}
+
+// Host reflects a line in the active users and hosts file which starts with
+// capital letter 'H'. Hosts are proxied by EZproxy either on a dedicated
+// port or by hostname. The layout of this line has not been confirmed; the
+// values below are determined on a best-effort basis and all fields are
+// preserved verbatim.
+type Host struct {
+
+	// Fields is the list of space-separated fields following the line type.
+	Fields []string
+
+	// URL is the first field containing a URL scheme (e.g.,
+	// https://www.jstor.org).
+	URL string
+
+	// Hostname is the hostname of the proxied host.
+	Hostname string
+
+	// Port is the port of the proxied host (from the URL, or the default
+	// port for the URL scheme).
+	Port int
+
+	// ProxyPort is the first numeric field found before the URL. This
+	// appears to be the local port assigned by EZproxy to the host; zero
+	// indicates that no dedicated port is assigned.
+	ProxyPort int
+
+	// ProxyByHostname indicates that the host is proxied by hostname rather
+	// than on a dedicated port.
+	ProxyByHostname bool
+
+	// Number is the line number within the file.
+	Number int
+}
+
+// Session reflects the set of lines in the active users and hosts file which
+// make up a single user session: a line starting with capital letter 'S',
+// optionally followed by lines starting with lowercase letter 's', a line
+// starting with capital letter 'L' and lines starting with lowercase letter
+// 'g'.
+type Session struct {
+
+	// SessionID is the second field of the session line.
+	SessionID string
+
+	// Created is the third field of the session line. This appears to be the
+	// UNIX timestamp for when the session was created.
+	Created int64
+
+	// LastAccessed is the first half of the fourth field of the session
+	// line. This appears to be the UNIX timestamp for when the session was
+	// last accessed.
+	LastAccessed int64
+
+	// LastAccessedSecondary is the second half of the fourth field of the
+	// session line. This also appears to be a UNIX timestamp.
+	LastAccessedSecondary int64
+
+	// Field5 is the fifth field of the session line. The purpose of this
+	// field is not known; 1 was common.
+	Field5 int
+
+	// MaxLifetime is the sixth field of the session line. This is the
+	// EZproxy "MaxLifetime" or User Session timeout value in minutes.
+	MaxLifetime int
+
+	// IPAddress is the seventh field of the session line.
+	IPAddress string
+
+	// TrailingFields is the list of remaining fields of the session line.
+	// The purpose of these fields is not known ("0 0 0 *" was common).
+	TrailingFields []string
+
+	// Username is the second field of the login line.
+	Username string
+
+	// Groups is the list of groups found on group lines for the session.
+	Groups []string
+
+	// Details is the list of lines starting with lowercase letter 's' for
+	// the session, preserved verbatim.
+	Details []Line
+
+	// Number is the line number of the session line within the file.
+	Number int
+}
+
+// State reflects the full contents of an active users and hosts file.
+type State struct {
+
+	// Hosts is the list of proxied hosts in the order they were found.
+	Hosts []Host
+
+	// Sessions is the list of user sessions in the order they were found.
+	Sessions []Session
+
+	// Other is the list of lines without a dedicated model (e.g., P and M
+	// lines and any unknown line types), preserved verbatim in the order
+	// they were found.
+	Other []Line
+
+	// Warnings is the list of malformed records skipped while parsing the
+	// file in lenient mode, along with any session line field values which
+	// could not be parsed (in either mode).
+	Warnings Warnings
+}
+
+// Warning reflects a malformed record in the active users and hosts file
+// which was skipped while parsing the file in lenient mode, or a session line
+// with field values which could not be parsed. Sessions are kept with those
+// fields left as the zero value.
+type Warning struct {
+
+	// Number is the line number within the file.
+	Number int
+
+	// Text is the content of the line.
+	Text string
+
+	// Message describes the problem with the record.
+	Message string
+}
+
+// Warnings is a collection of Warning values.
+type Warnings []Warning
+
+// String provides a human readable version of the warning.
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d: %s (%q)", w.Number, w.Message, w.Text)
+}
+
+// State uses the previously provided filename to return the full contents of
+// the active users and hosts file as a State value. The sessions included are
+// NOT filtered to a specific username.
+func (afr activeFileReader) State() (*State, error) {
+
+	entries, err := afr.readEntries()
+	if err != nil {
+		return nil, fmt.Errorf("func State: failed to read active file entries: %w", err)
+	}
+
+	state, err := parseState(entries, afr.Lenient)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"func State: failed to parse active users file %q: %w",
+			afr.Filename,
+			err,
+		)
+	}
+
+	ezproxy.Logger.Printf(
+		"Found %d hosts, %d sessions, %d other lines and %d warnings\n",
+		len(state.Hosts),
+		len(state.Sessions),
+		len(state.Other),
+		len(state.Warnings),
+	)
+
+	return state, nil
+}
+
+// ReadState returns the full contents of the active users and hosts file
+// provided by the given io.Reader as a State value. Compressed content is
+// decompressed as it is read. In lenient mode malformed records are skipped
+// and recorded as warnings instead of causing the whole file to be rejected.
+func ReadState(r io.Reader, lenient bool) (*State, error) {
+
+	if r == nil {
+		return nil, errors.New("func ReadState: missing reader")
+	}
+
+	entries, err := scanEntries(r)
+	if err != nil {
+		return nil, fmt.Errorf("func ReadState: failed to read active file entries: %w", err)
+	}
+
+	state, err := parseState(entries, lenient)
+	if err != nil {
+		return nil, fmt.Errorf("func ReadState: failed to parse active users file: %w", err)
+	}
+
+	return state, nil
+}
+
+// parseState converts the given active file entries into a State value. In
+// lenient mode malformed records are skipped and recorded as warnings instead
+// of causing the whole file to be rejected. In either mode, a session line
+// with field values which cannot be parsed (other than the session ID and IP
+// Address) is kept with those fields left as the zero value and a warning is
+// recorded.
+func parseState(entries []ezproxy.FileEntry, lenient bool) (*State, error) {
+
+	state := State{
+		Sessions: make([]Session, 0, ezproxy.AllUsersSessionsLimit),
+	}
+
+	// warn records a warning for the given entry.
+	warn := func(entry ezproxy.FileEntry, message string) {
+		state.Warnings = append(state.Warnings, Warning{
+			Number:  entry.Number,
+			Text:    entry.Text,
+			Message: message,
+		})
+	}
+
+	// problem records a malformed record as a warning in lenient mode or
+	// returns it as an error otherwise.
+	problem := func(entry ezproxy.FileEntry, format string, a ...interface{}) error {
+		message := fmt.Sprintf(format, a...)
+		if !lenient {
+			return fmt.Errorf("line %d: %s", entry.Number, message)
+		}
+		ezproxy.Logger.Printf("Skipping line %d: %s\n", entry.Number, message)
+		warn(entry, message)
+		return nil
+	}
+
+	// current is the session which login, group and session detail lines
+	// are associated with. This is nil before the first session line and
+	// after a malformed session line.
+	var current *Session
+
+	// pending is the session line entry for the current session; used to
+	// report sessions without a login line.
+	var pending ezproxy.FileEntry
+
+	// skipped is the line number of the last malformed session line, zero
+	// if the last session line was valid.
+	var skipped int
+
+	// orphan records a login, group or session detail line found without a
+	// valid preceding session line.
+	orphan := func(entry ezproxy.FileEntry, kind string) error {
+		if skipped != 0 {
+			return problem(entry, "%s line belongs to malformed session line %d", kind, skipped)
+		}
+		return problem(entry, "%s line without a preceding session line", kind)
+	}
+
+	// finish completes the current session, keeping it only if a login line
+	// was found.
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		session := *current
+		current = nil
+		if session.Username == "" {
+			return problem(pending, "missing login line for session %q", session.SessionID)
+		}
+		state.Sessions = append(state.Sessions, session)
+		return nil
+	}
+
+	for _, entry := range entries {
+
+		fields := strings.Split(entry.Text, activeFileFieldDelimiter)
+		lineType := fields[0]
+
+		var err error
+
+		switch lineType {
+		case SessionLinePrefix:
+			if err = finish(); err != nil {
+				return nil, err
+			}
+			session, fieldProblems, parseErr := parseSessionLine(fields)
+			if parseErr != nil {
+				skipped = entry.Number
+				err = problem(entry, "%v", parseErr)
+				break
+			}
+			for _, message := range fieldProblems {
+				ezproxy.Logger.Printf("Keeping session from line %d: %s\n", entry.Number, message)
+				warn(entry, message)
+			}
+			skipped = 0
+			session.Number = entry.Number
+			current = &session
+			pending = entry
+
+		case UsernameLinePrefix:
+			switch {
+			case current == nil:
+				err = orphan(entry, "login")
+			case current.Username != "":
+				err = problem(entry, "additional login line for session %q", current.SessionID)
+			case len(fields) < 2 || fields[1] == "":
+				err = problem(entry, "login line is missing username")
+			default:
+				current.Username = fields[1]
+			}
+
+		case GroupLinePrefix:
+			if current == nil {
+				err = orphan(entry, "group")
+				break
+			}
+			current.Groups = append(current.Groups, fields[1:]...)
+
+		case SessionDetailLinePrefix:
+			if current == nil {
+				err = orphan(entry, "session detail")
+				break
+			}
+			current.Details = append(current.Details, newLine(fields, entry.Number))
+
+		case HostLinePrefix:
+			state.Hosts = append(state.Hosts, parseHostLine(fields, entry.Number))
+
+		default:
+			state.Other = append(state.Other, newLine(fields, entry.Number))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// newLine creates a new Line value from the given fields.
+func newLine(fields []string, lineno int) Line {
+	return Line{
+		Type:   fields[0],
+		Fields: append([]string(nil), fields[1:]...),
+		Number: lineno,
+	}
+}
+
+// parseSessionLine converts the fields of a session line into a partial
+// Session value. An error is returned if the session line is too short to
+// include the session ID and IP Address. Any other field value which cannot
+// be parsed is left as the zero value and described in the returned list of
+// problems.
+func parseSessionLine(fields []string) (Session, []string, error) {
+
+	if len(fields) <= sessionFieldIPAddress {
+		return Session{}, nil, fmt.Errorf(
+			"session line has %d fields, expected at least %d",
+			len(fields),
+			sessionFieldIPAddress+1,
+		)
+	}
+
+	session := Session{
+		SessionID: fields[sessionFieldSessionID],
+		IPAddress: fields[sessionFieldIPAddress],
+	}
+
+	var problems []string
+
+	// invalid records a field value which could not be parsed.
+	invalid := func(name string, err error) {
+		problems = append(problems, fmt.Sprintf(
+			"invalid %s value for session %q: %v",
+			name,
+			session.SessionID,
+			err,
+		))
+	}
+
+	var err error
+
+	if session.Created, err = strconv.ParseInt(fields[sessionFieldCreated], 10, 64); err != nil {
+		session.Created = 0
+		invalid("created", err)
+	}
+
+	lastAccessed, lastAccessedSecondary, _ := strings.Cut(fields[sessionFieldLastAccessed], ".")
+	if session.LastAccessed, err = strconv.ParseInt(lastAccessed, 10, 64); err != nil {
+		session.LastAccessed = 0
+		invalid("last accessed", err)
+	}
+	if lastAccessedSecondary != "" {
+		if session.LastAccessedSecondary, err = strconv.ParseInt(lastAccessedSecondary, 10, 64); err != nil {
+			session.LastAccessedSecondary = 0
+			invalid("last accessed", err)
+		}
+	}
+
+	if session.Field5, err = strconv.Atoi(fields[sessionFieldField5]); err != nil {
+		session.Field5 = 0
+		invalid("fifth field", err)
+	}
+
+	if session.MaxLifetime, err = strconv.Atoi(fields[sessionFieldMaxLifetime]); err != nil {
+		session.MaxLifetime = 0
+		invalid("MaxLifetime", err)
+	}
+
+	if len(fields) > sessionFieldTrailing {
+		session.TrailingFields = append([]string(nil), fields[sessionFieldTrailing:]...)
+	}
+
+	return session, problems, nil
+}
+
+// parseHostLine converts the fields of a host line into a Host value.
+func parseHostLine(fields []string, lineno int) Host {
+
+	host := Host{
+		Fields: append([]string(nil), fields[1:]...),
+		Number: lineno,
+	}
+
+	for _, field := range host.Fields {
+
+		if strings.Contains(field, "://") {
+			host.URL = field
+			break
+		}
+
+		if host.ProxyPort == 0 {
+			if port, err := strconv.Atoi(field); err == nil && port > 0 && port <= 65535 {
+				host.ProxyPort = port
+			}
+		}
+	}
+
+	host.ProxyByHostname = host.ProxyPort == 0
+
+	if host.URL == "" {
+		return host
+	}
+
+	u, err := url.Parse(host.URL)
+	if err != nil {
+		return host
+	}
+
+	host.Hostname = strings.ToLower(u.Hostname())
+
+	switch port, err := strconv.Atoi(u.Port()); {
+	case err == nil:
+		host.Port = port
+	case strings.EqualFold(u.Scheme, "https"):
+		host.Port = 443
+	case strings.EqualFold(u.Scheme, "http"):
+		host.Port = 80
+	}
+
+	return host
+}
+
+// UserSession converts a Session value to a UserSession value.
+func (s Session) UserSession() ezproxy.UserSession {
+	return ezproxy.UserSession{
+		SessionID:    s.SessionID,
+		IPAddress:    s.IPAddress,
+		Username:     s.Username,
+		Created:      unixTime(s.Created),
+		LastAccessed: unixTime(s.LastAccessed),
+		MaxLifetime:  time.Duration(s.MaxLifetime) * time.Minute,
+	}
+}
+
+// unixTime converts the given UNIX timestamp to a time.Time value. The zero
+// value is returned for timestamps which are not set.
+func unixTime(timestamp int64) time.Time {
+	if timestamp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+// UserSessions returns a UserSession value for every session in the active
+// users and hosts file.
+func (s State) UserSessions() ezproxy.UserSessions {
+
+	userSessions := make(ezproxy.UserSessions, 0, len(s.Sessions))
+
+	for idx := range s.Sessions {
+		userSessions = append(userSessions, s.Sessions[idx].UserSession())
+	}
+
+	return userSessions
+}
+
+// MatchingSessions returns the sessions for the specified username.
+func (s State) MatchingSessions(username string) []Session {
+
+	sessions := make([]Session, 0, ezproxy.SessionsLimit)
+
+	for idx := range s.Sessions {
+		if strings.EqualFold(s.Sessions[idx].Username, username) {
+			sessions = append(sessions, s.Sessions[idx])
+		}
+	}
+
+	return sessions
+}
+
+// InGroup reports whether the session is a member of the specified group.
+func (s Session) InGroup(group string) bool {
+	for _, g := range s.Groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activefile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// testEntries converts the given lines to file entries numbered from 1.
+func testEntries(lines ...string) []ezproxy.FileEntry {
+	entries := make([]ezproxy.FileEntry, 0, len(lines))
+	for idx, line := range lines {
+		entries = append(entries, ezproxy.FileEntry{Text: line, Number: idx + 1})
+	}
+	return entries
+}
+
+const (
+	testSessionLineAlice = "S aBcDeFgHiJkLmNo 1600000000 1600000100 1 120 192.0.2.10 0 0 0 *"
+	testSessionLineBob   = "S pQrStUvWxYz0123 1600000200 1600000300 1 60 192.0.2.20 0 0 0 *"
+)
+
+func TestParseStateMalformed(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// lines is the content of the active file.
+		lines []string
+		// strictErr indicates whether parsing fails in strict mode.
+		strictErr bool
+		// usernames is the list of session usernames returned in lenient
+		// mode (and in strict mode when strictErr is false).
+		usernames []string
+		// warnings is the list of line numbers with warnings.
+		warnings []int
+	}{
+		{
+			name:      "valid",
+			lines:     []string{"P 1 2", testSessionLineAlice, "s x y", "L alice", "g Default", testSessionLineBob, "L bob"},
+			usernames: []string{"alice", "bob"},
+		},
+		{
+			name:      "session without login line",
+			lines:     []string{testSessionLineAlice, testSessionLineBob, "L bob"},
+			strictErr: true,
+			usernames: []string{"bob"},
+			warnings:  []int{1},
+		},
+		{
+			name:      "additional login line",
+			lines:     []string{testSessionLineAlice, "L alice", "L mallory", testSessionLineBob, "L bob"},
+			strictErr: true,
+			usernames: []string{"alice", "bob"},
+			warnings:  []int{3},
+		},
+		{
+			name:      "session detail and login lines before session line",
+			lines:     []string{"s x y", "L alice", testSessionLineAlice, "L alice"},
+			strictErr: true,
+			usernames: []string{"alice"},
+			warnings:  []int{1, 2},
+		},
+		{
+			name:      "orphan group and session detail lines",
+			lines:     []string{"g Default", "s x y", testSessionLineBob, "L bob", "g Staff"},
+			strictErr: true,
+			usernames: []string{"bob"},
+			warnings:  []int{1, 2},
+		},
+		{
+			name:      "short session line",
+			lines:     []string{"S aBcDeFgHiJkLmNo 1600000000", "L alice", "g Default", testSessionLineBob, "L bob"},
+			strictErr: true,
+			usernames: []string{"bob"},
+			warnings:  []int{1, 2, 3},
+		},
+		{
+			name:      "non-numeric timestamps",
+			lines:     []string{"S aBcDeFgHiJkLmNo abc 1600000100.xyz 1 forever 192.0.2.10 0 0 0 *", "L alice", testSessionLineBob, "L bob"},
+			usernames: []string{"alice", "bob"},
+			warnings:  []int{1, 1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			strict, err := parseState(testEntries(tt.lines...), false)
+			switch {
+			case tt.strictErr && err == nil:
+				t.Error("strict parseState() error = nil, want error")
+			case !tt.strictErr && err != nil:
+				t.Errorf("strict parseState() error = %v", err)
+			case !tt.strictErr:
+				checkParsedState(t, strict, tt.usernames, tt.warnings)
+			}
+
+			lenient, err := parseState(testEntries(tt.lines...), true)
+			if err != nil {
+				t.Fatalf("lenient parseState() error = %v", err)
+			}
+			checkParsedState(t, lenient, tt.usernames, tt.warnings)
+		})
+	}
+}
+
+func TestParseStateKeepsUnparsableFields(t *testing.T) {
+
+	state, err := parseState(testEntries(
+		"S aBcDeFgHiJkLmNo abc 1600000100.xyz 1 forever 192.0.2.10 0 0 0 *",
+		"L alice",
+		"g Default",
+	), false)
+	if err != nil {
+		t.Fatalf("parseState() error = %v", err)
+	}
+
+	if len(state.Sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(state.Sessions))
+	}
+
+	session := state.Sessions[0]
+	switch {
+	case session.SessionID != "aBcDeFgHiJkLmNo",
+		session.IPAddress != "192.0.2.10",
+		session.Username != "alice",
+		len(session.Groups) != 1:
+		t.Errorf("got session %+v, want ID, IP Address, username and groups kept", session)
+	}
+
+	switch {
+	case session.Created != 0,
+		session.LastAccessed != 1600000100,
+		session.LastAccessedSecondary != 0,
+		session.Field5 != 1,
+		session.MaxLifetime != 0:
+		t.Errorf("got session %+v, want only the unparsable fields zeroed", session)
+	}
+
+	for _, warning := range state.Warnings {
+		if !strings.Contains(warning.Message, "aBcDeFgHiJkLmNo") {
+			t.Errorf("warning %q does not name the session", warning)
+		}
+	}
+}
+
+// checkParsedState compares the session usernames and warning line numbers
+// of the given State value.
+func checkParsedState(t *testing.T, state *State, usernames []string, warnings []int) {
+	t.Helper()
+
+	got := make([]string, 0, len(state.Sessions))
+	for _, session := range state.Sessions {
+		got = append(got, session.Username)
+	}
+	if strings.Join(got, ",") != strings.Join(usernames, ",") {
+		t.Errorf("got sessions for %v, want %v", got, usernames)
+	}
+
+	if len(state.Warnings) != len(warnings) {
+		t.Fatalf("got warnings %v, want warnings for lines %v", state.Warnings, warnings)
+	}
+	for idx, warning := range state.Warnings {
+		if warning.Number != warnings[idx] {
+			t.Errorf("warning %d is for line %d, want line %d", idx, warning.Number, warnings[idx])
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activefile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+	"github.com/atc0005/go-ezproxy/internal/ctxutils"
+)
+
+// DefaultPollInterval is the default delay between checks for changes to the
+// active users and hosts file made by a Watcher.
+const DefaultPollInterval time.Duration = 1 * time.Second
+
+// maxInitialReadAttempts is the number of attempts made to read the initial
+// sessions from an active users and hosts file which changes while it is
+// being read.
+const maxInitialReadAttempts int = 5
+
+// SessionEventType indicates the type of change to a user session noted by a
+// Watcher.
+type SessionEventType int
+
+// These are the types of changes to user sessions noted by a Watcher.
+const (
+
+	// SessionStarted indicates that a session was added to the active users
+	// and hosts file.
+	SessionStarted SessionEventType = iota + 1
+
+	// SessionEnded indicates that a session was removed from the active
+	// users and hosts file.
+	SessionEnded
+)
+
+// String provides a human readable version of the session event type.
+func (set SessionEventType) String() string {
+	switch set {
+	case SessionStarted:
+		return "SessionStarted"
+	case SessionEnded:
+		return "SessionEnded"
+	default:
+		return fmt.Sprintf("SessionEventType(%d)", int(set))
+	}
+}
+
+// SessionEvent reflects a change to a user session noted by a Watcher.
+type SessionEvent struct {
+
+	// Type is the type of change to the user session.
+	Type SessionEventType
+
+	// UserSession is the user session which was added or removed. For ended
+	// sessions this is the last recorded value of the session.
+	ezproxy.UserSession
+
+	// Time is when the change was noted.
+	Time time.Time
+}
+
+// Watcher polls the active users and hosts file for changes, comparing the
+// sessions found before and after each change. The modification time and
+// size of the file are checked on each poll; the file is only read again
+// when either changes. The file is checked again once it has been read and
+// the sessions are only compared if the file did not change during the read;
+// otherwise EZproxy may have been part way through writing the file and the
+// file is read again at the next poll.
+type Watcher struct {
+
+	// reader is used to retrieve the sessions from the active users and
+	// hosts file.
+	reader activeFileReader
+
+	// pollInterval is the delay between checks for changes to the file.
+	pollInterval time.Duration
+}
+
+// NewWatcher creates a new Watcher for the specified active users and hosts
+// file. Lenient parsing is enabled by default so that a malformed record does
+// not prevent changes to other sessions from being noted.
+func NewWatcher(filename string) (*Watcher, error) {
+
+	if filename == "" {
+		return nil, errors.New("func NewWatcher: missing filename")
+	}
+
+	return &Watcher{
+		reader: activeFileReader{
+			Filename: filename,
+			Open:     ezproxy.FileOpener(filename),
+			Lenient:  true,
+		},
+		pollInterval: DefaultPollInterval,
+	}, nil
+}
+
+// SetPollInterval is a helper method for setting the delay between checks
+// for changes to the active users and hosts file.
+func (w *Watcher) SetPollInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("func SetPollInterval: %v is not a valid poll interval", interval)
+	}
+
+	w.pollInterval = interval
+
+	return nil
+}
+
+// SetLenient is a helper method for enabling or disabling lenient parsing of
+// the active users and hosts file. If disabled, sessions are not compared
+// while the file includes a malformed record.
+func (w *Watcher) SetLenient(lenient bool) {
+	w.reader.Lenient = lenient
+}
+
+// Watch reads the current sessions from the active users and hosts file and
+// then polls the file for changes until the provided context is cancelled.
+// The current sessions are returned along with a channel on which a
+// SessionStarted or SessionEnded event is sent for each session added to or
+// removed from the file. The channel is closed once the context is
+// cancelled.
+//
+// Errors encountered reading the file after the first attempt (e.g., if
+// EZproxy is part way through replacing the file) are logged and the file is
+// read again at the next poll interval.
+func (w *Watcher) Watch(ctx context.Context) (ezproxy.UserSessions, <-chan SessionEvent, error) {
+
+	if ctx == nil {
+		return nil, nil, errors.New("func Watch: missing context")
+	}
+
+	var info os.FileInfo
+	var sessions ezproxy.UserSessions
+
+	for attempt := 1; ; attempt++ {
+
+		var stable bool
+		var err error
+
+		info, sessions, stable, err = w.read()
+		if err != nil {
+			return nil, nil, fmt.Errorf("func Watch: failed to read initial sessions: %w", err)
+		}
+
+		if stable {
+			break
+		}
+
+		if attempt == maxInitialReadAttempts {
+			return nil, nil, fmt.Errorf(
+				"func Watch: file %q changed while reading initial sessions on each of %d attempts",
+				w.reader.Filename,
+				maxInitialReadAttempts,
+			)
+		}
+
+		if err := ctxutils.Sleep(ctx, w.pollInterval); err != nil {
+			return nil, nil, fmt.Errorf("func Watch: %w", err)
+		}
+	}
+
+	events := make(chan SessionEvent, ezproxy.AllUsersSessionsLimit)
+
+	go w.poll(ctx, info, sessions, events)
+
+	return sessions, events, nil
+}
+
+// poll checks the active users and hosts file for changes at each poll
+// interval, sending events for added or removed sessions.
+func (w *Watcher) poll(
+	ctx context.Context,
+	lastInfo os.FileInfo,
+	lastSessions ezproxy.UserSessions,
+	events chan<- SessionEvent,
+) {
+
+	defer close(events)
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	// failedInfo is the file info as of the last failed read; used to only
+	// log the failure once until the file changes.
+	var failedInfo os.FileInfo
+
+	for {
+		select {
+		case <-ctx.Done():
+			ezproxy.Logger.Printf("Watch: stopping: %v\n", ctx.Err())
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(filepath.Clean(w.reader.Filename))
+		if err != nil {
+			ezproxy.Logger.Printf("Watch: failed to stat file %q: %v\n", w.reader.Filename, err)
+			continue
+		}
+
+		if sameFileInfo(info, lastInfo) {
+			continue
+		}
+
+		// The file is read again at each poll until a read succeeds
+		// without the file changing while it is being read.
+		info, sessions, stable, err := w.read()
+		switch {
+		case err != nil:
+			if !sameFileInfo(info, failedInfo) {
+				ezproxy.Logger.Printf("Watch: %v\n", err)
+			}
+			failedInfo = info
+			continue
+
+		case !stable:
+			ezproxy.Logger.Printf("Watch: file %q changed while being read; reading again\n", w.reader.Filename)
+			continue
+		}
+
+		failedInfo = nil
+
+		now := time.Now()
+		for _, event := range diffSessions(lastSessions, sessions, now) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				ezproxy.Logger.Printf("Watch: stopping: %v\n", ctx.Err())
+				return
+			}
+		}
+
+		lastInfo = info
+		lastSessions = sessions
+	}
+}
+
+// read reads the sessions from the active users and hosts file, returning
+// them along with the file info as of the read. The returned stable value is
+// false if the file changed while it was being read, in which case the
+// sessions may be incomplete and are not returned.
+func (w *Watcher) read() (os.FileInfo, ezproxy.UserSessions, bool, error) {
+
+	filename := filepath.Clean(w.reader.Filename)
+
+	before, err := os.Stat(filename)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to stat file %q: %w", w.reader.Filename, err)
+	}
+
+	sessions, err := w.reader.AllUserSessions()
+	if err != nil {
+		return before, nil, false, fmt.Errorf("failed to read sessions from %q: %w", w.reader.Filename, err)
+	}
+
+	after, err := os.Stat(filename)
+	if err != nil {
+		return before, nil, false, fmt.Errorf("failed to stat file %q: %w", w.reader.Filename, err)
+	}
+
+	if !sameFileInfo(before, after) {
+		return after, nil, false, nil
+	}
+
+	return after, sessions, true, nil
+}
+
+// sameFileInfo indicates whether the modification time and size of the two
+// file info values are the same. A nil value is never the same.
+func sameFileInfo(a os.FileInfo, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// diffSessions compares the previous and current collections of sessions,
+// returning a SessionEnded event for each session no longer present followed
+// by a SessionStarted event for each new session. Sessions are matched by
+// session ID and username.
+func diffSessions(previous ezproxy.UserSessions, current ezproxy.UserSessions, now time.Time) []SessionEvent {
+
+	key := func(session ezproxy.UserSession) string {
+		return session.SessionID + "\x00" + session.Username
+	}
+
+	previousKeys := make(map[string]struct{}, len(previous))
+	for _, session := range previous {
+		previousKeys[key(session)] = struct{}{}
+	}
+
+	currentKeys := make(map[string]struct{}, len(current))
+	for _, session := range current {
+		currentKeys[key(session)] = struct{}{}
+	}
+
+	var events []SessionEvent
+
+	for _, session := range previous {
+		if _, ok := currentKeys[key(session)]; !ok {
+			events = append(events, SessionEvent{
+				Type:        SessionEnded,
+				UserSession: session,
+				Time:        now,
+			})
+		}
+	}
+
+	for _, session := range current {
+		if _, ok := previousKeys[key(session)]; !ok {
+			events = append(events, SessionEvent{
+				Type:        SessionStarted,
+				UserSession: session,
+				Time:        now,
+			})
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,287 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activefile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+const (
+	testWatchAlice = testSessionLineAlice + "\nL alice\n"
+	testWatchBob   = testSessionLineBob + "\nL bob\n"
+	testWatchCarol = "S cCcCcCcCcCcCcCc 1600000400 1600000500 1 60 192.0.2.30 0 0 0 *\nL carol\n"
+)
+
+func TestDiffSessions(t *testing.T) {
+
+	alice := ezproxy.UserSession{SessionID: "aBcDeFgHiJkLmNo", Username: "alice"}
+	bob := ezproxy.UserSession{SessionID: "pQrStUvWxYz0123", Username: "bob"}
+	carol := ezproxy.UserSession{SessionID: "cCcCcCcCcCcCcCc", Username: "carol"}
+
+	// the same session ID reused for a different user is a new session
+	aliceAsMallory := ezproxy.UserSession{SessionID: "aBcDeFgHiJkLmNo", Username: "mallory"}
+
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		previous ezproxy.UserSessions
+		current  ezproxy.UserSessions
+		want     []SessionEvent
+	}{
+		{
+			name:     "unchanged",
+			previous: ezproxy.UserSessions{alice, bob},
+			current:  ezproxy.UserSessions{bob, alice},
+		},
+		{
+			name:     "started",
+			previous: ezproxy.UserSessions{alice},
+			current:  ezproxy.UserSessions{alice, bob},
+			want:     []SessionEvent{{Type: SessionStarted, UserSession: bob, Time: now}},
+		},
+		{
+			name:     "ended before started",
+			previous: ezproxy.UserSessions{alice, bob},
+			current:  ezproxy.UserSessions{bob, carol},
+			want: []SessionEvent{
+				{Type: SessionEnded, UserSession: alice, Time: now},
+				{Type: SessionStarted, UserSession: carol, Time: now},
+			},
+		},
+		{
+			name:     "username changed",
+			previous: ezproxy.UserSessions{alice},
+			current:  ezproxy.UserSessions{aliceAsMallory},
+			want: []SessionEvent{
+				{Type: SessionEnded, UserSession: alice, Time: now},
+				{Type: SessionStarted, UserSession: aliceAsMallory, Time: now},
+			},
+		},
+		{
+			name:    "empty previous",
+			current: ezproxy.UserSessions{alice},
+			want:    []SessionEvent{{Type: SessionStarted, UserSession: alice, Time: now}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffSessions(tt.previous, tt.current, now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %v, want %d", len(got), got, len(tt.want))
+			}
+			for idx := range got {
+				if got[idx] != tt.want[idx] {
+					t.Errorf("event %d = %+v, want %+v", idx, got[idx], tt.want[idx])
+				}
+			}
+		})
+	}
+}
+
+// testWatchFile is an active users and hosts file which is replaced
+// atomically with a distinct modification time for each write.
+type testWatchFile struct {
+	t        *testing.T
+	filename string
+	modTime  time.Time
+}
+
+func newTestWatchFile(t *testing.T, content string) *testWatchFile {
+	t.Helper()
+
+	f := &testWatchFile{
+		t:        t,
+		filename: filepath.Join(t.TempDir(), "ezproxy.hst"),
+		modTime:  time.Now().Add(-time.Hour).Truncate(time.Second),
+	}
+	f.write(content)
+
+	return f
+}
+
+// write replaces the content of the file, advancing the modification time.
+func (f *testWatchFile) write(content string) {
+	f.t.Helper()
+
+	f.modTime = f.modTime.Add(time.Second)
+
+	tmp := f.filename + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		f.t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Chtimes(tmp, f.modTime, f.modTime); err != nil {
+		f.t.Fatalf("failed to set file times: %v", err)
+	}
+	if err := os.Rename(tmp, f.filename); err != nil {
+		f.t.Fatalf("failed to replace file: %v", err)
+	}
+}
+
+// startTestWatcher starts a Watcher for the given file, using the given open
+// function wrapper (if any) for every read of the file.
+func startTestWatcher(
+	t *testing.T,
+	f *testWatchFile,
+	wrap func(call int, open ezproxy.OpenFunc) (io.ReadCloser, error),
+) (ezproxy.UserSessions, <-chan SessionEvent, context.CancelFunc) {
+	t.Helper()
+
+	w, err := NewWatcher(f.filename)
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+	if err := w.SetPollInterval(5 * time.Millisecond); err != nil {
+		t.Fatalf("SetPollInterval() error = %v", err)
+	}
+
+	if wrap != nil {
+		var mu sync.Mutex
+		var calls int
+		open := w.reader.Open
+		w.reader.Open = func() (io.ReadCloser, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			return wrap(calls, open)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	sessions, events, err := w.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	return sessions, events, cancel
+}
+
+// collectEvents waits for the wanted number of events, then stops the
+// Watcher and returns every event sent.
+func collectEvents(t *testing.T, events <-chan SessionEvent, cancel context.CancelFunc, want int) []SessionEvent {
+	t.Helper()
+
+	var got []SessionEvent
+
+	timeout := time.After(5 * time.Second)
+	for len(got) < want {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got %v", got)
+		}
+	}
+
+	// allow for any unexpected events before stopping
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	for event := range events {
+		got = append(got, event)
+	}
+
+	return got
+}
+
+// eventSummary returns the event types and usernames of the given events.
+func eventSummary(events []SessionEvent) string {
+	summary := make([]string, 0, len(events))
+	for _, event := range events {
+		summary = append(summary, event.Type.String()+":"+event.Username)
+	}
+	return strings.Join(summary, ",")
+}
+
+func TestWatcherPoll(t *testing.T) {
+
+	f := newTestWatchFile(t, testWatchAlice+testWatchBob)
+
+	sessions, events, cancel := startTestWatcher(t, f, nil)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d initial sessions, want 2", len(sessions))
+	}
+
+	f.write(testWatchBob + testWatchCarol)
+
+	got := collectEvents(t, events, cancel, 2)
+	if want := "SessionEnded:alice,SessionStarted:carol"; eventSummary(got) != want {
+		t.Errorf("got events %s, want %s", eventSummary(got), want)
+	}
+}
+
+func TestWatcherIgnoresFileChangedDuringRead(t *testing.T) {
+
+	f := newTestWatchFile(t, testWatchAlice+testWatchBob)
+
+	_, events, cancel := startTestWatcher(t, f, func(call int, open ezproxy.OpenFunc) (io.ReadCloser, error) {
+		if call != 2 {
+			return open()
+		}
+
+		// the first poll reads a half-written file which EZproxy then
+		// finishes writing before the read completes
+		data, err := os.ReadFile(f.filename)
+		if err != nil {
+			return nil, err
+		}
+		f.write(testWatchAlice + testWatchBob + testWatchCarol)
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+
+	f.write(testWatchAlice)
+
+	got := collectEvents(t, events, cancel, 1)
+	if want := "SessionStarted:carol"; eventSummary(got) != want {
+		t.Errorf("got events %s, want %s", eventSummary(got), want)
+	}
+}
+
+func TestWatcherRetriesAfterReadError(t *testing.T) {
+
+	f := newTestWatchFile(t, testWatchAlice)
+
+	_, events, cancel := startTestWatcher(t, f, func(call int, open ezproxy.OpenFunc) (io.ReadCloser, error) {
+		if call == 2 {
+			return nil, errors.New("transient read error")
+		}
+		return open()
+	})
+
+	// the file does not change again after the failed read
+	f.write(testWatchAlice + testWatchBob)
+
+	got := collectEvents(t, events, cancel, 1)
+	if want := "SessionStarted:bob"; eventSummary(got) != want {
+		t.Errorf("got events %s, want %s", eventSummary(got), want)
+	}
+}
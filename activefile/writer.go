@@ -0,0 +1,311 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activefile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// defaultSessionTrailingFields is the list of trailing fields written for
+// session lines which do not specify any. These are the values commonly
+// recorded by EZproxy.
+var defaultSessionTrailingFields = []string{"0", "0", "0", "*"}
+
+// Writer is the API for writing values in the format of an active users and
+// hosts file. This is intended for generating realistic files for testing
+// purposes without using copies of production files.
+type Writer interface {
+
+	// WriteState writes the given State value in the format of an active
+	// users and hosts file.
+	WriteState(state *State) error
+}
+
+// activeFileWriter represents a writer specific to the EZProxy active users
+// and hosts file.
+type activeFileWriter struct {
+	w io.Writer
+}
+
+// NewWriter creates a new instance of a Writer which writes to the provided
+// io.Writer.
+//
+// Lines other than host and session lines (e.g., P and M lines) are written
+// first, followed by host lines and then session lines. Each session is
+// written as a session line followed by its session detail lines, login line
+// and group line, an ordering supported by the Reader. Reading the output
+// with a Reader results in a State value equal to the one written (aside from
+// line numbers), provided that the host Fields and session TrailingFields
+// values are set as they are for State values returned by a Reader. If not
+// set, the host fields are generated from the ProxyPort and URL values and
+// the session trailing fields default to "0 0 0 *".
+func NewWriter(w io.Writer) (Writer, error) {
+
+	if w == nil {
+		return nil, errors.New("func NewWriter: missing writer")
+	}
+
+	return &activeFileWriter{w: w}, nil
+}
+
+// WriteFile writes the given State value to the specified file in the format
+// of an active users and hosts file. The file is created if needed or
+// truncated if it already exists.
+func WriteFile(filename string, state *State) error {
+
+	if filename == "" {
+		return errors.New("func WriteFile: missing filename")
+	}
+
+	ezproxy.Logger.Printf(
+		"WriteFile: Attempting to create sanitized version of file %q\n",
+		filepath.Clean(filename),
+	)
+
+	f, err := os.OpenFile(filepath.Clean(filename), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("func WriteFile: error encountered creating file %q: %w", filename, err)
+	}
+
+	// #nosec G307
+	// Believed to be a false-positive from recent gosec release
+	// https://github.com/securego/gosec/issues/714
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Ignore "file already closed" errors
+			if !errors.Is(err, os.ErrClosed) {
+				ezproxy.Logger.Printf(
+					"WriteFile: failed to close file %q: %s",
+					filename,
+					err.Error(),
+				)
+			}
+		}
+	}()
+
+	writer, err := NewWriter(f)
+	if err != nil {
+		return fmt.Errorf("func WriteFile: %w", err)
+	}
+
+	if err := writer.WriteState(state); err != nil {
+		return fmt.Errorf("func WriteFile: failed to write file %q: %w", filename, err)
+	}
+
+	// explicitly close file, bail if failure occurs
+	if err := f.Close(); err != nil {
+		return fmt.Errorf(
+			"func WriteFile: failed to close file %q: %w",
+			filename,
+			err,
+		)
+	}
+
+	return nil
+}
+
+// WriteState writes the given State value in the format of an active users
+// and hosts file. An error is returned without writing anything if the State
+// value cannot be written in a form which would be read back unchanged.
+func (afw *activeFileWriter) WriteState(state *State) error {
+
+	if state == nil {
+		return errors.New("func WriteState: missing state")
+	}
+
+	lines, err := formatState(state)
+	if err != nil {
+		return fmt.Errorf("func WriteState: %w", err)
+	}
+
+	bw := bufio.NewWriter(afw.w)
+	for _, line := range lines {
+		if _, err := bw.WriteString(line + "\n"); err != nil {
+			return fmt.Errorf("func WriteState: failed to write line: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("func WriteState: failed to flush output: %w", err)
+	}
+
+	ezproxy.Logger.Printf(
+		"Wrote %d hosts, %d sessions and %d other lines\n",
+		len(state.Hosts),
+		len(state.Sessions),
+		len(state.Other),
+	)
+
+	return nil
+}
+
+// formatState converts the given State value to the lines of an active users
+// and hosts file.
+func formatState(state *State) ([]string, error) {
+
+	lines := make([]string, 0, len(state.Other)+len(state.Hosts)+len(state.Sessions)*3)
+
+	for idx := range state.Other {
+		line := state.Other[idx]
+		switch line.Type {
+		case SessionLinePrefix, UsernameLinePrefix, GroupLinePrefix,
+			SessionDetailLinePrefix, HostLinePrefix:
+			return nil, fmt.Errorf(
+				"other line %d has type %q which is not written as an other line",
+				idx,
+				line.Type,
+			)
+		}
+		text, err := formatLine(line.Type, line.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid other line %d: %w", idx, err)
+		}
+		lines = append(lines, text)
+	}
+
+	for idx := range state.Hosts {
+		text, err := formatHost(state.Hosts[idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %d: %w", idx, err)
+		}
+		lines = append(lines, text)
+	}
+
+	for idx := range state.Sessions {
+		sessionLines, err := formatSession(state.Sessions[idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid session %d: %w", idx, err)
+		}
+		lines = append(lines, sessionLines...)
+	}
+
+	return lines, nil
+}
+
+// formatHost converts the given Host value to a host line.
+func formatHost(host Host) (string, error) {
+
+	fields := host.Fields
+	if len(fields) == 0 {
+		if host.URL == "" {
+			return "", errors.New("missing fields or URL")
+		}
+		fields = []string{strconv.Itoa(host.ProxyPort), host.URL}
+	}
+
+	return formatLine(HostLinePrefix, fields)
+}
+
+// formatSession converts the given Session value to a session line followed
+// by session detail, login and group lines.
+func formatSession(session Session) ([]string, error) {
+
+	if session.Username == "" {
+		return nil, fmt.Errorf("missing username for session %q", session.SessionID)
+	}
+
+	if session.IPAddress == "" {
+		return nil, fmt.Errorf("missing IP Address for session %q", session.SessionID)
+	}
+
+	lastAccessed := strconv.FormatInt(session.LastAccessed, 10)
+	if session.LastAccessedSecondary != 0 {
+		lastAccessed += "." + strconv.FormatInt(session.LastAccessedSecondary, 10)
+	}
+
+	trailing := session.TrailingFields
+	if len(trailing) == 0 {
+		trailing = defaultSessionTrailingFields
+	}
+
+	fields := append([]string{
+		session.SessionID,
+		strconv.FormatInt(session.Created, 10),
+		lastAccessed,
+		strconv.Itoa(session.Field5),
+		strconv.Itoa(session.MaxLifetime),
+		session.IPAddress,
+	}, trailing...)
+
+	sessionLine, err := formatLine(SessionLinePrefix, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{sessionLine}
+
+	for _, detail := range session.Details {
+		if detail.Type != SessionDetailLinePrefix {
+			return nil, fmt.Errorf(
+				"session detail line for session %q has type %q",
+				session.SessionID,
+				detail.Type,
+			)
+		}
+		text, err := formatLine(SessionDetailLinePrefix, detail.Fields)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, text)
+	}
+
+	loginLine, err := formatLine(UsernameLinePrefix, []string{session.Username})
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, loginLine)
+
+	if len(session.Groups) > 0 {
+		groupLine, err := formatLine(GroupLinePrefix, session.Groups)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, groupLine)
+	}
+
+	return lines, nil
+}
+
+// formatLine joins the given line type and fields, returning an error if any
+// value would not be read back unchanged.
+func formatLine(lineType string, fields []string) (string, error) {
+
+	if lineType == "" || strings.ContainsAny(lineType, " \t\r\n") {
+		return "", fmt.Errorf("invalid line type %q", lineType)
+	}
+
+	for _, field := range fields {
+		if field == "" || strings.ContainsAny(field, " \t\r\n") {
+			return "", fmt.Errorf(
+				"invalid field %q for %s line; fields must be non-empty and must not contain whitespace",
+				field,
+				lineType,
+			)
+		}
+	}
+
+	return strings.Join(append([]string{lineType}, fields...), activeFileFieldDelimiter), nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activefile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// newTestState returns a State value with every kind of line, with the
+// values set as they would be by a Reader (aside from line numbers).
+func newTestState() *State {
+	return &State{
+		Other: []Line{
+			{Type: PLinePrefix, Fields: []string{"1", "2"}},
+			{Type: MLinePrefix, Fields: []string{"abc"}},
+		},
+		Hosts: []Host{
+			{
+				Fields:    []string{"2050", "1600000000", "https://www.jstor.org"},
+				URL:       "https://www.jstor.org",
+				Hostname:  "www.jstor.org",
+				Port:      443,
+				ProxyPort: 2050,
+			},
+			{
+				Fields:          []string{"0", "http://example.com:8080"},
+				URL:             "http://example.com:8080",
+				Hostname:        "example.com",
+				Port:            8080,
+				ProxyByHostname: true,
+			},
+		},
+		Sessions: []Session{
+			{
+				SessionID:             "aBcDeFgHiJkLmNo",
+				Created:               1600000000,
+				LastAccessed:          1600000100,
+				LastAccessedSecondary: 1600000050,
+				Field5:                1,
+				MaxLifetime:           120,
+				IPAddress:             "192.0.2.10",
+				TrailingFields:        []string{"0", "0", "0", "*"},
+				Username:              "alice",
+				Groups:                []string{"Default", "Staff"},
+				Details: []Line{
+					{Type: SessionDetailLinePrefix, Fields: []string{"x", "y"}},
+				},
+			},
+			{
+				SessionID:      "pQrStUvWxYz0123",
+				Created:        1600000200,
+				LastAccessed:   1600000300,
+				MaxLifetime:    60,
+				IPAddress:      "2001:db8::1",
+				TrailingFields: []string{"1", "2"},
+				Username:       "bob",
+			},
+		},
+	}
+}
+
+// clearLineNumbers returns a copy of the given State value without line
+// numbers so that it can be compared with the State value written.
+func clearLineNumbers(state *State) *State {
+	cleared := *state
+	cleared.Other = append([]Line(nil), state.Other...)
+	cleared.Hosts = append([]Host(nil), state.Hosts...)
+	cleared.Sessions = append([]Session(nil), state.Sessions...)
+
+	for idx := range cleared.Other {
+		cleared.Other[idx].Number = 0
+	}
+	for idx := range cleared.Hosts {
+		cleared.Hosts[idx].Number = 0
+	}
+	for idx := range cleared.Sessions {
+		cleared.Sessions[idx].Number = 0
+		details := append([]Line(nil), cleared.Sessions[idx].Details...)
+		for i := range details {
+			details[i].Number = 0
+		}
+		cleared.Sessions[idx].Details = details
+	}
+
+	return &cleared
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+
+	want := newTestState()
+
+	var buf bytes.Buffer
+	writer, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	if err := writer.WriteState(want); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+	written := buf.String()
+
+	for _, lenient := range []bool{false, true} {
+
+		got, err := ReadState(bytes.NewBufferString(written), lenient)
+		if err != nil {
+			t.Fatalf("lenient=%t: failed to read state: %v", lenient, err)
+		}
+
+		if len(got.Warnings) != 0 {
+			t.Errorf("lenient=%t: unexpected warnings: %v", lenient, got.Warnings)
+		}
+		got.Warnings = nil
+
+		if !reflect.DeepEqual(clearLineNumbers(got), want) {
+			t.Errorf("lenient=%t: state read back differs\ngot:  %+v\nwant: %+v", lenient, clearLineNumbers(got), want)
+		}
+
+		// Writing the State value read back must produce identical output.
+		var rewritten bytes.Buffer
+		if err := (&activeFileWriter{w: &rewritten}).WriteState(got); err != nil {
+			t.Fatalf("lenient=%t: failed to write state read back: %v", lenient, err)
+		}
+		if rewritten.String() != written {
+			t.Errorf("lenient=%t: rewritten output differs\ngot:\n%s\nwant:\n%s", lenient, rewritten.String(), written)
+		}
+	}
+}
+
+func TestWriterRoundTripUserSessions(t *testing.T) {
+
+	want := newTestState()
+
+	var buf bytes.Buffer
+	if err := (&activeFileWriter{w: &buf}).WriteState(want); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+
+	for _, lenient := range []bool{false, true} {
+
+		reader, err := NewReaderFrom("", bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		reader.SetLenient(lenient)
+
+		got, err := reader.AllUserSessions()
+		if err != nil {
+			t.Fatalf("lenient=%t: failed to read user sessions: %v", lenient, err)
+		}
+
+		wantSessions := want.UserSessions()
+		if !reflect.DeepEqual(got, wantSessions) {
+			t.Errorf("lenient=%t: user sessions read back differ\ngot:  %+v\nwant: %+v", lenient, got, wantSessions)
+		}
+	}
+}
+
+func TestWriterDefaults(t *testing.T) {
+
+	state := &State{
+		Hosts: []Host{{URL: "https://www.jstor.org", ProxyPort: 2050}},
+		Sessions: []Session{{
+			SessionID: "aBcDeFgHiJkLmNo",
+			IPAddress: "192.0.2.10",
+			Username:  "alice",
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := (&activeFileWriter{w: &buf}).WriteState(state); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+
+	got, err := ReadState(&buf, false)
+	if err != nil {
+		t.Fatalf("failed to read state: %v", err)
+	}
+
+	if !reflect.DeepEqual(got.Hosts[0].Fields, []string{"2050", "https://www.jstor.org"}) {
+		t.Errorf("got host fields %q", got.Hosts[0].Fields)
+	}
+	if !reflect.DeepEqual(got.Sessions[0].TrailingFields, defaultSessionTrailingFields) {
+		t.Errorf("got session trailing fields %q", got.Sessions[0].TrailingFields)
+	}
+}
+
+func TestWriterRejectsInvalidState(t *testing.T) {
+
+	tests := map[string]*State{
+		"nil state":        nil,
+		"missing username": {Sessions: []Session{{SessionID: "a", IPAddress: "192.0.2.1"}}},
+		"missing IP":       {Sessions: []Session{{SessionID: "a", Username: "alice"}}},
+		"space in field":   {Sessions: []Session{{SessionID: "a", IPAddress: "192.0.2.1", Username: "alice smith"}}},
+		"empty group":      {Sessions: []Session{{SessionID: "a", IPAddress: "192.0.2.1", Username: "alice", Groups: []string{""}}}},
+		"session as other": {Other: []Line{{Type: SessionLinePrefix, Fields: []string{"a"}}}},
+		"host without URL": {Hosts: []Host{{ProxyPort: 2050}}},
+	}
+
+	for name, state := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (&activeFileWriter{w: &buf}).WriteState(state); err == nil {
+				t.Error("expected error")
+			}
+			if buf.Len() != 0 {
+				t.Errorf("unexpected output %q", buf.String())
+			}
+		})
+	}
+}
@@ -17,16 +17,15 @@
 package auditlog
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/atc0005/go-ezproxy"
-	"github.com/atc0005/go-ezproxy/internal/textutils"
+	"github.com/atc0005/go-ezproxy/internal/ctxutils"
 )
 
 // These are the events in the audit log applicable to this package.
@@ -83,6 +82,16 @@ type SessionEntry struct {
 
 	// SessionID is the session ID associated with an entry in the audit file
 	SessionID string
+
+	// IPChanges is the collection of IP Address changes recorded for the
+	// session after the initial login, in the order that they were
+	// recorded. The IPAddress field reflects the most recent IP Address.
+	IPChanges IPChanges
+
+	// LoginIPAddress is the IP Address used by the session at login. If the
+	// login was not recorded in the events processed, this is the earliest
+	// IP Address known for the session (if any).
+	LoginIPAddress string
 }
 
 // SessionEntries is a collection of SessionEntry values that is intended for
@@ -111,13 +120,19 @@ type auditLogReader struct {
 	Username string
 
 	// Filename is the name of the file which will be parsed/searched for the
-	// specified username.
+	// specified username. For input not read from a file this is a
+	// descriptive name used for logging purposes.
 	Filename string
+
+	// Open opens the input which will be parsed/searched for the specified
+	// username. This is called for each read attempt.
+	Open ezproxy.OpenFunc
 }
 
 // AuditReader is the API for retrieving values from an audit log file
 type AuditReader interface {
-	ezproxy.SessionsReader
+	ezproxy.SessionsReaderContext
+	ezproxy.SearchSettings
 
 	// MatchingSessionEntries uses the previously provided username as a
 	// search key, the previously provided filename to search through and
@@ -125,17 +140,34 @@ type AuditReader interface {
 	// specified audit file for that username.
 	MatchingSessionEntries() (SessionEntries, error)
 
+	// MatchingSessionEntriesContext behaves like MatchingSessionEntries,
+	// returning early with the context error if the provided context is
+	// done (e.g., while delaying between search attempts).
+	MatchingSessionEntriesContext(ctx context.Context) (SessionEntries, error)
+
 	// AllSessionEntries uses the previously provided filename to search
 	// through and return a slice of SessionEntry values which reflect ALL
 	// session-related events. The SessionEntry values returned are NOT
 	// filtered to a specific username.
 	AllSessionEntries() (SessionEntries, error)
+
+	// AllEvents uses the previously provided filename to return a slice of
+	// Event values which reflect ALL events recorded in the audit log,
+	// including those not related to user sessions. The Event values
+	// returned are NOT filtered to a specific username.
+	AllEvents() (Events, error)
+
+	// MatchingEvents uses the previously provided username as a search key
+	// and returns a slice of Event values which reflect all events in the
+	// specified audit file for that username.
+	MatchingEvents() (Events, error)
 }
 
 // AllSessionEntries uses the previously provided filename to search
 // through and return a slice of SessionEntry values which reflect ALL
 // session-related events. The SessionEntry values returned are NOT
-// filtered to a specific username.
+// filtered to a specific username. Each SessionEntry reflects the current IP
+// Address for the session along with any recorded IP Address changes.
 func (alr auditLogReader) AllSessionEntries() (SessionEntries, error) {
 
 	// These are events that contain relevant details for our work
@@ -148,134 +180,17 @@ func (alr auditLogReader) AllSessionEntries() (SessionEntries, error) {
 		EventLogout,
 	}
 
-	ezproxy.Logger.Printf(
-		"AllSessionEntries: Request to open %q received\n",
-		alr.Filename,
-	)
-	ezproxy.Logger.Printf(
-		"AllSessionEntries: Attempting to open sanitized version of file %q\n",
-		filepath.Clean(alr.Filename),
-	)
-
-	f, err := os.Open(filepath.Clean(alr.Filename))
+	allEvents, err := alr.AllEvents()
 	if err != nil {
-		return nil, fmt.Errorf("func AllSessionEntries: error encountered opening file %q: %w", alr.Filename, err)
-	}
-
-	// #nosec G307
-	// Believed to be a false-positive from recent gosec release
-	// https://github.com/securego/gosec/issues/714
-	defer func() {
-		if err := f.Close(); err != nil {
-			// Ignore "file already closed" errors
-			if !errors.Is(err, os.ErrClosed) {
-				ezproxy.Logger.Printf(
-					"AllSessionEntries: failed to close file %q: %s",
-					alr.Filename,
-					err.Error(),
-				)
-			}
-		}
-	}()
-
-	ezproxy.Logger.Printf("Searching for: %q\n", alr.Username)
-
-	s := bufio.NewScanner(f)
-	var lineno int
-
-	var logoutEvents []SessionEntry
-
-	userSessionIDsIndex := make(map[string]SessionEntry, ezproxy.SessionsLimit)
-
-	// TODO: Does Scan() perform any whitespace manipulation already?
-	for s.Scan() {
-		lineno++
-		currentLine := s.Text()
-		ezproxy.Logger.Printf("Scanned line %d from %q: %q\n",
-			lineno,
-			alr.Filename,
-			currentLine,
-		)
-
-		currentLine = strings.TrimSpace(currentLine)
-		ezproxy.Logger.Printf(
-			"Line %d from %q after whitespace removal: %q\n",
-			lineno,
-			alr.Filename,
-			currentLine,
-		)
-
-		auditFileEntry := strings.Split(currentLine, "\t")
-		if len(auditFileEntry) < EventMinFieldLength {
-			continue
-		}
-
-		// Event field is the second field, so 1 in a zero-based array/slice
-		if !textutils.InList(auditFileEntry[1], validEvents) {
-			continue
-		}
-
-		// at this point we are dealing with one of these events:
-		//
-		// Logout
-		// Login.Success
-		// Login.Success.Relogin
-
-		if strings.EqualFold(auditFileEntry[1], EventLogout) {
-
-			logoutEvents = append(logoutEvents, SessionEntry{
-				Datestamp: auditFileEntry[0],
-				Event:     auditFileEntry[1],
-				IPAddress: "",
-				Username:  auditFileEntry[2],
-				SessionID: auditFileEntry[3],
-			})
-
-			continue
-		}
-
-		// at this point we're only dealing with these events:
-		//
-		// Login.Success
-		// Login.Success.Relogin
-		userSessionIDsIndex[auditFileEntry[3]] = SessionEntry{
-			Datestamp: auditFileEntry[0],
-			Event:     auditFileEntry[1],
-			IPAddress: auditFileEntry[2],
-			Username:  auditFileEntry[3],
-			SessionID: auditFileEntry[4],
-		}
-	}
-
-	ezproxy.Logger.Println("Exited s.Scan() loop")
-
-	// report any errors encountered while scanning the input file
-	if err := s.Err(); err != nil {
-		return nil, fmt.Errorf("func AllSessionEntries: errors encountered while scanning the input file: %w", err)
-	}
-
-	// Loop over logoutEvents, remove matching entries from the
-	// userSessionIDsIndex map
-	for _, loggedOutSession := range logoutEvents {
-		delete(userSessionIDsIndex, loggedOutSession.SessionID)
-	}
-
-	// Convert our userSessionIDsIndex map
-	userSessions := make(SessionEntries, 0, ezproxy.SessionsLimit)
-	for _, entry := range userSessionIDsIndex {
-		userSessions = append(userSessions, entry)
-	}
-
-	// explicitly close file, bail if failure occurs
-	if err := f.Close(); err != nil {
 		return nil, fmt.Errorf(
-			"func AllSessionEntries: failed to close file %q: %w",
-			alr.Filename,
+			"func AllSessionEntries: failed to retrieve all events in order to generate session entries: %w",
 			err,
 		)
 	}
 
-	return userSessions, nil
+	ezproxy.Logger.Printf("Searching for: %q\n", alr.Username)
+
+	return allEvents.MatchingTypes(validEvents...).SessionEntries(), nil
 
 }
 
@@ -283,6 +198,21 @@ func (alr auditLogReader) AllSessionEntries() (SessionEntries, error) {
 // key and returns a slice of SessionEntry values which reflect entries in the
 // specified audit file for that username.
 func (alr auditLogReader) MatchingSessionEntries() (SessionEntries, error) {
+	return alr.MatchingSessionEntriesContext(context.Background())
+}
+
+// MatchingSessionEntriesContext behaves like MatchingSessionEntries,
+// returning early with the context error if the provided context is done
+// (e.g., while delaying between search attempts).
+func (alr auditLogReader) MatchingSessionEntriesContext(ctx context.Context) (SessionEntries, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func MatchingSessionEntriesContext: missing context")
+	}
+
+	if alr.Username == "" {
+		return nil, errors.New("func MatchingSessionEntries: missing username")
+	}
 
 	ezproxy.Logger.Printf("Searching for: %q\n", alr.Username)
 
@@ -306,7 +236,9 @@ func (alr auditLogReader) MatchingSessionEntries() (SessionEntries, error) {
 			"Intentionally delaying for %v to help avoid race condition due to delayed EZproxy writes\n",
 			alr.SearchDelay,
 		)
-		time.Sleep(alr.SearchDelay)
+		if err := ctxutils.Sleep(ctx, alr.SearchDelay); err != nil {
+			return nil, fmt.Errorf("func SessionEntries: search for %q cancelled: %w", alr.Username, err)
+		}
 
 		allSessionEntries, err := alr.AllSessionEntries()
 		if err != nil {
@@ -368,6 +300,36 @@ func (alr auditLogReader) AllUserSessions() (ezproxy.UserSessions, error) {
 // of all matching session IDs along with their associated IP Address in the
 // form of a slice of UserSession values.
 func (alr auditLogReader) MatchingUserSessions() (ezproxy.UserSessions, error) {
+	return alr.MatchingUserSessionsContext(context.Background())
+}
+
+// AllUserSessionsContext behaves like AllUserSessions, returning early with
+// the context error if the provided context is done.
+func (alr auditLogReader) AllUserSessionsContext(ctx context.Context) (ezproxy.UserSessions, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func AllUserSessionsContext: missing context")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("func AllUserSessionsContext: %w", err)
+	}
+
+	return alr.AllUserSessions()
+}
+
+// MatchingUserSessionsContext behaves like MatchingUserSessions, returning
+// early with the context error if the provided context is done (e.g., while
+// delaying between search attempts).
+func (alr auditLogReader) MatchingUserSessionsContext(ctx context.Context) (ezproxy.UserSessions, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func MatchingUserSessionsContext: missing context")
+	}
+
+	if alr.Username == "" {
+		return nil, errors.New("func MatchingUserSessions: missing username")
+	}
 
 	ezproxy.Logger.Printf("Searching for: %q\n", alr.Username)
 
@@ -391,10 +353,12 @@ func (alr auditLogReader) MatchingUserSessions() (ezproxy.UserSessions, error) {
 			"Intentionally delaying for %v to help avoid race condition due to delayed EZproxy writes\n",
 			alr.SearchDelay,
 		)
-		time.Sleep(alr.SearchDelay)
+		if err := ctxutils.Sleep(ctx, alr.SearchDelay); err != nil {
+			return nil, fmt.Errorf("func UserSessions: search for %q cancelled: %w", alr.Username, err)
+		}
 
 		var err error
-		sessionEntries, err = alr.MatchingSessionEntries()
+		sessionEntries, err = alr.MatchingSessionEntriesContext(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("func UserSessions: unable to convert audit log session entries to user sessions: %w", err)
 		}
@@ -443,20 +407,44 @@ func (se SessionEntries) UserSessions() ezproxy.UserSessions {
 
 }
 
+// inputName is the descriptive name used for logging purposes for input not
+// read from a file.
+const inputName string = "input"
+
 // NewReader creates a new instance of an AuditReader that provides access to
 // collections of user sessions and audit log session entries specific to the
-// specified username.
+// specified username. The username is optional if only the methods which
+// return values for ALL usernames are used.
 func NewReader(username string, filename string) (AuditReader, error) {
 
-	if username == "" {
+	if filename == "" {
 		return nil, errors.New(
-			"func NewReader: missing username",
+			"func NewReader: missing filename",
 		)
 	}
 
-	if filename == "" {
+	reader := auditLogReader{
+		SearchDelay:   ezproxy.DefaultSearchDelay,
+		SearchRetries: ezproxy.DefaultSearchRetries,
+		Username:      username,
+		Filename:      filename,
+		Open:          ezproxy.FileOpener(filename),
+	}
+
+	return &reader, nil
+
+}
+
+// NewReaderFunc creates a new instance of an AuditReader that provides access
+// to collections of user sessions and audit log session entries specific to
+// the specified username using the content provided by the given OpenFunc.
+// The OpenFunc is called for each read attempt. The username is optional if
+// only the methods which return values for ALL usernames are used.
+func NewReaderFunc(username string, open ezproxy.OpenFunc) (AuditReader, error) {
+
+	if open == nil {
 		return nil, errors.New(
-			"func NewReader: missing filename",
+			"func NewReaderFunc: missing open function",
 		)
 	}
 
@@ -464,11 +452,36 @@ func NewReader(username string, filename string) (AuditReader, error) {
 		SearchDelay:   ezproxy.DefaultSearchDelay,
 		SearchRetries: ezproxy.DefaultSearchRetries,
 		Username:      username,
-		Filename:      filename,
+		Filename:      inputName,
+		Open:          open,
 	}
 
 	return &reader, nil
+}
+
+// NewReaderFrom creates a new instance of an AuditReader that provides access
+// to collections of user sessions and audit log session entries specific to
+// the specified username using the content provided by the given io.Reader.
+// The content is streamed from the reader on each read attempt; unless the
+// reader can be rewound (see ezproxy.ReaderOpener), only one read attempt can
+// be made. As the content does not change, search retries and the delay
+// between search attempts are disabled by default. The username is optional
+// if only the methods which return values for ALL usernames are used.
+func NewReaderFrom(username string, r io.Reader) (AuditReader, error) {
+
+	if r == nil {
+		return nil, errors.New(
+			"func NewReaderFrom: missing reader",
+		)
+	}
 
+	reader := auditLogReader{
+		Username: username,
+		Filename: inputName,
+		Open:     ezproxy.ReaderOpener(r),
+	}
+
+	return &reader, nil
 }
 
 // SetSearchRetries is a helper method for setting the number of additional
@@ -494,3 +507,9 @@ func (alr *auditLogReader) SetSearchDelay(delay int) error {
 
 	return nil
 }
+
+// SearchSettings returns the number of additional search attempts and the
+// delay between search attempts.
+func (alr auditLogReader) SearchSettings() (int, time.Duration) {
+	return alr.SearchRetries, alr.SearchDelay
+}
@@ -36,10 +36,13 @@ use the audit log files as a source of active user session details. Instead,
 we have to parse the Active Users and Hosts "state" file for that information.
 
 Even so, the audit log files contain event-based data that is of potential use
-to security-related applications in the future. Depending on the need, this
-library may be further updated to expose those details to client applications
-(e.g., number of failed login attempts and whether a set of failed login
-attempts eventually resulted in a successful login).
+to security-related applications. The AnalyzeLogins function groups failed
+login events by username and by source IP Address within sliding windows in
+order to flag password spraying (one IP Address, many usernames), brute force
+attempts (one username, many failures) and bursts of failed login attempts
+which eventually resulted in a successful login. Each finding carries the
+SessionEntry values for those successful logins so that the associated
+sessions can be terminated.
 
 # Field Types
 
@@ -55,7 +58,47 @@ the field names below are taken directly from a real audit log file.
 05) Session
 06) Other
 
-Currently we only deal with the first 5 fields.
+The SessionEntry values used to work with user sessions only make use of the
+first 5 fields. Event values reflect all 6 fields for every event type
+recorded in the audit log (e.g., Login.Failure, Login.Denied and System
+events), along with a parsed timestamp.
+
+The Logout event does not record an IP Address; the Username and Session
+fields are shifted left by one position for this event type.
+
+The Session.IPChange event records the new IP Address in the IP field and the
+previous IP Address in the Other field. These events are modeled by the
+IPChange type; SessionEntry values report the current IP Address for a
+session along with the full history of IP Address changes.
+
+The DetectIPHopping function uses this history (including the history of
+sessions which have since been logged out) to flag sessions whose IP Address
+changes too often or which move between networks too quickly. If an
+offline geolocation database is provided by the caller (see the ezproxy/geoip
+package), sessions which move between distant locations faster than is
+physically possible (impossible travel) are also flagged.
+
+# Following Audit Logs
+
+EZproxy writes one audit log file per day (e.g., 20200925.txt). A Follower
+created via NewFollower tails the current audit log file and streams newly
+recorded events over a channel for near-real-time alerting. Once the current
+file has been read in full, the Follower switches to the next daily audit log
+file in the same directory if one exists, reading the current file once more
+before switching. If the current file is replaced or truncated, it is read
+again from the start. If the current file is removed and there is no newer
+file, an error is logged at each poll. Each event is sent along with the
+position just after it; the caller may save this position and provide it to
+a new Follower via SetOffset in order to resume after a restart. The
+LatestFile function returns the newest daily audit log file in a directory.
+
+# Reading Audit History
+
+A HistoryReader created via NewHistoryReader reads the daily audit log files
+in a directory (including compressed copies, e.g., 20200925.txt.gz) which
+cover a span of time. Events from all of the files are merged in
+chronological order and session state is reconstructed across the files, so
+a login recorded on one day is paired with a logout recorded on the next.
 
 # Race Condition
 
@@ -0,0 +1,89 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"github.com/atc0005/go-ezproxy/geoip"
+)
+
+// EnrichedEvent is an Event along with the details recorded for the IP
+// Address of the event.
+type EnrichedEvent struct {
+	Event
+	geoip.Details
+
+	// Error is the error (if any) from the attempt to resolve the IP
+	// Address. geoip.ErrNotFound is recorded if the database has no record
+	// for the IP Address or if the event does not record an IP Address.
+	Error error
+}
+
+// EnrichedEvents is a collection of EnrichedEvent values that is intended
+// for aggregation before bulk processing of some kind.
+type EnrichedEvents []EnrichedEvent
+
+// EnrichedSessionEntry is a SessionEntry along with the details recorded for
+// the current IP Address of the session.
+type EnrichedSessionEntry struct {
+	SessionEntry
+	geoip.Details
+
+	// Error is the error (if any) from the attempt to resolve the IP
+	// Address. geoip.ErrNotFound is recorded if the database has no record
+	// for the IP Address.
+	Error error
+}
+
+// EnrichedSessionEntries is a collection of EnrichedSessionEntry values that
+// is intended for aggregation before bulk processing of some kind.
+type EnrichedSessionEntries []EnrichedSessionEntry
+
+// Enrich resolves the details for the IP Address of each event using the
+// provided offline database.
+func (e Events) Enrich(resolver geoip.Resolver) EnrichedEvents {
+
+	enriched := make(EnrichedEvents, 0, len(e))
+
+	for idx := range e {
+		details, err := geoip.ResolveIPAddress(resolver, e[idx].IPAddress)
+		enriched = append(enriched, EnrichedEvent{
+			Event:   e[idx],
+			Details: details,
+			Error:   err,
+		})
+	}
+
+	return enriched
+}
+
+// Enrich resolves the details for the current IP Address of each session
+// entry using the provided offline database.
+func (se SessionEntries) Enrich(resolver geoip.Resolver) EnrichedSessionEntries {
+
+	enriched := make(EnrichedSessionEntries, 0, len(se))
+
+	for idx := range se {
+		details, err := geoip.ResolveIPAddress(resolver, se[idx].IPAddress)
+		enriched = append(enriched, EnrichedSessionEntry{
+			SessionEntry: se[idx],
+			Details:      details,
+			Error:        err,
+		})
+	}
+
+	return enriched
+}
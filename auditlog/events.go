@@ -0,0 +1,358 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+	"github.com/atc0005/go-ezproxy/internal/decompress"
+	"github.com/atc0005/go-ezproxy/internal/textutils"
+)
+
+// These are additional events in the audit log which are not directly
+// related to user sessions.
+const (
+
+	// EventLoginFailure is recorded for a failed login attempt (e.g., an
+	// invalid password).
+	EventLoginFailure string = "Login.Failure"
+
+	// EventLoginDenied is recorded when a login attempt is refused (e.g., the
+	// user account is not permitted to login or has been blocked).
+	EventLoginDenied string = "Login.Denied"
+
+	// EventSystem is recorded for EZproxy system events (e.g., startup). These
+	// events do not have IP Address, Username or Session ID values.
+	EventSystem string = "System"
+)
+
+// These are the field positions (zero-based) for entries in the audit log.
+const (
+	fieldDatestamp int = iota
+	fieldEvent
+	fieldIPAddress
+	fieldUsername
+	fieldSessionID
+	fieldOther
+)
+
+// auditLogHeaderField is the value of the first field in the header line
+// found at the start of each audit log file.
+const auditLogHeaderField string = "Date/Time"
+
+// Event reflects any entry in a audit/YYYYMMDD.txt file. Unlike SessionEntry
+// values, Event values are returned for every event type recorded in the
+// audit log. Not all event types recorded in the audit log will have all
+// fields; fields without a recorded value are left empty.
+type Event struct {
+
+	// Time is the parsed Date/Time field of the entry in the audit file. The
+	// audit log does not record a time zone, so the local time zone is
+	// assumed.
+	Time time.Time
+
+	// Datestamp is the Date/Time field of the entry in the audit file as
+	// originally recorded.
+	Datestamp string
+
+	// Type is the event type associated with an entry in the audit file
+	// (e.g., Login.Success, Login.Failure, System).
+	Type string
+
+	// IPAddress is an IP Adddress associated with an entry in the audit file
+	IPAddress string
+
+	// Username is the username associated with an entry in the audit file
+	Username string
+
+	// SessionID is the session ID associated with an entry in the audit file
+	SessionID string
+
+	// Other is the sixth ("Other") field of an entry in the audit file. The
+	// value recorded here varies by event type (e.g., the reason for a failed
+	// login or details for a System event).
+	Other string
+
+	// LineNumber is the line number of the entry within the audit file.
+	LineNumber int
+
+	// Filename is the name of the audit file containing the entry. This is
+	// set for events read by a HistoryReader or Follower (where events may
+	// come from several files) and is empty otherwise.
+	Filename string
+}
+
+// Events is a collection of Event values that is intended for aggregation
+// before bulk processing of some kind.
+type Events []Event
+
+// ParseEvent parses a single line from an audit log file into an Event.
+func ParseEvent(line string) (Event, error) {
+
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return Event{}, errors.New("empty line")
+	}
+
+	fields := strings.Split(line, "\t")
+	for idx := range fields {
+		fields[idx] = strings.TrimSpace(fields[idx])
+	}
+
+	if len(fields) <= fieldEvent || fields[fieldEvent] == "" {
+		return Event{}, fmt.Errorf("missing event field in line %q", line)
+	}
+
+	if fields[fieldDatestamp] == auditLogHeaderField {
+		return Event{}, errors.New("header line")
+	}
+
+	t, err := time.ParseInLocation(TimeStampLayout, fields[fieldDatestamp], time.Local)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to parse timestamp %q: %w", fields[fieldDatestamp], err)
+	}
+
+	field := func(idx int) string {
+		if idx < len(fields) {
+			return fields[idx]
+		}
+		return ""
+	}
+
+	event := Event{
+		Time:      t,
+		Datestamp: fields[fieldDatestamp],
+		Type:      fields[fieldEvent],
+	}
+
+	switch event.Type {
+
+	// The Logout event does not record an IP Address; the remaining fields
+	// are shifted left by one position.
+	case EventLogout:
+		event.Username = field(fieldIPAddress)
+		event.SessionID = field(fieldUsername)
+		event.Other = field(fieldSessionID)
+
+	default:
+		event.IPAddress = field(fieldIPAddress)
+		event.Username = field(fieldUsername)
+		event.SessionID = field(fieldSessionID)
+		event.Other = strings.Join(fieldsFrom(fields, fieldOther), "\t")
+	}
+
+	return event, nil
+}
+
+// fieldsFrom returns the fields starting at the given position, or nil if
+// there are no fields at or after that position.
+func fieldsFrom(fields []string, start int) []string {
+	if start >= len(fields) {
+		return nil
+	}
+	return fields[start:]
+}
+
+// AllEvents uses the previously provided filename to return a slice of
+// Event values which reflect ALL events recorded in the audit log. The Event
+// values returned are NOT filtered to a specific username.
+func (alr auditLogReader) AllEvents() (Events, error) {
+
+	ezproxy.Logger.Printf(
+		"AllEvents: Request to open %q received\n",
+		alr.Filename,
+	)
+
+	if alr.Open == nil {
+		return nil, errors.New("func AllEvents: missing open function")
+	}
+
+	f, err := alr.Open()
+	if err != nil {
+		return nil, fmt.Errorf("func AllEvents: %w", err)
+	}
+
+	// #nosec G307
+	// Believed to be a false-positive from recent gosec release
+	// https://github.com/securego/gosec/issues/714
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Ignore "file already closed" errors
+			if !errors.Is(err, os.ErrClosed) {
+				ezproxy.Logger.Printf(
+					"AllEvents: failed to close file %q: %s",
+					alr.Filename,
+					err.Error(),
+				)
+			}
+		}
+	}()
+
+	events, err := scanEvents(f, alr.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("func AllEvents: %w", err)
+	}
+
+	// explicitly close file, bail if failure occurs
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf(
+			"func AllEvents: failed to close file %q: %w",
+			alr.Filename,
+			err,
+		)
+	}
+
+	return events, nil
+}
+
+// ReadEvents returns the events recorded in the audit log provided by the
+// given io.Reader. Compressed content is decompressed as it is read. Lines
+// which cannot be parsed as events (e.g., the header line) are skipped.
+func ReadEvents(r io.Reader) (Events, error) {
+
+	if r == nil {
+		return nil, errors.New("func ReadEvents: missing reader")
+	}
+
+	events, err := scanEvents(r, "input")
+	if err != nil {
+		return nil, fmt.Errorf("func ReadEvents: %w", err)
+	}
+
+	return events, nil
+}
+
+// scanEvents returns the events read from the provided audit log content.
+// Compressed content is decompressed as it is read. Lines which cannot be
+// parsed as events (e.g., the header line) are skipped. The name is used for
+// logging purposes only.
+func scanEvents(input io.Reader, name string) (Events, error) {
+
+	r, err := decompress.NewReader(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", name, err)
+	}
+
+	s := bufio.NewScanner(r)
+	var lineno int
+
+	events := make(Events, 0, ezproxy.AllUsersSessionsLimit)
+
+	for s.Scan() {
+		lineno++
+
+		event, parseErr := ParseEvent(s.Text())
+		if parseErr != nil {
+			ezproxy.Logger.Printf(
+				"Skipping line %d from %q: %v\n",
+				lineno,
+				name,
+				parseErr,
+			)
+			continue
+		}
+		event.LineNumber = lineno
+
+		events = append(events, event)
+	}
+
+	ezproxy.Logger.Println("Exited s.Scan() loop")
+
+	// report any errors encountered while scanning the input
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("errors encountered while scanning %q: %w", name, err)
+	}
+
+	return events, nil
+}
+
+// MatchingEvents uses the previously provided username as a search key and
+// returns a slice of Event values which reflect all events in the specified
+// audit file for that username.
+func (alr auditLogReader) MatchingEvents() (Events, error) {
+
+	if alr.Username == "" {
+		return nil, errors.New("func MatchingEvents: missing username")
+	}
+
+	allEvents, err := alr.AllEvents()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"func MatchingEvents: failed to retrieve all events in order to filter to specific username: %w",
+			err,
+		)
+	}
+
+	return allEvents.MatchingUsername(alr.Username), nil
+}
+
+// MatchingUsername returns the events associated with the specified
+// username.
+func (e Events) MatchingUsername(username string) Events {
+
+	matchingEvents := make(Events, 0, ezproxy.SessionsLimit)
+
+	for idx := range e {
+		if strings.EqualFold(e[idx].Username, username) {
+			matchingEvents = append(matchingEvents, e[idx])
+		}
+	}
+
+	return matchingEvents
+}
+
+// MatchingTypes returns the events with one of the specified event types.
+func (e Events) MatchingTypes(eventTypes ...string) Events {
+
+	matchingEvents := make(Events, 0, len(e))
+
+	for idx := range e {
+		if textutils.InList(e[idx].Type, eventTypes) {
+			matchingEvents = append(matchingEvents, e[idx])
+		}
+	}
+
+	return matchingEvents
+}
+
+// SessionEntry converts an Event value to a SessionEntry value.
+func (e Event) SessionEntry() SessionEntry {
+	return SessionEntry{
+		Datestamp:      e.Datestamp,
+		Event:          e.Type,
+		IPAddress:      e.IPAddress,
+		Username:       e.Username,
+		SessionID:      e.SessionID,
+		LoginIPAddress: e.IPAddress,
+	}
+}
+
+// UserSession converts an Event value to a UserSession value.
+func (e Event) UserSession() ezproxy.UserSession {
+	return ezproxy.UserSession{
+		SessionID: e.SessionID,
+		IPAddress: e.IPAddress,
+		Username:  e.Username,
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEvent(t *testing.T) {
+
+	tests := []struct {
+		name string
+		line string
+		want Event
+	}{
+		{
+			name: "login success",
+			line: "2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taBcDeFgHiJkLmNo\t",
+			want: Event{
+				Type:      EventLoginSuccess,
+				IPAddress: "192.0.2.10",
+				Username:  "alice",
+				SessionID: "aBcDeFgHiJkLmNo",
+			},
+		},
+		{
+			name: "relogin",
+			line: "2020-09-25 08:00:00\tLogin.Success.Relogin\t192.0.2.10\talice\taBcDeFgHiJkLmNo\t",
+			want: Event{
+				Type:      EventLoginSuccessRelogin,
+				IPAddress: "192.0.2.10",
+				Username:  "alice",
+				SessionID: "aBcDeFgHiJkLmNo",
+			},
+		},
+		{
+			name: "ip change",
+			line: "2020-09-25 08:00:00\tSession.IPChange\t198.51.100.20\talice\taBcDeFgHiJkLmNo\t192.0.2.10",
+			want: Event{
+				Type:      EventSessionIPChange,
+				IPAddress: "198.51.100.20",
+				Username:  "alice",
+				SessionID: "aBcDeFgHiJkLmNo",
+				Other:     "192.0.2.10",
+			},
+		},
+		{
+			name: "logout fields are shifted",
+			line: "2020-09-25 08:00:00\tLogout\talice\taBcDeFgHiJkLmNo\t",
+			want: Event{
+				Type:      EventLogout,
+				Username:  "alice",
+				SessionID: "aBcDeFgHiJkLmNo",
+			},
+		},
+		{
+			name: "logout without trailing tab",
+			line: "2020-09-25 08:00:00\tLogout\talice\taBcDeFgHiJkLmNo",
+			want: Event{
+				Type:      EventLogout,
+				Username:  "alice",
+				SessionID: "aBcDeFgHiJkLmNo",
+			},
+		},
+		{
+			name: "login failure",
+			line: "2020-09-25 08:00:00\tLogin.Failure\t192.0.2.10\talice\t\tInvalid password",
+			want: Event{
+				Type:      EventLoginFailure,
+				IPAddress: "192.0.2.10",
+				Username:  "alice",
+				Other:     "Invalid password",
+			},
+		},
+		{
+			name: "login denied",
+			line: "2020-09-25 08:00:00\tLogin.Denied\t192.0.2.10\tmallory\t\tDeny",
+			want: Event{
+				Type:      EventLoginDenied,
+				IPAddress: "192.0.2.10",
+				Username:  "mallory",
+				Other:     "Deny",
+			},
+		},
+		{
+			name: "system",
+			line: "2020-09-25 08:00:00\tSystem\t\t\t\tEZproxy started",
+			want: Event{
+				Type:  EventSystem,
+				Other: "EZproxy started",
+			},
+		},
+		{
+			name: "other joins remaining fields",
+			line: "2020-09-25 08:00:00\tSystem\t\t\t\tEZproxy started\tversion 7.0\tpid 123",
+			want: Event{
+				Type:  EventSystem,
+				Other: "EZproxy started\tversion 7.0\tpid 123",
+			},
+		},
+		{
+			name: "missing trailing fields",
+			line: "2020-09-25 08:00:00\tLogin.Failure\t192.0.2.10",
+			want: Event{
+				Type:      EventLoginFailure,
+				IPAddress: "192.0.2.10",
+			},
+		},
+		{
+			name: "surrounding whitespace and line ending",
+			line: "2020-09-25 08:00:00\t Login.Success \t192.0.2.10 \talice\taBcDeFgHiJkLmNo\t\r\n",
+			want: Event{
+				Type:      EventLoginSuccess,
+				IPAddress: "192.0.2.10",
+				Username:  "alice",
+				SessionID: "aBcDeFgHiJkLmNo",
+			},
+		},
+	}
+
+	wantTime := time.Date(2020, time.September, 25, 8, 0, 0, 0, time.Local)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEvent(tt.line)
+			if err != nil {
+				t.Fatalf("ParseEvent() error = %v", err)
+			}
+
+			tt.want.Time = wantTime
+			tt.want.Datestamp = "2020-09-25 08:00:00"
+
+			if !got.Time.Equal(tt.want.Time) {
+				t.Errorf("Time = %v, want %v", got.Time, tt.want.Time)
+			}
+			got.Time = tt.want.Time
+
+			if got != tt.want {
+				t.Errorf("ParseEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEventMalformed(t *testing.T) {
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"empty", ""},
+		{"whitespace", " \t \r\n"},
+		{"header", "Date/Time\tEvent\tIP\tUsername\tSession\tOther"},
+		{"missing event field", "2020-09-25 08:00:00"},
+		{"empty event field", "2020-09-25 08:00:00\t\t192.0.2.10\talice\taBcDeFgHiJkLmNo\t"},
+		{"invalid timestamp", "25/09/2020 08:00\tLogin.Success\t192.0.2.10\talice\taBcDeFgHiJkLmNo\t"},
+		{"missing timestamp", "\tLogin.Success\t192.0.2.10\talice\taBcDeFgHiJkLmNo\t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if event, err := ParseEvent(tt.line); err == nil {
+				t.Errorf("ParseEvent() = %+v, want error", event)
+			}
+		})
+	}
+}
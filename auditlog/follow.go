@@ -0,0 +1,517 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// DefaultPollInterval is the default delay between checks for new audit log
+// entries made by a Follower.
+const DefaultPollInterval time.Duration = 1 * time.Second
+
+// FilenameDateLayout is the layout of the date used by EZproxy to name each
+// daily audit log file (e.g., 20200925.txt).
+const FilenameDateLayout string = "20060102"
+
+// dailyFilenameRegex matches the names of the daily audit log files created
+// by EZproxy.
+var dailyFilenameRegex = regexp.MustCompile(`^\d{8}\.txt$`)
+
+// Offset reflects the position within an audit log file just after the last
+// event read by a Follower. This value can be saved by the caller and
+// provided to a new Follower in order to resume after a restart.
+type Offset struct {
+
+	// Filename is the name of the audit log file.
+	Filename string
+
+	// Offset is the number of bytes read from the audit log file.
+	Offset int64
+
+	// Line is the number of lines read from the audit log file.
+	Line int
+}
+
+// FollowEvent is an Event read by a Follower along with the position within
+// the audit log file just after the event.
+type FollowEvent struct {
+	Event
+
+	// Offset is the position within the audit log file just after this
+	// event. Save this value once the event has been processed in order to
+	// resume from the next event after a restart.
+	Offset Offset
+}
+
+// Follower tails the current audit log file, streaming newly recorded events
+// over a channel. When EZproxy begins writing to a new daily audit log file
+// in the same directory the Follower switches to that file once the current
+// file has been read in full, reading the current file once more after the
+// newer file is found so that entries written just before the switch are not
+// lost. If the current file is replaced or truncated it is read again from
+// the start.
+type Follower struct {
+
+	// offset is the position within the audit log file from which to start
+	// following.
+	offset Offset
+
+	// offsetSet indicates whether a starting position was provided by the
+	// caller. If not, the Follower starts at the end of the file.
+	offsetSet bool
+
+	// pollInterval is the delay between checks for new audit log entries.
+	pollInterval time.Duration
+}
+
+// NewFollower creates a new Follower for the specified audit log file. By
+// default the Follower starts at the end of the file; use SetOffset to resume
+// from a saved position instead.
+func NewFollower(filename string) (*Follower, error) {
+
+	if filename == "" {
+		return nil, errors.New("func NewFollower: missing filename")
+	}
+
+	return &Follower{
+		offset:       Offset{Filename: filename},
+		pollInterval: DefaultPollInterval,
+	}, nil
+}
+
+// SetPollInterval is a helper method for setting the delay between checks
+// for new audit log entries.
+func (f *Follower) SetPollInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("func SetPollInterval: %v is not a valid poll interval", interval)
+	}
+
+	f.pollInterval = interval
+
+	return nil
+}
+
+// SetOffset is a helper method for setting the position from which to start
+// following, usually a value saved from an earlier FollowEvent. If the
+// Filename field is empty the filename provided to NewFollower is used. A
+// zero Offset field starts at the beginning of the file.
+func (f *Follower) SetOffset(offset Offset) error {
+	if offset.Offset < 0 || offset.Line < 0 {
+		return fmt.Errorf("func SetOffset: %+v is not a valid offset", offset)
+	}
+
+	if offset.Filename == "" {
+		offset.Filename = f.offset.Filename
+	}
+
+	f.offset = offset
+	f.offsetSet = true
+
+	return nil
+}
+
+// Follow starts following the audit log file, returning a channel on which
+// each newly recorded event is sent. The channel is closed once the provided
+// context is cancelled.
+//
+// Errors encountered after the initial attempt to open the audit log file
+// (e.g., while EZproxy is replacing the file) are logged and the read
+// retried on the next poll. Lines which cannot be parsed as events are
+// skipped.
+func (f *Follower) Follow(ctx context.Context) (<-chan FollowEvent, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func Follow: missing context")
+	}
+
+	info, err := os.Stat(filepath.Clean(f.offset.Filename))
+	if err != nil {
+		return nil, fmt.Errorf("func Follow: failed to stat file %q: %w", f.offset.Filename, err)
+	}
+
+	offset := f.offset
+	if !f.offsetSet {
+		offset.Offset = info.Size()
+	}
+
+	if offset.Offset > info.Size() {
+		ezproxy.Logger.Printf(
+			"Follow: offset %d is beyond the end of %q (%d bytes); starting from the beginning\n",
+			offset.Offset,
+			offset.Filename,
+			info.Size(),
+		)
+		offset.Offset, offset.Line = 0, 0
+	}
+
+	// Determine the number of lines already read if not provided in order
+	// to report accurate line numbers.
+	if offset.Line == 0 && offset.Offset > 0 {
+		offset.Line, err = countLines(offset.Filename, offset.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("func Follow: %w", err)
+		}
+	}
+
+	events := make(chan FollowEvent, ezproxy.AllUsersSessionsLimit)
+
+	go f.follow(ctx, offset, info, events)
+
+	return events, nil
+}
+
+// follow polls the audit log file for new entries at each poll interval,
+// sending an event for each complete line recorded.
+func (f *Follower) follow(
+	ctx context.Context,
+	offset Offset,
+	lastInfo os.FileInfo,
+	events chan<- FollowEvent,
+) {
+
+	defer close(events)
+
+	ticker := time.NewTicker(f.pollInterval)
+	defer ticker.Stop()
+
+	for {
+
+		// Read everything available, switching to newer daily files as
+		// needed, before waiting for the next poll.
+		for {
+			var err error
+			var done bool
+
+			offset, lastInfo, done, err = f.readAvailable(ctx, offset, lastInfo, events)
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				ezproxy.Logger.Printf("Follow: stopping: %v\n", err)
+				return
+			}
+			if err != nil {
+				ezproxy.Logger.Printf("Follow: %v\n", err)
+				break
+			}
+			if done {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			ezproxy.Logger.Printf("Follow: stopping: %v\n", ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readAvailable sends events for the complete lines available in the current
+// audit log file. If the file has been read in full and a newer daily audit
+// log file exists, the current file is read once more (in case entries were
+// written to it after the first read) and, if still read in full, the
+// returned offset refers to the start of the newer file and done is false to
+// indicate that it should be read without waiting. An error is returned if
+// the current file no longer exists and there is no newer file.
+func (f *Follower) readAvailable(
+	ctx context.Context,
+	offset Offset,
+	lastInfo os.FileInfo,
+	events chan<- FollowEvent,
+) (Offset, os.FileInfo, bool, error) {
+
+	info, err := os.Stat(filepath.Clean(offset.Filename))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// the current file was removed; move on to a newer file if present
+		next, err := nextDailyFile(offset.Filename)
+		switch {
+		case err != nil:
+			return offset, lastInfo, true, err
+		case next == "":
+			return offset, lastInfo, true, fmt.Errorf(
+				"file %q no longer exists and no newer daily audit log file was found",
+				offset.Filename,
+			)
+		}
+		return switchOffset(offset, next)
+
+	case err != nil:
+		return offset, lastInfo, true, fmt.Errorf("failed to stat file %q: %w", offset.Filename, err)
+	}
+
+	switch {
+	case lastInfo != nil && !os.SameFile(lastInfo, info):
+		ezproxy.Logger.Printf("Follow: file %q was replaced; reading from the beginning\n", offset.Filename)
+		offset.Offset, offset.Line = 0, 0
+
+	case info.Size() < offset.Offset:
+		ezproxy.Logger.Printf("Follow: file %q was truncated; reading from the beginning\n", offset.Filename)
+		offset.Offset, offset.Line = 0, 0
+	}
+
+	lastInfo = info
+
+	offset, lastInfo, complete, err := drain(ctx, offset, lastInfo, events)
+	if err != nil || !complete {
+		return offset, lastInfo, true, err
+	}
+
+	next, err := nextDailyFile(offset.Filename)
+	if err != nil || next == "" {
+		return offset, lastInfo, true, err
+	}
+
+	// EZproxy may have written entries to the current file between the read
+	// above and the newer file being found; read these before switching.
+	offset, lastInfo, complete, err = drain(ctx, offset, lastInfo, events)
+	if err != nil || !complete {
+		return offset, lastInfo, true, err
+	}
+
+	return switchOffset(offset, next)
+}
+
+// drain sends events for the complete lines in the current audit log file
+// after the given offset. The returned complete value indicates whether the
+// file was read in full; the file is only read in full if it does not end
+// with a partial line.
+func drain(
+	ctx context.Context,
+	offset Offset,
+	lastInfo os.FileInfo,
+	events chan<- FollowEvent,
+) (Offset, os.FileInfo, bool, error) {
+
+	info, err := os.Stat(filepath.Clean(offset.Filename))
+	if err != nil {
+		return offset, lastInfo, false, fmt.Errorf("failed to stat file %q: %w", offset.Filename, err)
+	}
+
+	// the file was replaced or truncated since the last check; this is
+	// handled at the next poll
+	if (lastInfo != nil && !os.SameFile(lastInfo, info)) || info.Size() < offset.Offset {
+		return offset, lastInfo, false, nil
+	}
+
+	if info.Size() > offset.Offset {
+		offset, err = readEvents(ctx, offset, events)
+		if err != nil {
+			return offset, info, false, err
+		}
+	}
+
+	// Only move on to a newer file once the current file has been read in
+	// full; EZproxy may still be writing the last entries to it.
+	return offset, info, info.Size() <= offset.Offset, nil
+}
+
+// switchOffset returns the starting position of the specified daily audit
+// log file, which replaces the current file.
+func switchOffset(offset Offset, next string) (Offset, os.FileInfo, bool, error) {
+
+	ezproxy.Logger.Printf("Follow: switching from %q to %q\n", offset.Filename, next)
+
+	return Offset{Filename: next}, nil, false, nil
+}
+
+// readEvents sends an event for each complete line in the audit log file
+// after the given offset, returning the offset just after the last complete
+// line read.
+func readEvents(ctx context.Context, offset Offset, events chan<- FollowEvent) (Offset, error) {
+
+	f, err := os.Open(filepath.Clean(offset.Filename))
+	if err != nil {
+		return offset, fmt.Errorf("error encountered opening file %q: %w", offset.Filename, err)
+	}
+
+	// #nosec G307
+	// Believed to be a false-positive from recent gosec release
+	// https://github.com/securego/gosec/issues/714
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Ignore "file already closed" errors
+			if !errors.Is(err, os.ErrClosed) {
+				ezproxy.Logger.Printf(
+					"readEvents: failed to close file %q: %s",
+					offset.Filename,
+					err.Error(),
+				)
+			}
+		}
+	}()
+
+	if _, err := f.Seek(offset.Offset, io.SeekStart); err != nil {
+		return offset, fmt.Errorf("failed to seek to offset %d in file %q: %w", offset.Offset, offset.Filename, err)
+	}
+
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// A partial line is not consumed; EZproxy may still be writing
+			// it.
+			if errors.Is(err, io.EOF) {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("failed to read file %q: %w", offset.Filename, err)
+		}
+
+		offset.Offset += int64(len(line))
+		offset.Line++
+
+		event, parseErr := ParseEvent(line)
+		if parseErr != nil {
+			ezproxy.Logger.Printf(
+				"Skipping line %d from %q: %v\n",
+				offset.Line,
+				offset.Filename,
+				parseErr,
+			)
+			continue
+		}
+		event.LineNumber = offset.Line
+		event.Filename = offset.Filename
+
+		select {
+		case events <- FollowEvent{Event: event, Offset: offset}:
+		case <-ctx.Done():
+			return offset, ctx.Err()
+		}
+	}
+}
+
+// countLines returns the number of lines in the first limit bytes of the
+// specified file.
+func countLines(filename string, limit int64) (int, error) {
+
+	f, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return 0, fmt.Errorf("error encountered opening file %q: %w", filename, err)
+	}
+
+	// #nosec G307
+	// Believed to be a false-positive from recent gosec release
+	// https://github.com/securego/gosec/issues/714
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Ignore "file already closed" errors
+			if !errors.Is(err, os.ErrClosed) {
+				ezproxy.Logger.Printf(
+					"countLines: failed to close file %q: %s",
+					filename,
+					err.Error(),
+				)
+			}
+		}
+	}()
+
+	var lines int
+
+	r := bufio.NewReader(io.LimitReader(f, limit))
+	for {
+		_, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read file %q: %w", filename, err)
+		}
+		lines++
+	}
+}
+
+// dailyFiles returns the names of the daily audit log files in the specified
+// directory, oldest first.
+func dailyFiles(dir string) ([]string, error) {
+
+	dirEntries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %q: %w", dir, err)
+	}
+
+	filenames := make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Type().IsRegular() && dailyFilenameRegex.MatchString(dirEntry.Name()) {
+			filenames = append(filenames, filepath.Join(dir, dirEntry.Name()))
+		}
+	}
+
+	// The date based names sort chronologically.
+	sort.Strings(filenames)
+
+	return filenames, nil
+}
+
+// nextDailyFile returns the name of the oldest daily audit log file in the
+// same directory as the specified file which is newer than that file, or an
+// empty string if there is none or if the specified file is not a daily
+// audit log file.
+func nextDailyFile(filename string) (string, error) {
+
+	current := filepath.Base(filename)
+	if !dailyFilenameRegex.MatchString(current) {
+		return "", nil
+	}
+
+	filenames, err := dailyFiles(filepath.Dir(filename))
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range filenames {
+		if strings.Compare(filepath.Base(name), current) > 0 {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// LatestFile returns the name of the newest daily audit log file (e.g.,
+// 20200925.txt) in the specified directory. This is intended for use with
+// NewFollower.
+func LatestFile(dir string) (string, error) {
+
+	if dir == "" {
+		return "", errors.New("func LatestFile: missing directory")
+	}
+
+	filenames, err := dailyFiles(dir)
+	if err != nil {
+		return "", fmt.Errorf("func LatestFile: %w", err)
+	}
+
+	if len(filenames) == 0 {
+		return "", fmt.Errorf("func LatestFile: no daily audit log files found in %q", dir)
+	}
+
+	return filenames[len(filenames)-1], nil
+}
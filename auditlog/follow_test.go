@@ -0,0 +1,301 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testAuditHeader = "Date/Time\tEvent\tIP\tUsername\tSession\tOther\n"
+
+// testAuditLine returns an audit log line for a Login.Success event for the
+// specified username.
+func testAuditLine(username string) string {
+	return fmt.Sprintf("2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\t%s\tsess%s\t\n", username, username)
+}
+
+// appendFile appends the given content to the specified file.
+func appendFile(t *testing.T, filename string, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("failed to close file: %v", err)
+	}
+}
+
+// startTestFollower starts a Follower for the specified file, starting at the
+// given offset if not nil.
+func startTestFollower(t *testing.T, filename string, offset *Offset) <-chan FollowEvent {
+	t.Helper()
+
+	f, err := NewFollower(filename)
+	if err != nil {
+		t.Fatalf("NewFollower() error = %v", err)
+	}
+	if err := f.SetPollInterval(5 * time.Millisecond); err != nil {
+		t.Fatalf("SetPollInterval() error = %v", err)
+	}
+	if offset != nil {
+		if err := f.SetOffset(*offset); err != nil {
+			t.Fatalf("SetOffset() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	events, err := f.Follow(ctx)
+	if err != nil {
+		t.Fatalf("Follow() error = %v", err)
+	}
+
+	return events
+}
+
+// receiveEvents waits for the specified number of events.
+func receiveEvents(t *testing.T, events <-chan FollowEvent, count int) []FollowEvent {
+	t.Helper()
+
+	got := make([]FollowEvent, 0, count)
+
+	timeout := time.After(5 * time.Second)
+	for len(got) < count {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got %d of %d", len(got), count)
+		}
+	}
+
+	return got
+}
+
+// expectNoEvents fails the test if an event is received within a short
+// period.
+func expectNoEvents(t *testing.T, events <-chan FollowEvent) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		t.Fatalf("got unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// checkFollowEvent compares the username, filename and line number of the
+// given event.
+func checkFollowEvent(t *testing.T, event FollowEvent, username string, filename string, line int) {
+	t.Helper()
+
+	if event.Username != username ||
+		event.Offset.Filename != filename ||
+		event.LineNumber != line ||
+		event.Offset.Line != line {
+		t.Errorf(
+			"got event for %q from %q line %d (offset line %d), want %q from %q line %d",
+			event.Username,
+			event.Offset.Filename,
+			event.LineNumber,
+			event.Offset.Line,
+			username,
+			filename,
+			line,
+		)
+	}
+}
+
+func TestFollowerStartsAtEnd(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "20200925.txt")
+	appendFile(t, filename, testAuditHeader+testAuditLine("alice"))
+
+	events := startTestFollower(t, filename, nil)
+	expectNoEvents(t, events)
+
+	appendFile(t, filename, testAuditLine("bob"))
+
+	got := receiveEvents(t, events, 1)
+	checkFollowEvent(t, got[0], "bob", filename, 3)
+}
+
+func TestFollowerResumesFromOffset(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "20200925.txt")
+	content := testAuditHeader + testAuditLine("alice") + testAuditLine("bob") + testAuditLine("carol")
+	appendFile(t, filename, content)
+
+	// the number of lines read is determined if not provided
+	events := startTestFollower(t, filename, &Offset{
+		Offset: int64(len(testAuditHeader + testAuditLine("alice"))),
+	})
+
+	got := receiveEvents(t, events, 2)
+	checkFollowEvent(t, got[0], "bob", filename, 3)
+	checkFollowEvent(t, got[1], "carol", filename, 4)
+
+	if got[1].Offset.Offset != int64(len(content)) {
+		t.Errorf("final offset = %d, want %d", got[1].Offset.Offset, len(content))
+	}
+
+	// resuming from the saved offset only returns new events
+	events = startTestFollower(t, filename, &got[1].Offset)
+	appendFile(t, filename, testAuditLine("dave"))
+
+	got = receiveEvents(t, events, 1)
+	checkFollowEvent(t, got[0], "dave", filename, 5)
+}
+
+func TestFollowerOffsetBeyondEnd(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "20200925.txt")
+	appendFile(t, filename, testAuditHeader+testAuditLine("alice"))
+
+	events := startTestFollower(t, filename, &Offset{Offset: 1 << 20, Line: 1000})
+
+	got := receiveEvents(t, events, 1)
+	checkFollowEvent(t, got[0], "alice", filename, 2)
+}
+
+func TestFollowerPartialLine(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "20200925.txt")
+	appendFile(t, filename, testAuditHeader)
+
+	events := startTestFollower(t, filename, nil)
+
+	line := testAuditLine("alice")
+	appendFile(t, filename, line[:10])
+	expectNoEvents(t, events)
+
+	appendFile(t, filename, line[10:])
+
+	got := receiveEvents(t, events, 1)
+	checkFollowEvent(t, got[0], "alice", filename, 2)
+}
+
+func TestFollowerTruncation(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "20200925.txt")
+	appendFile(t, filename, testAuditHeader+testAuditLine("alice")+testAuditLine("bob"))
+
+	events := startTestFollower(t, filename, &Offset{})
+	receiveEvents(t, events, 2)
+
+	// truncated in place, then rewritten with less content
+	if err := os.WriteFile(filename, []byte(testAuditLine("carol")), 0600); err != nil {
+		t.Fatalf("failed to rewrite file: %v", err)
+	}
+
+	got := receiveEvents(t, events, 1)
+	checkFollowEvent(t, got[0], "carol", filename, 1)
+}
+
+func TestFollowerReplaced(t *testing.T) {
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "20200925.txt")
+	appendFile(t, filename, testAuditHeader+testAuditLine("alice"))
+
+	events := startTestFollower(t, filename, &Offset{})
+	receiveEvents(t, events, 1)
+
+	// replaced with a new file which is longer than the original
+	replacement := filepath.Join(dir, "replacement")
+	appendFile(t, replacement, testAuditHeader+testAuditLine("bob")+testAuditLine("carol"))
+	if err := os.Rename(replacement, filename); err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+
+	got := receiveEvents(t, events, 2)
+	checkFollowEvent(t, got[0], "bob", filename, 2)
+	checkFollowEvent(t, got[1], "carol", filename, 3)
+}
+
+func TestFollowerRotation(t *testing.T) {
+
+	dir := t.TempDir()
+	current := filepath.Join(dir, "20200925.txt")
+	next := filepath.Join(dir, "20200926.txt")
+
+	appendFile(t, current, testAuditHeader+testAuditLine("alice"))
+
+	events := startTestFollower(t, current, &Offset{})
+	receiveEvents(t, events, 1)
+
+	// the current file ends with a partial line when the newer file is
+	// created; the Follower waits for the line to be completed
+	line := testAuditLine("bob")
+	appendFile(t, current, line[:10])
+	appendFile(t, next, testAuditHeader+testAuditLine("carol"))
+	expectNoEvents(t, events)
+
+	appendFile(t, current, line[10:])
+
+	got := receiveEvents(t, events, 2)
+	checkFollowEvent(t, got[0], "bob", current, 3)
+	checkFollowEvent(t, got[1], "carol", next, 2)
+
+	// entries added to the older file after the switch are not read
+	appendFile(t, current, testAuditLine("mallory"))
+	appendFile(t, next, testAuditLine("dave"))
+
+	got = receiveEvents(t, events, 1)
+	checkFollowEvent(t, got[0], "dave", next, 3)
+}
+
+func TestReadAvailableMissingFile(t *testing.T) {
+
+	dir := t.TempDir()
+	current := filepath.Join(dir, "20200925.txt")
+	next := filepath.Join(dir, "20200926.txt")
+
+	f, err := NewFollower(current)
+	if err != nil {
+		t.Fatalf("NewFollower() error = %v", err)
+	}
+
+	events := make(chan FollowEvent, 10)
+	offset := Offset{Filename: current}
+
+	// the current file does not exist and there is no newer file
+	if _, _, _, err := f.readAvailable(context.Background(), offset, nil, events); err == nil {
+		t.Error("readAvailable() error = nil, want error for missing file")
+	}
+
+	appendFile(t, next, testAuditHeader)
+
+	got, _, done, err := f.readAvailable(context.Background(), offset, nil, events)
+	if err != nil {
+		t.Fatalf("readAvailable() error = %v", err)
+	}
+	if done || got.Filename != next {
+		t.Errorf("readAvailable() = %+v (done %t), want switch to %q", got, done, next)
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// datedFilenameRegex matches the names of the daily audit log files created
+// by EZproxy along with compressed copies of those files (e.g., 20200925.txt,
+// 20200925.txt.gz, 20200925.txt.bz2 or 20200925.txt.zst).
+var datedFilenameRegex = regexp.MustCompile(`^(\d{8})\.txt(\.gz|\.bz2|\.zst)?$`)
+
+// HistoryReader is the API for retrieving values from the daily audit log
+// files in a directory for a span of time.
+type HistoryReader interface {
+
+	// Files returns the daily audit log files in the directory which cover
+	// the requested span of time, oldest first.
+	Files() ([]string, error)
+
+	// AllEvents returns the events recorded within the requested span of
+	// time in chronological order. The Event values returned are NOT
+	// filtered to a specific username.
+	AllEvents() (Events, error)
+
+	// AllSessionEntries returns a slice of SessionEntry values for the
+	// sessions without a corresponding logout event as of the end of the
+	// requested span of time. Session state is reconstructed across all
+	// daily audit log files, so a login recorded in one file is paired with
+	// a logout recorded in a later file.
+	AllSessionEntries() (SessionEntries, error)
+
+	// AllUserSessions returns a list of all session IDs along with their
+	// associated IP Address and username for the sessions without a
+	// corresponding logout event as of the end of the requested span of
+	// time.
+	AllUserSessions() (ezproxy.UserSessions, error)
+}
+
+// auditHistoryReader represents a reader for the daily audit log files in a
+// directory.
+type auditHistoryReader struct {
+
+	// Dir is the directory containing the daily audit log files.
+	Dir string
+
+	// Start is the beginning of the span of time of interest. The zero value
+	// indicates no lower bound.
+	Start time.Time
+
+	// End is the end of the span of time of interest. The zero value
+	// indicates no upper bound.
+	End time.Time
+}
+
+// datedFile is a daily audit log file along with the date it covers.
+type datedFile struct {
+	Filename   string
+	Date       time.Time
+	Compressed bool
+}
+
+// NewHistoryReader creates a new instance of a HistoryReader that provides
+// access to the events recorded within the specified span of time (start and
+// end inclusive) in the daily audit log files found in the specified
+// directory. Both plain (e.g., 20200925.txt) and compressed (e.g.,
+// 20200925.txt.gz) daily audit log files are read. The zero value for start
+// or end indicates no bound.
+func NewHistoryReader(dir string, start time.Time, end time.Time) (HistoryReader, error) {
+
+	if dir == "" {
+		return nil, errors.New("func NewHistoryReader: missing directory")
+	}
+
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return nil, fmt.Errorf(
+			"func NewHistoryReader: end %v is before start %v",
+			end,
+			start,
+		)
+	}
+
+	reader := auditHistoryReader{
+		Dir:   dir,
+		Start: start,
+		End:   end,
+	}
+
+	return &reader, nil
+}
+
+// datedFiles returns the daily audit log files in the directory which cover
+// the requested span of time, oldest first. If both a plain and a compressed
+// file are present for the same date, the plain file is used.
+func (ahr auditHistoryReader) datedFiles() ([]datedFile, error) {
+
+	dirEntries, err := os.ReadDir(filepath.Clean(ahr.Dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %q: %w", ahr.Dir, err)
+	}
+
+	byDate := make(map[string]datedFile, len(dirEntries))
+
+	for _, dirEntry := range dirEntries {
+
+		if !dirEntry.Type().IsRegular() {
+			continue
+		}
+
+		matches := datedFilenameRegex.FindStringSubmatch(dirEntry.Name())
+		if matches == nil {
+			continue
+		}
+
+		date, err := time.ParseInLocation(FilenameDateLayout, matches[1], time.Local)
+		if err != nil {
+			ezproxy.Logger.Printf("Skipping file %q: %v\n", dirEntry.Name(), err)
+			continue
+		}
+
+		// Each file covers the events recorded on that date.
+		if !ahr.Start.IsZero() && !date.AddDate(0, 0, 1).After(ahr.Start) {
+			continue
+		}
+		if !ahr.End.IsZero() && date.After(ahr.End) {
+			continue
+		}
+
+		file := datedFile{
+			Filename:   filepath.Join(ahr.Dir, dirEntry.Name()),
+			Date:       date,
+			Compressed: matches[2] != "",
+		}
+
+		if existing, ok := byDate[matches[1]]; ok && !existing.Compressed {
+			ezproxy.Logger.Printf(
+				"Skipping file %q in favor of %q\n",
+				file.Filename,
+				existing.Filename,
+			)
+			continue
+		}
+
+		byDate[matches[1]] = file
+	}
+
+	files := make([]datedFile, 0, len(byDate))
+	for _, file := range byDate {
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Date.Before(files[j].Date)
+	})
+
+	return files, nil
+}
+
+// Files returns the daily audit log files in the directory which cover the
+// requested span of time, oldest first.
+func (ahr auditHistoryReader) Files() ([]string, error) {
+
+	files, err := ahr.datedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("func Files: %w", err)
+	}
+
+	filenames := make([]string, 0, len(files))
+	for _, file := range files {
+		filenames = append(filenames, file.Filename)
+	}
+
+	return filenames, nil
+}
+
+// AllEvents returns the events recorded within the requested span of time in
+// chronological order. The Event values returned are NOT filtered to a
+// specific username.
+func (ahr auditHistoryReader) AllEvents() (Events, error) {
+
+	files, err := ahr.datedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("func AllEvents: %w", err)
+	}
+
+	ezproxy.Logger.Printf("Found %d audit log files in %q\n", len(files), ahr.Dir)
+
+	var allEvents Events
+
+	for _, file := range files {
+
+		events, err := readDatedFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("func AllEvents: %w", err)
+		}
+
+		for idx := range events {
+			if !ahr.Start.IsZero() && events[idx].Time.Before(ahr.Start) {
+				continue
+			}
+			if !ahr.End.IsZero() && events[idx].Time.After(ahr.End) {
+				continue
+			}
+			allEvents = append(allEvents, events[idx])
+		}
+	}
+
+	// Files are read oldest first, but entries may be recorded slightly out
+	// of order within a file or across the midnight boundary.
+	sort.SliceStable(allEvents, func(i, j int) bool {
+		return allEvents[i].Time.Before(allEvents[j].Time)
+	})
+
+	ezproxy.Logger.Printf("Found %d events\n", len(allEvents))
+
+	return allEvents, nil
+}
+
+// AllSessionEntries returns a slice of SessionEntry values for the sessions
+// without a corresponding logout event as of the end of the requested span
+// of time. Session state is reconstructed across all daily audit log files,
+// so a login recorded in one file is paired with a logout recorded in a later
+// file. Logins recorded before the start of the requested span of time are
+// not included.
+func (ahr auditHistoryReader) AllSessionEntries() (SessionEntries, error) {
+
+	// These are events that contain relevant details for our work
+	validEvents := []string{
+		EventLoginSuccess,
+		EventLoginSuccessRelogin,
+		EventSessionIPChange,
+
+		// Used to remove any earlier entries since they're no longer relevant
+		EventLogout,
+	}
+
+	allEvents, err := ahr.AllEvents()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"func AllSessionEntries: failed to retrieve all events in order to generate session entries: %w",
+			err,
+		)
+	}
+
+	return allEvents.MatchingTypes(validEvents...).SessionEntries(), nil
+}
+
+// AllUserSessions returns a list of all session IDs along with their
+// associated IP Address and username for the sessions without a
+// corresponding logout event as of the end of the requested span of time.
+func (ahr auditHistoryReader) AllUserSessions() (ezproxy.UserSessions, error) {
+
+	sessionEntries, err := ahr.AllSessionEntries()
+	if err != nil {
+		return nil, fmt.Errorf("func AllUserSessions: %w", err)
+	}
+
+	return sessionEntries.UserSessions(), nil
+}
+
+// readDatedFile returns the events recorded in the specified daily audit log
+// file, decompressing the file if needed.
+func readDatedFile(file datedFile) (Events, error) {
+
+	ezproxy.Logger.Printf(
+		"readDatedFile: Attempting to open sanitized version of file %q\n",
+		filepath.Clean(file.Filename),
+	)
+
+	f, err := os.Open(filepath.Clean(file.Filename))
+	if err != nil {
+		return nil, fmt.Errorf("error encountered opening file %q: %w", file.Filename, err)
+	}
+
+	// #nosec G307
+	// Believed to be a false-positive from recent gosec release
+	// https://github.com/securego/gosec/issues/714
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Ignore "file already closed" errors
+			if !errors.Is(err, os.ErrClosed) {
+				ezproxy.Logger.Printf(
+					"readDatedFile: failed to close file %q: %s",
+					file.Filename,
+					err.Error(),
+				)
+			}
+		}
+	}()
+
+	events, err := scanEvents(f, file.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range events {
+		events[idx].Filename = file.Filename
+	}
+
+	// explicitly close file, bail if failure occurs
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf(
+			"failed to close file %q: %w",
+			file.Filename,
+			err,
+		)
+	}
+
+	return events, nil
+}
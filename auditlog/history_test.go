@@ -0,0 +1,215 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHistoryFile writes the given lines (after a header line) to the
+// specified file in the directory, gzip compressing the content if the name
+// ends with .gz.
+func writeHistoryFile(t *testing.T, dir string, name string, lines ...string) string {
+	t.Helper()
+
+	content := testAuditHeader + strings.Join(lines, "\n") + "\n"
+	filename := filepath.Join(dir, name)
+
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer f.Close()
+
+	if !strings.HasSuffix(name, ".gz") {
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		return filename
+	}
+
+	gw := gzip.NewWriter(f)
+	if _, err := gw.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to write gzip content: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	return filename
+}
+
+// newTestHistory creates a directory of daily audit log files where a login
+// in a plain file is paired with a logout in a later compressed file, and a
+// date has both a plain and a compressed file.
+func newTestHistory(t *testing.T) (string, map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"20200925": writeHistoryFile(t, dir, "20200925.txt",
+			"2020-09-25 23:58:00\tLogin.Success\t192.0.2.10\talice\tsessAlice\t",
+			"2020-09-25 23:59:00\tLogin.Success\t192.0.2.20\tbob\tsessBob\t",
+		),
+		"20200926": writeHistoryFile(t, dir, "20200926.txt.gz",
+			"2020-09-26 00:01:00\tLogout\talice\tsessAlice\t",
+			"2020-09-26 00:02:00\tSession.IPChange\t192.0.2.21\tbob\tsessBob\t192.0.2.20",
+		),
+		"20200927": writeHistoryFile(t, dir, "20200927.txt",
+			"2020-09-27 08:00:00\tLogin.Success\t192.0.2.30\tcarol\tsessCarol\t",
+		),
+	}
+
+	// an older compressed copy of the plain file for the same date
+	writeHistoryFile(t, dir, "20200927.txt.gz",
+		"2020-09-27 07:00:00\tLogin.Success\t192.0.2.66\tmallory\tsessMallory\t",
+	)
+
+	// files which are not daily audit log files are ignored
+	writeHistoryFile(t, dir, "notes.txt", "2020-09-27 09:00:00\tLogin.Success\t192.0.2.67\teve\tsessEve\t")
+
+	return dir, files
+}
+
+func TestHistoryReaderFiles(t *testing.T) {
+
+	dir, files := newTestHistory(t)
+
+	day := func(d int) time.Time {
+		return time.Date(2020, time.September, d, 0, 0, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		end   time.Time
+		want  []string
+	}{
+		{"unbounded", time.Time{}, time.Time{}, []string{files["20200925"], files["20200926"], files["20200927"]}},
+		{"start", day(26), time.Time{}, []string{files["20200926"], files["20200927"]}},
+		{"start within day", day(25).Add(23 * time.Hour), time.Time{}, []string{files["20200925"], files["20200926"], files["20200927"]}},
+		{"end", time.Time{}, day(26).Add(time.Hour), []string{files["20200925"], files["20200926"]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewHistoryReader(dir, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("NewHistoryReader() error = %v", err)
+			}
+
+			got, err := reader.Files()
+			if err != nil {
+				t.Fatalf("Files() error = %v", err)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Files() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryReaderAllEvents(t *testing.T) {
+
+	dir, files := newTestHistory(t)
+
+	reader, err := NewHistoryReader(dir, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("NewHistoryReader() error = %v", err)
+	}
+
+	events, err := reader.AllEvents()
+	if err != nil {
+		t.Fatalf("AllEvents() error = %v", err)
+	}
+
+	want := []struct {
+		username string
+		filename string
+		line     int
+	}{
+		{"alice", files["20200925"], 2},
+		{"bob", files["20200925"], 3},
+		{"alice", files["20200926"], 2},
+		{"bob", files["20200926"], 3},
+		{"carol", files["20200927"], 2},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+
+	for idx, event := range events {
+		if event.Username != want[idx].username ||
+			event.Filename != want[idx].filename ||
+			event.LineNumber != want[idx].line {
+			t.Errorf(
+				"event %d is for %q from %q line %d, want %q from %q line %d",
+				idx,
+				event.Username,
+				event.Filename,
+				event.LineNumber,
+				want[idx].username,
+				want[idx].filename,
+				want[idx].line,
+			)
+		}
+	}
+}
+
+func TestHistoryReaderAllSessionEntries(t *testing.T) {
+
+	dir, _ := newTestHistory(t)
+
+	reader, err := NewHistoryReader(dir, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("NewHistoryReader() error = %v", err)
+	}
+
+	entries, err := reader.AllSessionEntries()
+	if err != nil {
+		t.Fatalf("AllSessionEntries() error = %v", err)
+	}
+
+	// the login for alice is paired with the logout in the compressed file
+	// for the following day
+	got := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		got[entry.SessionID] = entry.IPAddress
+	}
+
+	want := map[string]string{
+		"sessBob":   "192.0.2.21",
+		"sessCarol": "192.0.2.30",
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got sessions %v, want %v", got, want)
+	}
+	for sessionID, ipAddress := range want {
+		if got[sessionID] != ipAddress {
+			t.Errorf("session %q has IP Address %q, want %q", sessionID, got[sessionID], ipAddress)
+		}
+	}
+}
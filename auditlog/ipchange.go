@@ -0,0 +1,261 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// IPChange reflects a Session.IPChange entry in a audit/YYYYMMDD.txt file.
+// This event is recorded when the IP Address for an established session
+// changes. The IP field of the entry records the new IP Address while the
+// Other field records the previous IP Address.
+type IPChange struct {
+
+	// Time is the parsed Date/Time field of the entry in the audit file.
+	Time time.Time
+
+	// Datestamp is the Date/Time field of the entry in the audit file as
+	// originally recorded.
+	Datestamp string
+
+	// Username is the username associated with the session.
+	Username string
+
+	// SessionID is the session ID associated with the session.
+	SessionID string
+
+	// OldIPAddress is the IP Address used by the session prior to the
+	// change. This value is empty if it could not be determined from the
+	// Other field.
+	OldIPAddress string
+
+	// NewIPAddress is the IP Address used by the session after the change.
+	NewIPAddress string
+}
+
+// IPChanges is a collection of IPChange values that is intended for
+// aggregation before bulk processing of some kind.
+type IPChanges []IPChange
+
+// IPChange converts an Event value to an IPChange value. False is returned
+// if the event is not a Session.IPChange event.
+func (e Event) IPChange() (IPChange, bool) {
+
+	if e.Type != EventSessionIPChange {
+		return IPChange{}, false
+	}
+
+	return IPChange{
+		Time:         e.Time,
+		Datestamp:    e.Datestamp,
+		Username:     e.Username,
+		SessionID:    e.SessionID,
+		OldIPAddress: findIPAddress(e.Other),
+		NewIPAddress: e.IPAddress,
+	}, true
+}
+
+// IPChanges returns the IPChange values for all Session.IPChange events in
+// the collection.
+func (e Events) IPChanges() IPChanges {
+
+	ipChanges := make(IPChanges, 0, ezproxy.SessionsLimit)
+
+	for idx := range e {
+		if ipChange, ok := e[idx].IPChange(); ok {
+			ipChanges = append(ipChanges, ipChange)
+		}
+	}
+
+	return ipChanges
+}
+
+// findIPAddress returns the first IP Address found within the given text,
+// or an empty string if one is not found.
+func findIPAddress(text string) string {
+
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		switch r {
+		case ' ', '\t', ',', ';', '=', '(', ')', '[', ']':
+			return true
+		default:
+			return false
+		}
+	})
+
+	for _, field := range fields {
+		if ip := net.ParseIP(field); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return ""
+}
+
+// IPHistory returns the IP Addresses used by the session in the order that
+// they were recorded, starting with the IP Address used at login (if known).
+// Repeated consecutive IP Addresses are only listed once.
+func (se SessionEntry) IPHistory() []string {
+
+	history := make([]string, 0, len(se.IPChanges)+2)
+
+	add := func(ip string) {
+		if ip == "" {
+			return
+		}
+		if len(history) > 0 && history[len(history)-1] == ip {
+			return
+		}
+		history = append(history, ip)
+	}
+
+	// The previous IP Address is not always recorded for an IP Address
+	// change, so the login IP Address is listed explicitly.
+	add(se.LoginIPAddress)
+
+	for _, ipChange := range se.IPChanges {
+		add(ipChange.OldIPAddress)
+		add(ipChange.NewIPAddress)
+	}
+
+	add(se.IPAddress)
+
+	return history
+}
+
+// SessionEntries reconstructs session state from the session-related events
+// in the collection, processed in the order that they were recorded. Login
+// events create (or replace) the entry for a session, IP Address change
+// events update the current IP Address and IP Address history for a session
+// and Logout events remove the entry for a session. Sessions are returned in
+// the order that they were first recorded.
+func (e Events) SessionEntries() SessionEntries {
+	return e.sessionHistory(false)
+}
+
+// SessionHistory behaves like SessionEntries, but also returns the sessions
+// which were logged out. Each session is returned with the IP Address history
+// recorded up to the Logout event; a later login reusing the same session ID
+// is returned as a separate session.
+func (e Events) SessionHistory() SessionEntries {
+	return e.sessionHistory(true)
+}
+
+// sessionHistory reconstructs session state from the session-related events
+// in the collection, optionally including sessions which were logged out.
+func (e Events) sessionHistory(includeEnded bool) SessionEntries {
+
+	// userSessionIDsIndex holds the sessions which have not been logged out,
+	// indexed by session ID.
+	userSessionIDsIndex := make(map[string]*SessionEntry, ezproxy.SessionsLimit)
+
+	// order holds every session in the order that they were first recorded.
+	order := make([]*SessionEntry, 0, ezproxy.SessionsLimit)
+
+	// ended holds the sessions which were logged out.
+	ended := make(map[*SessionEntry]struct{})
+
+	for idx := range e {
+
+		event := e[idx]
+		if event.SessionID == "" {
+			continue
+		}
+
+		switch event.Type {
+
+		case EventLoginSuccess, EventLoginSuccessRelogin:
+
+			entry := event.SessionEntry()
+
+			// A relogin for an existing session retains the IP Address
+			// history already recorded for that session.
+			if existing, ok := userSessionIDsIndex[event.SessionID]; ok {
+				if event.Type == EventLoginSuccessRelogin {
+					entry.IPChanges = existing.IPChanges
+					entry.LoginIPAddress = existing.LoginIPAddress
+					if existing.IPAddress != event.IPAddress && event.IPAddress != "" {
+						entry.IPChanges = append(entry.IPChanges, IPChange{
+							Time:         event.Time,
+							Datestamp:    event.Datestamp,
+							Username:     event.Username,
+							SessionID:    event.SessionID,
+							OldIPAddress: existing.IPAddress,
+							NewIPAddress: event.IPAddress,
+						})
+					}
+				}
+				*existing = entry
+				continue
+			}
+
+			userSessionIDsIndex[event.SessionID] = &entry
+			order = append(order, &entry)
+
+		case EventSessionIPChange:
+
+			ipChange, _ := event.IPChange()
+
+			existing, ok := userSessionIDsIndex[event.SessionID]
+			if !ok {
+				// The login for this session was not recorded in the events
+				// provided (e.g., it was recorded in an earlier audit file).
+				entry := event.SessionEntry()
+				entry.IPAddress = ipChange.OldIPAddress
+				entry.LoginIPAddress = ipChange.OldIPAddress
+				userSessionIDsIndex[event.SessionID] = &entry
+				order = append(order, &entry)
+				existing = &entry
+			}
+
+			if ipChange.OldIPAddress == "" {
+				ipChange.OldIPAddress = existing.IPAddress
+				if ipChange.OldIPAddress == ipChange.NewIPAddress {
+					ipChange.OldIPAddress = ""
+				}
+			}
+
+			existing.IPChanges = append(existing.IPChanges, ipChange)
+			existing.IPAddress = ipChange.NewIPAddress
+			if existing.Username == "" {
+				existing.Username = ipChange.Username
+			}
+
+		case EventLogout:
+			if existing, ok := userSessionIDsIndex[event.SessionID]; ok {
+				ended[existing] = struct{}{}
+				delete(userSessionIDsIndex, event.SessionID)
+			}
+		}
+	}
+
+	userSessions := make(SessionEntries, 0, len(order))
+	for _, entry := range order {
+		if _, ok := ended[entry]; ok && !includeEnded {
+			continue
+		}
+
+		userSessions = append(userSessions, *entry)
+	}
+
+	return userSessions
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testSession summarizes a SessionEntry value for comparison.
+type testSession struct {
+	SessionID string
+	Username  string
+	IPAddress string
+	IPHistory []string
+}
+
+// testSessions summarizes the given SessionEntry values for comparison.
+func testSessions(entries SessionEntries) []testSession {
+	sessions := make([]testSession, 0, len(entries))
+	for _, entry := range entries {
+		sessions = append(sessions, testSession{
+			SessionID: entry.SessionID,
+			Username:  entry.Username,
+			IPAddress: entry.IPAddress,
+			IPHistory: entry.IPHistory(),
+		})
+	}
+
+	return sessions
+}
+
+func TestSessionEntries(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		lines   []string
+		open    []testSession
+		history []testSession
+	}{
+		{
+			name: "sessions are keyed by session ID",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:01:00\tLogin.Success\t192.0.2.11\talice\tbbbb\t",
+				"2020-09-25 08:02:00\tLogout\talice\taaaa\t",
+			},
+			open: []testSession{
+				{"bbbb", "alice", "192.0.2.11", []string{"192.0.2.11"}},
+			},
+			history: []testSession{
+				{"aaaa", "alice", "192.0.2.10", []string{"192.0.2.10"}},
+				{"bbbb", "alice", "192.0.2.11", []string{"192.0.2.11"}},
+			},
+		},
+		{
+			name: "ip change",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\t192.0.2.10",
+				"2020-09-25 08:20:00\tSession.IPChange\t192.0.2.30\talice\taaaa\t198.51.100.20",
+			},
+			open: []testSession{
+				{"aaaa", "alice", "192.0.2.30", []string{"192.0.2.10", "198.51.100.20", "192.0.2.30"}},
+			},
+		},
+		{
+			name: "ip change without previous ip address",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\t",
+			},
+			open: []testSession{
+				{"aaaa", "alice", "198.51.100.20", []string{"192.0.2.10", "198.51.100.20"}},
+			},
+		},
+		{
+			name: "ip change without login",
+			lines: []string{
+				"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\t192.0.2.10",
+			},
+			open: []testSession{
+				{"aaaa", "alice", "198.51.100.20", []string{"192.0.2.10", "198.51.100.20"}},
+			},
+		},
+		{
+			name: "relogin retains ip history",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\t192.0.2.10",
+				"2020-09-25 08:20:00\tLogin.Success.Relogin\t192.0.2.30\talice\taaaa\t",
+			},
+			open: []testSession{
+				{"aaaa", "alice", "192.0.2.30", []string{"192.0.2.10", "198.51.100.20", "192.0.2.30"}},
+			},
+		},
+		{
+			name: "login replaces session",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\t192.0.2.10",
+				"2020-09-25 08:20:00\tLogin.Success\t192.0.2.30\tbob\taaaa\t",
+			},
+			open: []testSession{
+				{"aaaa", "bob", "192.0.2.30", []string{"192.0.2.30"}},
+			},
+		},
+		{
+			name: "logout",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\t192.0.2.10",
+				"2020-09-25 08:20:00\tLogout\talice\taaaa\t",
+			},
+			open: []testSession{},
+			history: []testSession{
+				{"aaaa", "alice", "198.51.100.20", []string{"192.0.2.10", "198.51.100.20"}},
+			},
+		},
+		{
+			name: "login after logout reusing session ID",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+				"2020-09-25 08:10:00\tLogout\talice\taaaa\t",
+				"2020-09-25 08:20:00\tLogin.Success\t198.51.100.20\talice\taaaa\t",
+			},
+			open: []testSession{
+				{"aaaa", "alice", "198.51.100.20", []string{"198.51.100.20"}},
+			},
+			history: []testSession{
+				{"aaaa", "alice", "192.0.2.10", []string{"192.0.2.10"}},
+				{"aaaa", "alice", "198.51.100.20", []string{"198.51.100.20"}},
+			},
+		},
+		{
+			name: "events without session ID are ignored",
+			lines: []string{
+				"2020-09-25 08:00:00\tLogin.Failure\t192.0.2.10\talice\t\tInvalid password",
+				"2020-09-25 08:01:00\tSystem\t\t\t\tEZproxy started",
+			},
+			open: []testSession{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := testEvents(t, tt.lines...)
+
+			if got := testSessions(events.SessionEntries()); !reflect.DeepEqual(got, tt.open) {
+				t.Errorf("SessionEntries() = %+v, want %+v", got, tt.open)
+			}
+
+			// Unless listed separately, the session history matches the
+			// open sessions.
+			history := tt.history
+			if history == nil {
+				history = tt.open
+			}
+			if got := testSessions(events.SessionHistory()); !reflect.DeepEqual(got, history) {
+				t.Errorf("SessionHistory() = %+v, want %+v", got, history)
+			}
+		})
+	}
+}
+
+func TestSessionEntryIPHistory(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		entry SessionEntry
+		want  []string
+	}{
+		{
+			name:  "no changes",
+			entry: SessionEntry{IPAddress: "192.0.2.10", LoginIPAddress: "192.0.2.10"},
+			want:  []string{"192.0.2.10"},
+		},
+		{
+			name: "first change without previous ip address",
+			entry: SessionEntry{
+				IPAddress:      "192.0.2.30",
+				LoginIPAddress: "192.0.2.10",
+				IPChanges: IPChanges{
+					{NewIPAddress: "198.51.100.20"},
+					{OldIPAddress: "198.51.100.20", NewIPAddress: "192.0.2.30"},
+				},
+			},
+			want: []string{"192.0.2.10", "198.51.100.20", "192.0.2.30"},
+		},
+		{
+			name: "return to an earlier ip address",
+			entry: SessionEntry{
+				IPAddress:      "192.0.2.10",
+				LoginIPAddress: "192.0.2.10",
+				IPChanges: IPChanges{
+					{OldIPAddress: "192.0.2.10", NewIPAddress: "198.51.100.20"},
+					{OldIPAddress: "198.51.100.20", NewIPAddress: "192.0.2.10"},
+				},
+			},
+			want: []string{"192.0.2.10", "198.51.100.20", "192.0.2.10"},
+		},
+		{
+			name:  "unknown login ip address",
+			entry: SessionEntry{IPAddress: "192.0.2.10"},
+			want:  []string{"192.0.2.10"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.IPHistory(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IPHistory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventIPChange(t *testing.T) {
+
+	events := testEvents(t,
+		"2020-09-25 08:10:00\tSession.IPChange\t198.51.100.20\talice\taaaa\tfrom 192.0.2.10",
+		"2020-09-25 08:20:00\tLogin.Success\t192.0.2.10\talice\taaaa\t",
+	)
+
+	ipChange, ok := events[0].IPChange()
+	if !ok {
+		t.Fatal("IPChange() = false, want true")
+	}
+	if ipChange.OldIPAddress != "192.0.2.10" || ipChange.NewIPAddress != "198.51.100.20" {
+		t.Errorf("IPChange() = %+v, want change from 192.0.2.10 to 198.51.100.20", ipChange)
+	}
+
+	if _, ok := events[1].IPChange(); ok {
+		t.Error("IPChange() = true for Login.Success event, want false")
+	}
+
+	if got := len(events.IPChanges()); got != 1 {
+		t.Errorf("IPChanges() returned %d values, want 1", got)
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+	"github.com/atc0005/go-ezproxy/geoip"
+)
+
+// These are the kinds of findings reported by the IP hopping detector.
+const (
+
+	// IPHoppingFindingFrequentChanges is reported when the IP Address for a
+	// session changes too often within the configured window.
+	IPHoppingFindingFrequentChanges string = "frequent-ip-changes"
+
+	// IPHoppingFindingRapidNetworkChange is reported when a session moves
+	// between networks faster than the configured interval allows.
+	IPHoppingFindingRapidNetworkChange string = "rapid-network-change"
+
+	// IPHoppingFindingImpossibleTravel is reported when a session moves
+	// between two geolocations faster than the configured travel speed
+	// allows. This requires a geoip.Locator.
+	IPHoppingFindingImpossibleTravel string = "impossible-travel"
+)
+
+// These are the default settings used by the IP hopping detector.
+const (
+
+	// DefaultIPHoppingWindow is the default sliding window within which IP
+	// Address changes for a session are counted.
+	DefaultIPHoppingWindow time.Duration = 1 * time.Hour
+
+	// DefaultMaxIPChanges is the default number of IP Address changes for a
+	// session allowed within the window.
+	DefaultMaxIPChanges int = 3
+
+	// DefaultMinNetworkChangeInterval is the default minimum amount of time
+	// expected between a session moving from one network to another.
+	DefaultMinNetworkChangeInterval time.Duration = 5 * time.Minute
+
+	// DefaultIPv4NetworkPrefixLength is the default prefix length used to
+	// determine whether two IPv4 Addresses belong to the same network.
+	DefaultIPv4NetworkPrefixLength int = 24
+
+	// DefaultIPv6NetworkPrefixLength is the default prefix length used to
+	// determine whether two IPv6 Addresses belong to the same network.
+	DefaultIPv6NetworkPrefixLength int = 48
+
+	// DefaultMaxTravelSpeed is the default maximum travel speed (in
+	// kilometers per hour) considered possible between two locations. This
+	// approximates the cruising speed of a commercial airliner.
+	DefaultMaxTravelSpeed float64 = 1000
+
+	// DefaultMinTravelDistance is the default minimum distance (in
+	// kilometers) between two locations required to report impossible
+	// travel. This helps account for the imprecision of geolocation data.
+	DefaultMinTravelDistance float64 = 500
+)
+
+// IPHoppingConfig provides the settings used by the IP hopping detector.
+type IPHoppingConfig struct {
+
+	// Window is the sliding window within which IP Address changes for a
+	// session are counted.
+	Window time.Duration
+
+	// MaxIPChanges is the number of IP Address changes for a session allowed
+	// within Window.
+	MaxIPChanges int
+
+	// MinNetworkChangeInterval is the minimum amount of time expected
+	// between a session moving from one network to another.
+	MinNetworkChangeInterval time.Duration
+
+	// IPv4NetworkPrefixLength is the prefix length used to determine whether
+	// two IPv4 Addresses belong to the same network.
+	IPv4NetworkPrefixLength int
+
+	// IPv6NetworkPrefixLength is the prefix length used to determine whether
+	// two IPv6 Addresses belong to the same network.
+	IPv6NetworkPrefixLength int
+
+	// Locator is an optional offline geolocation database. If not set,
+	// impossible travel is not evaluated.
+	Locator geoip.Locator
+
+	// MaxTravelSpeed is the maximum travel speed (in kilometers per hour)
+	// considered possible between two locations.
+	MaxTravelSpeed float64
+
+	// MinTravelDistance is the minimum distance (in kilometers) between two
+	// locations required to report impossible travel.
+	MinTravelDistance float64
+}
+
+// IPHoppingFinding reflects a session whose IP Address changed in a
+// suspicious manner.
+type IPHoppingFinding struct {
+
+	// Kind is the kind of finding (e.g., frequent-ip-changes,
+	// impossible-travel).
+	Kind string
+
+	// UserSession is the user session associated with the finding. This
+	// value is suitable for use with the ezproxy.UserSessions.Terminate
+	// method.
+	UserSession ezproxy.UserSession
+
+	// SessionEntry is the session entry (including the full IP Address
+	// history) associated with the finding.
+	SessionEntry SessionEntry
+
+	// IPChanges is the collection of IP Address changes which triggered the
+	// finding.
+	IPChanges IPChanges
+
+	// From is the location of the previous IP Address for impossible travel
+	// findings.
+	From geoip.Location
+
+	// To is the location of the new IP Address for impossible travel
+	// findings.
+	To geoip.Location
+
+	// Distance is the distance (in kilometers) between From and To for
+	// impossible travel findings.
+	Distance float64
+
+	// Speed is the implied travel speed (in kilometers per hour) between
+	// From and To for impossible travel findings.
+	Speed float64
+
+	// Message is a brief human readable summary of the finding.
+	Message string
+}
+
+// IPHoppingFindings is a collection of IPHoppingFinding values that is
+// intended for aggregation before bulk processing of some kind.
+type IPHoppingFindings []IPHoppingFinding
+
+// DefaultIPHoppingConfig returns an IPHoppingConfig using the default
+// settings. A Locator is not set.
+func DefaultIPHoppingConfig() IPHoppingConfig {
+	return IPHoppingConfig{
+		Window:                   DefaultIPHoppingWindow,
+		MaxIPChanges:             DefaultMaxIPChanges,
+		MinNetworkChangeInterval: DefaultMinNetworkChangeInterval,
+		IPv4NetworkPrefixLength:  DefaultIPv4NetworkPrefixLength,
+		IPv6NetworkPrefixLength:  DefaultIPv6NetworkPrefixLength,
+		MaxTravelSpeed:           DefaultMaxTravelSpeed,
+		MinTravelDistance:        DefaultMinTravelDistance,
+	}
+}
+
+// validate asserts that the settings are usable.
+func (c IPHoppingConfig) validate() error {
+	switch {
+	case c.Window <= 0:
+		return fmt.Errorf("%v is not a valid window", c.Window)
+	case c.MaxIPChanges < 1:
+		return fmt.Errorf("%d is not a valid number of IP Address changes", c.MaxIPChanges)
+	case c.MinNetworkChangeInterval < 0:
+		return fmt.Errorf("%v is not a valid network change interval", c.MinNetworkChangeInterval)
+	case c.IPv4NetworkPrefixLength < 0 || c.IPv4NetworkPrefixLength > 32:
+		return fmt.Errorf("%d is not a valid IPv4 prefix length", c.IPv4NetworkPrefixLength)
+	case c.IPv6NetworkPrefixLength < 0 || c.IPv6NetworkPrefixLength > 128:
+		return fmt.Errorf("%d is not a valid IPv6 prefix length", c.IPv6NetworkPrefixLength)
+	case c.Locator != nil && c.MaxTravelSpeed <= 0:
+		return fmt.Errorf("%v is not a valid travel speed", c.MaxTravelSpeed)
+	case c.MinTravelDistance < 0:
+		return fmt.Errorf("%v is not a valid travel distance", c.MinTravelDistance)
+	default:
+		return nil
+	}
+}
+
+// DetectIPHopping evaluates the IP Address history of each session recorded
+// in the given events, reporting sessions whose IP Address changes too often,
+// which move between networks too quickly or (if a geoip.Locator is
+// provided) which move between locations faster than is physically possible.
+// Sessions which were logged out are evaluated using the history recorded up
+// to the Logout event. At most one finding of each kind is reported for a
+// session.
+func DetectIPHopping(events Events, config IPHoppingConfig) (IPHoppingFindings, error) {
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("func DetectIPHopping: invalid config: %w", err)
+	}
+
+	entries := events.SessionHistory()
+
+	var findings IPHoppingFindings
+
+	for idx := range entries {
+		findings = append(findings, detectSessionIPHopping(entries[idx], config)...)
+	}
+
+	ezproxy.Logger.Printf("Found %d IP hopping findings\n", len(findings))
+
+	return findings, nil
+}
+
+// detectSessionIPHopping evaluates the IP Address history of a single
+// session.
+func detectSessionIPHopping(entry SessionEntry, config IPHoppingConfig) IPHoppingFindings {
+
+	if len(entry.IPChanges) == 0 {
+		return nil
+	}
+
+	var findings IPHoppingFindings
+
+	newFinding := func(kind string, changes IPChanges, message string) IPHoppingFinding {
+		return IPHoppingFinding{
+			Kind:         kind,
+			UserSession:  entry.UserSession(),
+			SessionEntry: entry,
+			IPChanges:    changes,
+			Message:      message,
+		}
+	}
+
+	// The login time (if recorded) marks the start of the interval before
+	// the first IP Address change. The time recorded for a relogin is not
+	// used as the session (and any IP Address changes) may predate it.
+	var previous time.Time
+	if entry.Event == EventLoginSuccess {
+		if t, err := time.ParseInLocation(TimeStampLayout, entry.Datestamp, time.Local); err == nil {
+			previous = t
+		}
+	}
+
+	var reportedFrequent, reportedNetwork, reportedTravel bool
+
+	for idx, change := range entry.IPChanges {
+
+		// Intervals which are not positive (e.g., changes recorded within
+		// the same second) do not provide a usable elapsed time and are
+		// skipped.
+		var interval time.Duration
+		if !previous.IsZero() && !change.Time.IsZero() {
+			interval = change.Time.Sub(previous)
+		}
+		hasInterval := interval > 0
+		previous = change.Time
+
+		if !reportedFrequent {
+			windowChanges := changesWithinWindow(entry.IPChanges[:idx+1], change.Time, config.Window)
+			if len(windowChanges) > config.MaxIPChanges {
+				reportedFrequent = true
+				findings = append(findings, newFinding(
+					IPHoppingFindingFrequentChanges,
+					windowChanges,
+					fmt.Sprintf(
+						"%d IP Address changes within %v (maximum %d)",
+						len(windowChanges),
+						config.Window,
+						config.MaxIPChanges,
+					),
+				))
+			}
+		}
+
+		if change.OldIPAddress == "" || change.NewIPAddress == "" {
+			continue
+		}
+
+		if !reportedNetwork && hasInterval && interval < config.MinNetworkChangeInterval &&
+			!sameNetwork(change.OldIPAddress, change.NewIPAddress, config) {
+			reportedNetwork = true
+			findings = append(findings, newFinding(
+				IPHoppingFindingRapidNetworkChange,
+				IPChanges{change},
+				fmt.Sprintf(
+					"moved from network of %s to network of %s within %v (minimum %v)",
+					change.OldIPAddress,
+					change.NewIPAddress,
+					interval,
+					config.MinNetworkChangeInterval,
+				),
+			))
+		}
+
+		if !reportedTravel && hasInterval && config.Locator != nil {
+			finding, ok := impossibleTravel(change, interval, config)
+			if ok {
+				reportedTravel = true
+				finding.UserSession = entry.UserSession()
+				finding.SessionEntry = entry
+				findings = append(findings, finding)
+			}
+		}
+	}
+
+	return findings
+}
+
+// impossibleTravel evaluates whether the given IP Address change implies
+// travel faster than the configured maximum travel speed.
+func impossibleTravel(change IPChange, interval time.Duration, config IPHoppingConfig) (IPHoppingFinding, bool) {
+
+	from, fromErr := config.Locator.Locate(change.OldIPAddress)
+	to, toErr := config.Locator.Locate(change.NewIPAddress)
+
+	for _, err := range []error{fromErr, toErr} {
+		if err != nil && !errors.Is(err, geoip.ErrNotFound) {
+			ezproxy.Logger.Printf(
+				"Failed to locate IP Addresses for session %q: %v\n",
+				change.SessionID,
+				err,
+			)
+		}
+	}
+
+	if fromErr != nil || toErr != nil {
+		return IPHoppingFinding{}, false
+	}
+
+	distance := geoip.Distance(from, to)
+	if distance < config.MinTravelDistance || distance == 0 {
+		return IPHoppingFinding{}, false
+	}
+
+	hours := interval.Hours()
+	if hours <= 0 {
+		return IPHoppingFinding{}, false
+	}
+
+	speed := distance / hours
+	if speed <= config.MaxTravelSpeed {
+		return IPHoppingFinding{}, false
+	}
+
+	return IPHoppingFinding{
+		Kind:      IPHoppingFindingImpossibleTravel,
+		IPChanges: IPChanges{change},
+		From:      from,
+		To:        to,
+		Distance:  distance,
+		Speed:     speed,
+		Message: fmt.Sprintf(
+			"moved %.0f km from %s to %s within %v (%.0f km/h, maximum %.0f km/h)",
+			distance,
+			change.OldIPAddress,
+			change.NewIPAddress,
+			interval,
+			speed,
+			config.MaxTravelSpeed,
+		),
+	}, true
+}
+
+// changesWithinWindow returns the IP Address changes which fall within the
+// window ending at the given timestamp.
+func changesWithinWindow(changes IPChanges, end time.Time, window time.Duration) IPChanges {
+	cutoff := end.Add(-window)
+	matching := make(IPChanges, 0, len(changes))
+	for _, change := range changes {
+		if !change.Time.Before(cutoff) {
+			matching = append(matching, change)
+		}
+	}
+	return matching
+}
+
+// sameNetwork reports whether the two IP Addresses belong to the same network
+// using the configured prefix lengths. IP Addresses which cannot be parsed or
+// which belong to different address families are treated as different
+// networks.
+func sameNetwork(a string, b string, config IPHoppingConfig) bool {
+
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return false
+	}
+
+	switch a4, b4 := ipA.To4(), ipB.To4(); {
+	case a4 != nil && b4 != nil:
+		mask := net.CIDRMask(config.IPv4NetworkPrefixLength, 32)
+		return a4.Mask(mask).Equal(b4.Mask(mask))
+	case a4 == nil && b4 == nil:
+		mask := net.CIDRMask(config.IPv6NetworkPrefixLength, 128)
+		return ipA.Mask(mask).Equal(ipB.Mask(mask))
+	default:
+		return false
+	}
+}
+
+// UserSessions returns the distinct user sessions associated with the
+// findings. The returned collection is suitable for use with the
+// ezproxy.UserSessions.Terminate method.
+func (f IPHoppingFindings) UserSessions() ezproxy.UserSessions {
+
+	userSessions := make(ezproxy.UserSessions, 0, len(f))
+	seen := make(map[string]struct{}, len(f))
+
+	for idx := range f {
+		if _, ok := seen[f[idx].UserSession.SessionID]; ok {
+			continue
+		}
+		seen[f[idx].UserSession.SessionID] = struct{}{}
+		userSessions = append(userSessions, f[idx].UserSession)
+	}
+
+	return userSessions
+}
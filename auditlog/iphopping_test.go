@@ -0,0 +1,225 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/atc0005/go-ezproxy/geoip"
+)
+
+// testEvents parses the given audit log lines.
+func testEvents(t *testing.T, lines ...string) Events {
+	t.Helper()
+
+	events := make(Events, 0, len(lines))
+	for _, line := range lines {
+		event, err := ParseEvent(line)
+		if err != nil {
+			t.Fatalf("ParseEvent(%q) error = %v", line, err)
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// testLocator locates IP Addresses in 192.0.2.0/24 in New York and IP
+// Addresses in 198.51.100.0/24 in London.
+type testLocator struct{}
+
+func (testLocator) Locate(ipAddress string) (geoip.Location, error) {
+	switch {
+	case strings.HasPrefix(ipAddress, "192.0.2."):
+		return geoip.Location{City: "New York", Latitude: 40.71, Longitude: -74.01, HasCoordinates: true}, nil
+	case strings.HasPrefix(ipAddress, "198.51.100."):
+		return geoip.Location{City: "London", Latitude: 51.51, Longitude: -0.13, HasCoordinates: true}, nil
+	default:
+		return geoip.Location{}, geoip.ErrNotFound
+	}
+}
+
+func TestDetectIPHopping(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		lines   []string
+		locator bool
+		// want is the list of finding kinds and session IDs.
+		want []string
+	}{
+		{
+			name: "frequent changes in ended session",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:10:00\tSession.IPChange\t192.0.2.11\talice\tsessA\t192.0.2.10",
+				"2020-09-25 10:20:00\tSession.IPChange\t192.0.2.12\talice\tsessA\t192.0.2.11",
+				"2020-09-25 10:30:00\tSession.IPChange\t192.0.2.13\talice\tsessA\t192.0.2.12",
+				"2020-09-25 10:40:00\tSession.IPChange\t192.0.2.14\talice\tsessA\t192.0.2.13",
+				"2020-09-25 10:50:00\tLogout\talice\tsessA\t",
+			},
+			want: []string{"frequent-ip-changes:sessA"},
+		},
+		{
+			name: "changes spread beyond window",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:10:00\tSession.IPChange\t192.0.2.11\talice\tsessA\t192.0.2.10",
+				"2020-09-25 10:40:00\tSession.IPChange\t192.0.2.12\talice\tsessA\t192.0.2.11",
+				"2020-09-25 11:10:00\tSession.IPChange\t192.0.2.13\talice\tsessA\t192.0.2.12",
+				"2020-09-25 11:40:00\tSession.IPChange\t192.0.2.14\talice\tsessA\t192.0.2.13",
+			},
+		},
+		{
+			name: "rapid network change in ended session",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:02:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+				"2020-09-25 10:03:00\tLogout\talice\tsessA\t",
+			},
+			want: []string{"rapid-network-change:sessA"},
+		},
+		{
+			name: "same network change",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:02:00\tSession.IPChange\t192.0.2.20\talice\tsessA\t192.0.2.10",
+			},
+		},
+		{
+			name: "network change at minimum interval",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:05:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+			},
+		},
+		{
+			name: "change recorded in same second as login",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:00:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+			},
+			locator: true,
+		},
+		{
+			name: "relogin time not used as interval start",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success.Relogin\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:01:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+			},
+		},
+		{
+			name: "session ID reused after logout",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:01:00\tLogout\talice\tsessA\t",
+				"2020-09-25 10:02:00\tLogin.Success\t198.51.100.20\tbob\tsessA\t",
+			},
+		},
+		{
+			name: "impossible travel",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 11:00:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+				"2020-09-25 11:30:00\tLogout\talice\tsessA\t",
+			},
+			locator: true,
+			want:    []string{"impossible-travel:sessA"},
+		},
+		{
+			name: "possible travel",
+			lines: []string{
+				"2020-09-25 00:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:00:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+			},
+			locator: true,
+		},
+		{
+			name: "sessions evaluated separately",
+			lines: []string{
+				"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+				"2020-09-25 10:00:30\tLogin.Success\t192.0.2.30\tbob\tsessB\t",
+				"2020-09-25 10:01:00\tSession.IPChange\t198.51.100.20\tbob\tsessB\t192.0.2.30",
+			},
+			want: []string{"rapid-network-change:sessB"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultIPHoppingConfig()
+			if tt.locator {
+				config.Locator = testLocator{}
+			}
+
+			findings, err := DetectIPHopping(testEvents(t, tt.lines...), config)
+			if err != nil {
+				t.Fatalf("DetectIPHopping() error = %v", err)
+			}
+
+			got := make([]string, 0, len(findings))
+			for _, finding := range findings {
+				got = append(got, finding.Kind+":"+finding.UserSession.SessionID)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got findings %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectIPHoppingImpossibleTravelDetails(t *testing.T) {
+
+	config := DefaultIPHoppingConfig()
+	config.Locator = testLocator{}
+
+	findings, err := DetectIPHopping(testEvents(t,
+		"2020-09-25 10:00:00\tLogin.Success\t192.0.2.10\talice\tsessA\t",
+		"2020-09-25 11:00:00\tSession.IPChange\t198.51.100.20\talice\tsessA\t192.0.2.10",
+	), config)
+	if err != nil {
+		t.Fatalf("DetectIPHopping() error = %v", err)
+	}
+
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1", len(findings))
+	}
+
+	finding := findings[0]
+	switch {
+	case finding.From.City != "New York" || finding.To.City != "London":
+		t.Errorf("got travel from %q to %q", finding.From.City, finding.To.City)
+	case finding.Distance < 5500 || finding.Distance > 5650:
+		t.Errorf("got distance %.0f km, want about 5570 km", finding.Distance)
+	case finding.Speed != finding.Distance:
+		t.Errorf("got speed %.0f km/h, want %.0f km/h over one hour", finding.Speed, finding.Distance)
+	case finding.UserSession.Username != "alice":
+		t.Errorf("got username %q, want alice", finding.UserSession.Username)
+	}
+}
+
+func TestDetectIPHoppingInvalidConfig(t *testing.T) {
+
+	config := DefaultIPHoppingConfig()
+	config.Window = 0
+
+	if _, err := DetectIPHopping(nil, config); err == nil {
+		t.Error("DetectIPHopping() error = nil, want error for invalid window")
+	}
+}
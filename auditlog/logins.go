@@ -0,0 +1,439 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// These are the kinds of findings reported by the failed login analysis.
+const (
+
+	// LoginFindingPasswordSpray is reported when failed logins for many
+	// usernames originate from a single IP Address.
+	LoginFindingPasswordSpray string = "password-spray"
+
+	// LoginFindingBruteForce is reported when many failed logins are recorded
+	// for a single username.
+	LoginFindingBruteForce string = "brute-force"
+
+	// LoginFindingFailureThenSuccess is reported when a burst of failed
+	// logins for a single username is followed by a successful login.
+	LoginFindingFailureThenSuccess string = "failure-then-success"
+)
+
+// These are the default settings used for the failed login analysis.
+const (
+
+	// DefaultLoginAnalysisWindow is the default sliding window within which
+	// failed logins are counted.
+	DefaultLoginAnalysisWindow time.Duration = 15 * time.Minute
+
+	// DefaultPasswordSprayThreshold is the default number of distinct
+	// usernames with failed logins from a single IP Address within the window
+	// required to report a password spray finding.
+	DefaultPasswordSprayThreshold int = 5
+
+	// DefaultBruteForceThreshold is the default number of failed logins for a
+	// single username within the window required to report a brute force
+	// finding.
+	DefaultBruteForceThreshold int = 10
+
+	// DefaultFailureBurstThreshold is the default number of failed logins for
+	// a single username within the window that must precede a successful
+	// login in order to report a failure then success finding.
+	DefaultFailureBurstThreshold int = 3
+)
+
+// LoginAnalysisConfig provides the settings used for the failed login
+// analysis.
+type LoginAnalysisConfig struct {
+
+	// Window is the sliding window within which failed logins are counted.
+	Window time.Duration
+
+	// PasswordSprayThreshold is the number of distinct usernames with failed
+	// logins from a single IP Address within Window required to report a
+	// password spray finding.
+	PasswordSprayThreshold int
+
+	// BruteForceThreshold is the number of failed logins for a single
+	// username within Window required to report a brute force finding.
+	BruteForceThreshold int
+
+	// FailureBurstThreshold is the number of failed logins for a single
+	// username within Window that must precede a successful login in order
+	// to report a failure then success finding.
+	FailureBurstThreshold int
+}
+
+// LoginFinding reflects a suspicious pattern of failed logins.
+type LoginFinding struct {
+
+	// Kind is the kind of finding (e.g., password-spray, brute-force).
+	Kind string
+
+	// IPAddress is the source IP Address for password spray findings.
+	IPAddress string
+
+	// Username is the targeted username for brute force and failure then
+	// success findings.
+	Username string
+
+	// Usernames is the list of distinct usernames with failed logins
+	// associated with the finding.
+	Usernames []string
+
+	// IPAddresses is the list of distinct source IP Addresses of the failed
+	// logins associated with the finding.
+	IPAddresses []string
+
+	// Failures is the collection of failed login events associated with the
+	// finding.
+	Failures Events
+
+	// FirstSeen is the timestamp of the earliest failed login associated
+	// with the finding.
+	FirstSeen time.Time
+
+	// LastSeen is the timestamp of the latest failed login associated with
+	// the finding.
+	LastSeen time.Time
+
+	// Successes is the collection of successful logins which followed the
+	// failed logins associated with the finding. These values can be
+	// converted to UserSession values in order to terminate the sessions.
+	Successes SessionEntries
+
+	// usernames and ipAddresses index the Usernames and IPAddresses values.
+	usernames   map[string]struct{}
+	ipAddresses map[string]struct{}
+}
+
+// LoginFindings is a collection of LoginFinding values that is intended for
+// aggregation before bulk processing of some kind.
+type LoginFindings []LoginFinding
+
+// DefaultLoginAnalysisConfig returns a LoginAnalysisConfig using the default
+// settings.
+func DefaultLoginAnalysisConfig() LoginAnalysisConfig {
+	return LoginAnalysisConfig{
+		Window:                 DefaultLoginAnalysisWindow,
+		PasswordSprayThreshold: DefaultPasswordSprayThreshold,
+		BruteForceThreshold:    DefaultBruteForceThreshold,
+		FailureBurstThreshold:  DefaultFailureBurstThreshold,
+	}
+}
+
+// validate asserts that the settings are usable.
+func (c LoginAnalysisConfig) validate() error {
+	switch {
+	case c.Window <= 0:
+		return fmt.Errorf("%v is not a valid window", c.Window)
+	case c.PasswordSprayThreshold < 1:
+		return fmt.Errorf("%d is not a valid password spray threshold", c.PasswordSprayThreshold)
+	case c.BruteForceThreshold < 1:
+		return fmt.Errorf("%d is not a valid brute force threshold", c.BruteForceThreshold)
+	case c.FailureBurstThreshold < 1:
+		return fmt.Errorf("%d is not a valid failure burst threshold", c.FailureBurstThreshold)
+	default:
+		return nil
+	}
+}
+
+// loginTracker tracks failed logins for a single key (IP Address or
+// username) within a sliding window along with any open finding for that
+// key. The number of failed logins within the window for each (lowercase)
+// username is kept up to date as failed logins are added and pruned.
+type loginTracker struct {
+	failures  Events
+	usernames map[string]int
+	finding   *LoginFinding
+}
+
+// add records the given failed login.
+func (lt *loginTracker) add(failure Event) {
+	lt.failures = append(lt.failures, failure)
+
+	if username := strings.ToLower(failure.Username); username != "" {
+		if lt.usernames == nil {
+			lt.usernames = make(map[string]int, ezproxy.SessionsLimit)
+		}
+		lt.usernames[username]++
+	}
+}
+
+// reset removes all failed logins and closes any open finding.
+func (lt *loginTracker) reset() {
+	lt.failures = nil
+	lt.usernames = nil
+	lt.finding = nil
+}
+
+// prune removes failed logins which fall outside of the window ending at the
+// given timestamp and closes any open finding which has gone stale. Failed
+// logins are recorded in chronological order.
+func (lt *loginTracker) prune(end time.Time, window time.Duration) {
+	cutoff := end.Add(-window)
+
+	pruned := 0
+	for _, failure := range lt.failures {
+		if !failure.Time.Before(cutoff) {
+			break
+		}
+		if username := strings.ToLower(failure.Username); username != "" {
+			lt.usernames[username]--
+			if lt.usernames[username] == 0 {
+				delete(lt.usernames, username)
+			}
+		}
+		pruned++
+	}
+	lt.failures = lt.failures[pruned:]
+
+	if lt.finding != nil && lt.finding.LastSeen.Before(cutoff) {
+		lt.finding = nil
+	}
+}
+
+// sweepTrackers prunes each tracker in the given index using the window
+// ending at the given timestamp and removes the trackers which no longer
+// hold failed logins or an open finding. This keeps the number of trackers
+// bounded by the failed logins within the window instead of growing with
+// every IP Address and username seen.
+func sweepTrackers(index map[string]*loginTracker, end time.Time, window time.Duration) {
+	for key, lt := range index {
+		lt.prune(end, window)
+		if len(lt.failures) == 0 && lt.finding == nil {
+			delete(index, key)
+		}
+	}
+}
+
+// AnalyzeLogins groups failed login events by username and by source IP
+// Address within sliding windows in order to detect password spraying (one
+// IP Address, many usernames), brute force attempts (one username, many
+// failures) and bursts of failed logins followed by a successful login. The
+// events are analyzed in chronological order. Each finding includes the
+// SessionEntry values for any successful logins which followed the failed
+// logins so that the associated sessions can be terminated.
+//
+// Only Login.Success events are treated as successful logins.
+// Login.Success.Relogin events are recorded when an existing session cookie
+// is reused rather than when credentials are checked, so they neither show
+// that a guessed password worked nor end a burst of failed logins.
+func AnalyzeLogins(events Events, config LoginAnalysisConfig) (LoginFindings, error) {
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("func AnalyzeLogins: invalid config: %w", err)
+	}
+
+	sorted := make(Events, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	byIP := make(map[string]*loginTracker, ezproxy.AllUsersSessionsLimit)
+	byUsername := make(map[string]*loginTracker, ezproxy.AllUsersSessionsLimit)
+
+	tracker := func(index map[string]*loginTracker, key string) *loginTracker {
+		lt, ok := index[key]
+		if !ok {
+			lt = &loginTracker{}
+			index[key] = lt
+		}
+		return lt
+	}
+
+	var findings []*LoginFinding
+
+	// lastSweep is the timestamp of the event at which idle trackers were
+	// last removed; trackers are swept once per window.
+	var lastSweep time.Time
+
+	for _, event := range sorted {
+
+		if event.Time.Sub(lastSweep) >= config.Window {
+			sweepTrackers(byIP, event.Time, config.Window)
+			sweepTrackers(byUsername, event.Time, config.Window)
+			lastSweep = event.Time
+		}
+
+		username := strings.ToLower(event.Username)
+
+		switch event.Type {
+		case EventLoginFailure:
+
+			if event.IPAddress != "" {
+				lt := tracker(byIP, event.IPAddress)
+				lt.prune(event.Time, config.Window)
+				lt.add(event)
+
+				switch {
+				case lt.finding != nil:
+					lt.finding.addFailure(event)
+
+				case len(lt.usernames) >= config.PasswordSprayThreshold:
+					lt.finding = newLoginFinding(LoginFindingPasswordSpray, lt.failures)
+					lt.finding.IPAddress = event.IPAddress
+					findings = append(findings, lt.finding)
+				}
+			}
+
+			if username != "" {
+				lt := tracker(byUsername, username)
+				lt.prune(event.Time, config.Window)
+				lt.add(event)
+
+				switch {
+				case lt.finding != nil:
+					lt.finding.addFailure(event)
+
+				case len(lt.failures) >= config.BruteForceThreshold:
+					lt.finding = newLoginFinding(LoginFindingBruteForce, lt.failures)
+					lt.finding.Username = event.Username
+					findings = append(findings, lt.finding)
+				}
+			}
+
+		case EventLoginSuccess:
+
+			success := event.SessionEntry()
+
+			if lt, ok := byIP[event.IPAddress]; ok && event.IPAddress != "" {
+				lt.prune(event.Time, config.Window)
+				if lt.finding != nil && lt.finding.hasUsername(username) {
+					lt.finding.Successes = append(lt.finding.Successes, success)
+				}
+			}
+
+			lt, ok := byUsername[username]
+			if !ok || username == "" {
+				continue
+			}
+			lt.prune(event.Time, config.Window)
+
+			if lt.finding != nil {
+				lt.finding.Successes = append(lt.finding.Successes, success)
+			}
+
+			if len(lt.failures) >= config.FailureBurstThreshold {
+				finding := newLoginFinding(LoginFindingFailureThenSuccess, lt.failures)
+				finding.Username = event.Username
+				finding.Successes = SessionEntries{success}
+				findings = append(findings, finding)
+			}
+
+			// The successful login ends the current burst of failed logins
+			// for this username.
+			lt.reset()
+		}
+	}
+
+	results := make(LoginFindings, 0, len(findings))
+	for _, finding := range findings {
+		results = append(results, *finding)
+	}
+
+	ezproxy.Logger.Printf("Found %d failed login findings\n", len(results))
+
+	return results, nil
+}
+
+// newLoginFinding creates a new LoginFinding of the specified kind from the
+// given failed login events.
+func newLoginFinding(kind string, failures Events) *LoginFinding {
+	finding := LoginFinding{
+		Kind:     kind,
+		Failures: make(Events, 0, len(failures)),
+	}
+	for _, failure := range failures {
+		finding.addFailure(failure)
+	}
+	return &finding
+}
+
+// addFailure records the given failed login event in the finding.
+func (lf *LoginFinding) addFailure(failure Event) {
+	lf.Failures = append(lf.Failures, failure)
+
+	if lf.usernames == nil {
+		lf.usernames = make(map[string]struct{}, ezproxy.SessionsLimit)
+		lf.ipAddresses = make(map[string]struct{}, ezproxy.SessionsLimit)
+	}
+
+	if username := strings.ToLower(failure.Username); username != "" {
+		if _, ok := lf.usernames[username]; !ok {
+			lf.usernames[username] = struct{}{}
+			lf.Usernames = append(lf.Usernames, username)
+		}
+	}
+
+	if failure.IPAddress != "" {
+		if _, ok := lf.ipAddresses[failure.IPAddress]; !ok {
+			lf.ipAddresses[failure.IPAddress] = struct{}{}
+			lf.IPAddresses = append(lf.IPAddresses, failure.IPAddress)
+		}
+	}
+
+	if lf.FirstSeen.IsZero() || failure.Time.Before(lf.FirstSeen) {
+		lf.FirstSeen = failure.Time
+	}
+	if failure.Time.After(lf.LastSeen) {
+		lf.LastSeen = failure.Time
+	}
+}
+
+// hasUsername reports whether the finding includes failed logins for the
+// given (lowercase) username.
+func (lf *LoginFinding) hasUsername(username string) bool {
+	_, ok := lf.usernames[username]
+	return ok
+}
+
+// Succeeded reports whether the failed logins associated with the finding
+// were followed by a successful login.
+func (lf LoginFinding) Succeeded() bool {
+	return len(lf.Successes) > 0
+}
+
+// UserSessions returns the user sessions for the successful logins
+// associated with the findings. The returned collection is suitable for use
+// with the ezproxy.UserSessions.Terminate method.
+func (lf LoginFindings) UserSessions() ezproxy.UserSessions {
+
+	userSessions := make(ezproxy.UserSessions, 0, ezproxy.SessionsLimit)
+	seen := make(map[string]struct{}, ezproxy.SessionsLimit)
+
+	for idx := range lf {
+		for _, success := range lf[idx].Successes {
+			if _, ok := seen[success.SessionID]; ok {
+				continue
+			}
+			seen[success.SessionID] = struct{}{}
+			userSessions = append(userSessions, success.UserSession())
+		}
+	}
+
+	return userSessions
+}
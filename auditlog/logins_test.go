@@ -0,0 +1,333 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testLoginTime is the time of the first login event used by the failed
+// login analysis tests.
+var testLoginTime = time.Date(2020, time.September, 25, 8, 0, 0, 0, time.Local)
+
+// testLoginLine returns an audit log line for a login event of the given
+// type recorded the given offset after testLoginTime.
+func testLoginLine(offset time.Duration, eventType string, ipAddress string, username string) string {
+	sessionID := ""
+	other := "Invalid password"
+	if eventType != EventLoginFailure {
+		sessionID = "s" + username
+		other = ""
+	}
+
+	return fmt.Sprintf(
+		"%s\t%s\t%s\t%s\t%s\t%s",
+		testLoginTime.Add(offset).Format(TimeStampLayout),
+		eventType,
+		ipAddress,
+		username,
+		sessionID,
+		other,
+	)
+}
+
+// testLoginConfig is the configuration used by the failed login analysis
+// tests.
+func testLoginConfig() LoginAnalysisConfig {
+	return LoginAnalysisConfig{
+		Window:                 10 * time.Minute,
+		PasswordSprayThreshold: 3,
+		BruteForceThreshold:    3,
+		FailureBurstThreshold:  2,
+	}
+}
+
+// testFinding summarizes a LoginFinding value for comparison.
+type testFinding struct {
+	Kind      string
+	Key       string
+	Failures  int
+	Successes []string
+}
+
+func TestAnalyzeLogins(t *testing.T) {
+
+	const (
+		ip1 = "192.0.2.10"
+		ip2 = "192.0.2.11"
+		ip3 = "192.0.2.12"
+	)
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  []testFinding
+	}{
+		{
+			name: "brute force below threshold",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip2, "alice"),
+			},
+		},
+		{
+			name: "brute force at threshold",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip2, "Alice"),
+				testLoginLine(2*time.Minute, EventLoginFailure, ip3, "alice"),
+				testLoginLine(3*time.Minute, EventLoginFailure, ip3, "alice"),
+			},
+			want: []testFinding{
+				{LoginFindingBruteForce, "alice", 4, nil},
+			},
+		},
+		{
+			name: "failure at the start of the window is counted",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(5*time.Minute, EventLoginFailure, ip2, "alice"),
+				testLoginLine(10*time.Minute, EventLoginFailure, ip3, "alice"),
+			},
+			want: []testFinding{
+				{LoginFindingBruteForce, "alice", 3, nil},
+			},
+		},
+		{
+			name: "failure before the start of the window is not counted",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(5*time.Minute, EventLoginFailure, ip2, "alice"),
+				testLoginLine(10*time.Minute+time.Second, EventLoginFailure, ip3, "alice"),
+			},
+		},
+		{
+			name: "stale finding is closed",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip2, "alice"),
+				testLoginLine(2*time.Minute, EventLoginFailure, ip3, "alice"),
+				testLoginLine(30*time.Minute, EventLoginFailure, ip1, "alice"),
+				testLoginLine(31*time.Minute, EventLoginFailure, ip2, "alice"),
+				testLoginLine(32*time.Minute, EventLoginFailure, ip3, "alice"),
+			},
+			want: []testFinding{
+				{LoginFindingBruteForce, "alice", 3, nil},
+				{LoginFindingBruteForce, "alice", 3, nil},
+			},
+		},
+		{
+			name: "password spray at threshold",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip1, "bob"),
+				testLoginLine(2*time.Minute, EventLoginFailure, ip1, "Bob"),
+				testLoginLine(3*time.Minute, EventLoginFailure, ip1, "carol"),
+			},
+			want: []testFinding{
+				{LoginFindingPasswordSpray, ip1, 4, nil},
+			},
+		},
+		{
+			name: "password spray usernames outside of the window",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(6*time.Minute, EventLoginFailure, ip1, "bob"),
+				testLoginLine(12*time.Minute, EventLoginFailure, ip1, "carol"),
+			},
+		},
+		{
+			name: "password spray followed by success",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip1, "bob"),
+				testLoginLine(2*time.Minute, EventLoginFailure, ip1, "carol"),
+				testLoginLine(3*time.Minute, EventLoginSuccess, ip1, "dave"),
+				testLoginLine(4*time.Minute, EventLoginSuccess, ip1, "bob"),
+			},
+			want: []testFinding{
+				{LoginFindingPasswordSpray, ip1, 3, []string{"sbob"}},
+			},
+		},
+		{
+			name: "failure then success",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip2, "alice"),
+				testLoginLine(2*time.Minute, EventLoginSuccess, ip2, "alice"),
+			},
+			want: []testFinding{
+				{LoginFindingFailureThenSuccess, "alice", 2, []string{"salice"}},
+			},
+		},
+		{
+			name: "success ends the burst of failures",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginSuccess, ip1, "alice"),
+				testLoginLine(2*time.Minute, EventLoginFailure, ip1, "alice"),
+				testLoginLine(3*time.Minute, EventLoginSuccess, ip1, "alice"),
+			},
+		},
+		{
+			name: "success after the window",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip1, "alice"),
+				testLoginLine(12*time.Minute, EventLoginSuccess, ip1, "alice"),
+			},
+		},
+		{
+			name: "relogin is not a successful login",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip1, "alice"),
+				testLoginLine(2*time.Minute, EventLoginSuccessRelogin, ip1, "alice"),
+			},
+		},
+		{
+			name: "brute force followed by success",
+			lines: []string{
+				testLoginLine(0, EventLoginFailure, ip1, "alice"),
+				testLoginLine(time.Minute, EventLoginFailure, ip2, "alice"),
+				testLoginLine(2*time.Minute, EventLoginFailure, ip3, "alice"),
+				testLoginLine(3*time.Minute, EventLoginSuccess, ip3, "alice"),
+			},
+			want: []testFinding{
+				{LoginFindingBruteForce, "alice", 3, []string{"salice"}},
+				{LoginFindingFailureThenSuccess, "alice", 3, []string{"salice"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := AnalyzeLogins(testEvents(t, tt.lines...), testLoginConfig())
+			if err != nil {
+				t.Fatalf("AnalyzeLogins() error = %v", err)
+			}
+
+			got := make([]testFinding, 0, len(findings))
+			for _, finding := range findings {
+				key := finding.Username
+				if finding.Kind == LoginFindingPasswordSpray {
+					key = finding.IPAddress
+				}
+
+				var successes []string
+				for _, success := range finding.Successes {
+					successes = append(successes, success.SessionID)
+				}
+
+				got = append(got, testFinding{finding.Kind, key, len(finding.Failures), successes})
+			}
+
+			want := tt.want
+			if want == nil {
+				want = []testFinding{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("AnalyzeLogins() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeLoginsUserSessions(t *testing.T) {
+
+	events := testEvents(t,
+		testLoginLine(0, EventLoginFailure, "192.0.2.10", "alice"),
+		testLoginLine(time.Minute, EventLoginFailure, "192.0.2.10", "alice"),
+		testLoginLine(2*time.Minute, EventLoginFailure, "192.0.2.10", "alice"),
+		testLoginLine(3*time.Minute, EventLoginSuccess, "192.0.2.10", "alice"),
+	)
+
+	findings, err := AnalyzeLogins(events, testLoginConfig())
+	if err != nil {
+		t.Fatalf("AnalyzeLogins() error = %v", err)
+	}
+
+	// The same session is associated with the brute force and failure then
+	// success findings.
+	userSessions := findings.UserSessions()
+	if len(userSessions) != 1 || userSessions[0].SessionID != "salice" {
+		t.Errorf("UserSessions() = %+v, want session salice", userSessions)
+	}
+}
+
+func TestAnalyzeLoginsInvalidConfig(t *testing.T) {
+
+	configs := map[string]func(*LoginAnalysisConfig){
+		"window":         func(c *LoginAnalysisConfig) { c.Window = 0 },
+		"password spray": func(c *LoginAnalysisConfig) { c.PasswordSprayThreshold = 0 },
+		"brute force":    func(c *LoginAnalysisConfig) { c.BruteForceThreshold = 0 },
+		"failure burst":  func(c *LoginAnalysisConfig) { c.FailureBurstThreshold = -1 },
+	}
+
+	for name, modify := range configs {
+		t.Run(name, func(t *testing.T) {
+			config := DefaultLoginAnalysisConfig()
+			modify(&config)
+
+			if _, err := AnalyzeLogins(nil, config); err == nil {
+				t.Error("AnalyzeLogins() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestSweepTrackers(t *testing.T) {
+
+	window := 10 * time.Minute
+	end := testLoginTime.Add(time.Hour)
+
+	failure := func(offset time.Duration) Event {
+		return Event{Time: end.Add(-offset), Type: EventLoginFailure, Username: "alice"}
+	}
+
+	index := map[string]*loginTracker{
+		"empty":  {},
+		"stale":  {},
+		"recent": {},
+		"finding": {
+			finding: &LoginFinding{LastSeen: end.Add(-time.Minute)},
+		},
+		"stale finding": {
+			finding: &LoginFinding{LastSeen: end.Add(-time.Hour)},
+		},
+	}
+	index["stale"].add(failure(time.Hour))
+	index["recent"].add(failure(time.Hour))
+	index["recent"].add(failure(time.Minute))
+
+	sweepTrackers(index, end, window)
+
+	got := make([]string, 0, len(index))
+	for key := range index {
+		got = append(got, key)
+	}
+	if len(got) != 2 || index["recent"] == nil || index["finding"] == nil {
+		t.Fatalf("remaining trackers = %v, want [finding recent]", got)
+	}
+
+	if failures := len(index["recent"].failures); failures != 1 {
+		t.Errorf("recent tracker has %d failures, want 1", failures)
+	}
+}
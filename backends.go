@@ -0,0 +1,550 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxAdminResponseSize is the maximum number of bytes read from a response
+// provided by the EZproxy admin web interface.
+const maxAdminResponseSize int64 = 1 << 20
+
+// SessionTerminator is the API for backends which terminate user sessions.
+// Each backend reports the result of terminating a user session using the
+// same TerminateUserSessionResult fields (e.g., ExitCode and StdOut) as the
+// `kill` subcommand of the ezproxy binary so that callers can switch
+// backends without code changes. Use NewTerminator to satisfy the Terminator
+// interface using a SessionTerminator.
+type SessionTerminator interface {
+
+	// TerminateSession terminates the specified user session, giving up if
+	// the provided context is done before the attempt completes.
+	TerminateSession(ctx context.Context, session UserSession) TerminateUserSessionResult
+}
+
+// boundTerminator is a Terminator which terminates a set of user sessions
+// using a SessionTerminator backend.
+type boundTerminator struct {
+	ctx      context.Context
+	backend  SessionTerminator
+	sessions []UserSession
+}
+
+// NewTerminator creates a new Terminator which terminates the provided user
+// sessions in turn using the specified backend (e.g., an ExecTerminator or
+// AdminTerminator) when the Terminate method is called. The provided context
+// is passed to the backend for each session.
+func NewTerminator(ctx context.Context, backend SessionTerminator, sessions ...UserSession) (Terminator, error) {
+
+	if ctx == nil {
+		return nil, errors.New("func NewTerminator: missing context")
+	}
+
+	if backend == nil {
+		return nil, errors.New("func NewTerminator: missing backend")
+	}
+
+	return &boundTerminator{
+		ctx:      ctx,
+		backend:  backend,
+		sessions: sessions,
+	}, nil
+}
+
+// Terminate terminates each of the bound user sessions using the backend,
+// returning the results in the same order as the sessions.
+func (bt *boundTerminator) Terminate() TerminateUserSessionResults {
+	return terminateSessions(bt.ctx, bt.backend, TerminateOptions{}, bt.sessions)
+}
+
+// ExecTerminator is a SessionTerminator which terminates user sessions by
+// calling the `kill` subcommand of the ezproxy binary. This requires running
+// on the EZproxy host.
+type ExecTerminator struct {
+
+	// executable is the path to the (presumably) ezproxy binary.
+	executable string
+}
+
+// NewExecTerminator creates a new ExecTerminator which uses the provided
+// executable to terminate user sessions.
+func NewExecTerminator(executable string) (*ExecTerminator, error) {
+
+	if executable == "" {
+		return nil, errors.New("func NewExecTerminator: missing executable")
+	}
+
+	return &ExecTerminator{executable: executable}, nil
+}
+
+// TerminateSession terminates the specified user session by calling the
+// `kill` subcommand of the ezproxy binary.
+func (et *ExecTerminator) TerminateSession(ctx context.Context, session UserSession) TerminateUserSessionResult {
+
+	if ctx == nil {
+		return TerminateUserSessionResult{
+			UserSession: session,
+			ExitCode:    -1,
+			Error:       errors.New("func TerminateSession: missing context"),
+		}
+	}
+
+	return terminateSession(ctx, et.executable, session)
+}
+
+// DryRunSession resolves the path to the ezproxy binary and records the
+// command line which would have been run to terminate the specified user
+// session, without running it.
+func (et *ExecTerminator) DryRunSession(session UserSession) TerminateUserSessionResult {
+
+	result := TerminateUserSessionResult{
+		UserSession: session,
+		ExitCode:    -1,
+	}
+
+	path, err := exec.LookPath(et.executable)
+	if err != nil {
+		result.Error = fmt.Errorf("func DryRunSession: %w", err)
+		return result
+	}
+
+	result.Command = commandLine(path, session.SessionID)
+
+	return result
+}
+
+// AdminInterface describes the pages of the EZproxy admin web interface used
+// by an AdminTerminator. The paths, form field names and response text vary
+// between EZproxy versions and with customizations of the login page, so no
+// defaults are provided; confirm each value against the EZproxy server in
+// use.
+type AdminInterface struct {
+
+	// LoginPath is the path of the EZproxy login form used to authenticate
+	// the admin account. Paths are resolved relative to the root of the base
+	// URL.
+	LoginPath string
+
+	// UsernameField and PasswordField are the names of the login form fields
+	// used to submit the admin account credentials. Any response containing
+	// a form field named PasswordField is treated as the login page.
+	UsernameField string
+	PasswordField string
+
+	// KillPath is the path of the page used to terminate user sessions.
+	KillPath string
+
+	// KillParameter is the name of the form field used to specify the
+	// session ID to terminate.
+	KillParameter string
+
+	// TerminatedText is text included in the response body only when a
+	// session has been terminated. Any %s in the text is replaced with the
+	// session ID.
+	TerminatedText string
+
+	// NotFoundText is optional text included in the response body when the
+	// session does not exist. Any %s in the text is replaced with the session
+	// ID. If not set, these responses are reported with an unknown outcome.
+	NotFoundText string
+}
+
+// validate returns an error if any required AdminInterface value is missing
+// or invalid.
+func (ai AdminInterface) validate() error {
+
+	switch {
+	case !strings.HasPrefix(ai.LoginPath, "/"):
+		return fmt.Errorf("login path %q is not a valid path", ai.LoginPath)
+	case ai.UsernameField == "":
+		return errors.New("missing username field name")
+	case ai.PasswordField == "":
+		return errors.New("missing password field name")
+	case !strings.HasPrefix(ai.KillPath, "/"):
+		return fmt.Errorf("kill path %q is not a valid path", ai.KillPath)
+	case ai.KillParameter == "":
+		return errors.New("missing kill parameter name")
+	case ai.TerminatedText == "":
+		return errors.New("missing terminated text")
+	}
+
+	return nil
+}
+
+// AdminTerminator is a SessionTerminator which terminates user sessions via
+// the EZproxy admin web interface over HTTPS using the credentials of an
+// account with admin access. This does not require running on the EZproxy
+// host.
+//
+// Responses are mapped onto the same TerminateUserSessionResult fields used
+// for the `kill` subcommand of the ezproxy binary. A response containing the
+// configured TerminatedText is reported with the
+// KillSubCmdExitCodeSessionTerminated exit code and a response containing
+// the configured NotFoundText is reported with the
+// KillSubCmdExitCodeSessionDoesNotExist exit code, with the StdOut field set
+// to the text the `kill` subcommand would have output. Any other response is
+// reported with an exit code of -1 and the Error field set; successful
+// responses which do not confirm the outcome match ErrOutcomeUnknown. This
+// allows the Outcome method to report the same outcomes for both backends.
+type AdminTerminator struct {
+
+	// baseURL is the URL of the EZproxy server (e.g.,
+	// https://ezproxy.example.com:2443).
+	baseURL *url.URL
+
+	// username and password are the credentials of an account with admin
+	// access.
+	username string
+	password string
+
+	// admin describes the pages of the EZproxy admin web interface.
+	admin AdminInterface
+
+	// loginFormRegex matches the password field of the EZproxy login form.
+	loginFormRegex *regexp.Regexp
+
+	// client is the HTTP client used for all requests. A cookie jar is
+	// used to retain the admin session between requests.
+	client *http.Client
+
+	// mu guards loggedIn.
+	mu sync.Mutex
+
+	// loggedIn indicates whether the admin account has logged in.
+	loggedIn bool
+}
+
+// adminResponse is a response provided by the EZproxy admin web interface.
+type adminResponse struct {
+
+	// status is the HTTP status code of the response.
+	status int
+
+	// url is the URL of the final request after following any redirects.
+	url *url.URL
+
+	// body is the (possibly truncated) response body.
+	body string
+}
+
+// NewAdminTerminator creates a new AdminTerminator which uses the EZproxy
+// admin web interface described by admin at the specified base URL with the
+// provided admin account credentials. The base URL must use the https scheme
+// to avoid sending the credentials in cleartext.
+func NewAdminTerminator(baseURL string, username string, password string, admin AdminInterface) (*AdminTerminator, error) {
+
+	if baseURL == "" {
+		return nil, errors.New("func NewAdminTerminator: missing base URL")
+	}
+
+	if username == "" {
+		return nil, errors.New("func NewAdminTerminator: missing username")
+	}
+
+	if password == "" {
+		return nil, errors.New("func NewAdminTerminator: missing password")
+	}
+
+	if err := admin.validate(); err != nil {
+		return nil, fmt.Errorf("func NewAdminTerminator: invalid admin interface: %w", err)
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("func NewAdminTerminator: invalid base URL %q: %w", baseURL, err)
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf(
+			"func NewAdminTerminator: base URL %q must use the https scheme and include a host",
+			u.Redacted(),
+		)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("func NewAdminTerminator: failed to create cookie jar: %w", err)
+	}
+
+	return &AdminTerminator{
+		baseURL:  u,
+		username: username,
+		password: password,
+		admin:    admin,
+		loginFormRegex: regexp.MustCompile(
+			`(?i)<input\b[^>]*\bname\s*=\s*["']?` + regexp.QuoteMeta(admin.PasswordField) + `["'\s/>]`,
+		),
+		client: &http.Client{Jar: jar},
+	}, nil
+}
+
+// SetHTTPClient is a helper method for setting the HTTP client used for all
+// requests (e.g., to apply custom TLS settings). A cookie jar is added to
+// the client if it does not already have one.
+func (at *AdminTerminator) SetHTTPClient(client *http.Client) error {
+	if client == nil {
+		return errors.New("func SetHTTPClient: missing client")
+	}
+
+	if client.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return fmt.Errorf("func SetHTTPClient: failed to create cookie jar: %w", err)
+		}
+		client.Jar = jar
+	}
+
+	at.mu.Lock()
+	at.client = client
+	at.loggedIn = false
+	at.mu.Unlock()
+
+	return nil
+}
+
+// TerminateSession terminates the specified user session via the EZproxy
+// admin web interface, logging in first if needed. EZproxy responds to
+// requests made with an expired admin session with the login page rather than
+// an error status, so if the login page is returned (or access is denied) the
+// admin account logs in again and the attempt is retried once.
+func (at *AdminTerminator) TerminateSession(ctx context.Context, session UserSession) TerminateUserSessionResult {
+
+	result := TerminateUserSessionResult{
+		UserSession: session,
+		ExitCode:    -1,
+	}
+
+	if ctx == nil {
+		result.Error = errors.New("func TerminateSession: missing context")
+		return result
+	}
+
+	if session.SessionID == "" {
+		result.ExitCode = KillSubCmdExitCodeSessionNotSpecified
+		result.StdOut = KillSubCmdExitTextSessionNotSpecified
+		return result
+	}
+
+	Logger.Printf(
+		"Terminating session %q for username %q via %s ... ",
+		session.SessionID,
+		session.Username,
+		at.baseURL.Redacted(),
+	)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+
+		if err := at.login(ctx, attempt > 1); err != nil {
+			result.Error = fmt.Errorf("func TerminateSession: %w", err)
+			return result
+		}
+
+		at.mu.Lock()
+		client := at.client
+		at.mu.Unlock()
+
+		resp, err := post(ctx, client, at.resolve(at.admin.KillPath), url.Values{
+			at.admin.KillParameter: {session.SessionID},
+		})
+		if err != nil {
+			result.Error = fmt.Errorf("func TerminateSession: %w", err)
+			return result
+		}
+
+		Logger.Printf("Response status: %d\n", resp.status)
+
+		switch {
+
+		// the admin session has expired; log in again and retry
+		case resp.status == http.StatusUnauthorized ||
+			resp.status == http.StatusForbidden ||
+			at.isLoginPage(resp):
+			if attempt > 1 {
+				result.Error = fmt.Errorf(
+					"func TerminateSession: admin access denied terminating session %q: HTTP status %d",
+					session.SessionID,
+					resp.status,
+				)
+				return result
+			}
+			continue
+
+		case resp.status < 200 || resp.status >= 300:
+			result.Error = fmt.Errorf(
+				"func TerminateSession: unexpected response terminating session %q: HTTP status %d",
+				session.SessionID,
+				resp.status,
+			)
+			return result
+
+		case at.admin.NotFoundText != "" &&
+			containsText(resp.body, at.admin.NotFoundText, session.SessionID):
+			result.ExitCode = KillSubCmdExitCodeSessionDoesNotExist
+			result.StdOut = fmt.Sprintf(KillSubCmdExitTextTemplateSessionDoesNotExist, session.SessionID)
+			return result
+
+		case containsText(resp.body, at.admin.TerminatedText, session.SessionID):
+			result.ExitCode = KillSubCmdExitCodeSessionTerminated
+			result.StdOut = fmt.Sprintf(KillSubCmdExitTextTemplateSessionTerminated, session.SessionID)
+			return result
+
+		default:
+			result.Error = fmt.Errorf(
+				"func TerminateSession: %w: response for session %q did not confirm termination",
+				ErrOutcomeUnknown,
+				session.SessionID,
+			)
+			return result
+		}
+	}
+
+	return result
+}
+
+// Terminate terminates each of the provided user sessions in turn via the
+// EZproxy admin web interface, returning the results in the same order as
+// the provided sessions.
+func (at *AdminTerminator) Terminate(ctx context.Context, sessions ...UserSession) TerminateUserSessionResults {
+	return terminateSessions(ctx, at, TerminateOptions{}, sessions)
+}
+
+// DryRunSession records the request which would have been made to terminate
+// the specified user session via the EZproxy admin web interface, without
+// making it. The admin account credentials are not checked.
+func (at *AdminTerminator) DryRunSession(session UserSession) TerminateUserSessionResult {
+	return TerminateUserSessionResult{
+		UserSession: session,
+		ExitCode:    -1,
+		Command: fmt.Sprintf(
+			"%s %s %s=%s",
+			http.MethodPost,
+			at.resolve(at.admin.KillPath).Redacted(),
+			at.admin.KillParameter,
+			session.SessionID,
+		),
+	}
+}
+
+// login submits the admin account credentials to the EZproxy login form if
+// not already logged in (or if forced). The login is treated as failed if the
+// login form is returned again.
+func (at *AdminTerminator) login(ctx context.Context, force bool) error {
+
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	if at.loggedIn && !force {
+		return nil
+	}
+
+	at.loggedIn = false
+
+	Logger.Printf("Logging in to %s as %q\n", at.baseURL.Redacted(), at.username)
+
+	resp, err := post(ctx, at.client, at.resolve(at.admin.LoginPath), url.Values{
+		at.admin.UsernameField: {at.username},
+		at.admin.PasswordField: {at.password},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+
+	if resp.status < 200 || resp.status >= 300 {
+		return fmt.Errorf("failed to log in as %q: HTTP status %d", at.username, resp.status)
+	}
+
+	if at.loginFormRegex.MatchString(resp.body) {
+		return fmt.Errorf("failed to log in as %q: login form returned", at.username)
+	}
+
+	at.loggedIn = true
+
+	return nil
+}
+
+// isLoginPage indicates whether the response is the EZproxy login page,
+// either because the request was redirected to the login path or because the
+// login form is included in the response body.
+func (at *AdminTerminator) isLoginPage(resp adminResponse) bool {
+	if resp.url != nil && resp.url.Path == at.admin.LoginPath {
+		return true
+	}
+
+	return at.loginFormRegex.MatchString(resp.body)
+}
+
+// resolve returns the URL for the specified path on the EZproxy server.
+func (at *AdminTerminator) resolve(path string) *url.URL {
+	return at.baseURL.ResolveReference(&url.URL{Path: path})
+}
+
+// containsText indicates whether the response body contains the provided
+// text, with any %s in the text replaced with the session ID.
+func containsText(body string, text string, sessionID string) bool {
+	return strings.Contains(body, strings.ReplaceAll(text, "%s", sessionID))
+}
+
+// post submits the provided form values to the specified URL, returning the
+// response status code, final URL and body.
+func post(ctx context.Context, client *http.Client, target *url.URL, values url.Values) (adminResponse, error) {
+
+	path := target.Path
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		target.String(),
+		strings.NewReader(values.Encode()),
+	)
+	if err != nil {
+		return adminResponse{}, fmt.Errorf("failed to create request for %q: %w", path, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return adminResponse{}, fmt.Errorf("request to %q failed: %w", path, err)
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			Logger.Printf("post: failed to close response body: %s", err.Error())
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAdminResponseSize))
+	if err != nil {
+		return adminResponse{}, fmt.Errorf("failed to read response from %q: %w", path, err)
+	}
+
+	return adminResponse{
+		status: resp.StatusCode,
+		url:    resp.Request.URL,
+		body:   string(body),
+	}, nil
+}
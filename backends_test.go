@@ -0,0 +1,375 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testAdminUsername = "admin"
+	testAdminPassword = "secret"
+)
+
+// testAdminInterface describes the pages provided by fakeEZproxy.
+var testAdminInterface = AdminInterface{
+	LoginPath:      "/login",
+	UsernameField:  "user",
+	PasswordField:  "pass",
+	KillPath:       "/kill",
+	KillParameter:  "session",
+	TerminatedText: "Session %s terminated",
+	NotFoundText:   "Session %s does not exist",
+}
+
+// testLoginPage is the login page returned by fakeEZproxy.
+const testLoginPage = `<html><body><form action="/login" method="post">
+<input type="text" name="user">
+<input type="password" name="pass">
+<input type="submit" value="Login">
+</form></body></html>`
+
+// fakeEZproxy stands in for the EZproxy admin web interface. Like EZproxy,
+// requests made without a valid admin session are redirected to the login
+// page and failed logins return the login page with a 200 status.
+type fakeEZproxy struct {
+	mu sync.Mutex
+
+	// sessions is the set of user sessions which may be terminated.
+	sessions map[string]bool
+
+	// tokens is the set of valid admin session cookie values.
+	tokens map[string]bool
+
+	// logins and kills count the requests made to the login and kill pages.
+	logins int
+	kills  int
+
+	// denyKill causes the kill page to return the login page directly, as
+	// for an account without admin access.
+	denyKill bool
+}
+
+func newFakeEZproxy(t *testing.T, sessions ...string) (*fakeEZproxy, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeEZproxy{
+		sessions: make(map[string]bool, len(sessions)),
+		tokens:   make(map[string]bool),
+	}
+	for _, id := range sessions {
+		fake.sessions[id] = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", fake.login)
+	mux.HandleFunc("/admin", fake.admin)
+	mux.HandleFunc("/kill", fake.kill)
+
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+
+	return fake, server
+}
+
+// expire invalidates all admin sessions.
+func (f *fakeEZproxy) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = make(map[string]bool)
+}
+
+func (f *fakeEZproxy) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins, f.kills
+}
+
+func (f *fakeEZproxy) loggedIn(r *http.Request) bool {
+	cookie, err := r.Cookie("ezproxy")
+	return err == nil && f.tokens[cookie.Value]
+}
+
+func (f *fakeEZproxy) login(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method != http.MethodPost {
+		fmt.Fprint(w, testLoginPage)
+		return
+	}
+
+	f.logins++
+
+	if r.PostFormValue("user") != testAdminUsername || r.PostFormValue("pass") != testAdminPassword {
+		fmt.Fprint(w, testLoginPage)
+		return
+	}
+
+	token := "token" + strconv.Itoa(f.logins)
+	f.tokens[token] = true
+	http.SetCookie(w, &http.Cookie{Name: "ezproxy", Value: token, Path: "/"})
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func (f *fakeEZproxy) admin(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.loggedIn(r) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	fmt.Fprint(w, "<html><body>Administration</body></html>")
+}
+
+func (f *fakeEZproxy) kill(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.kills++
+
+	switch {
+	case f.denyKill:
+		fmt.Fprint(w, testLoginPage)
+	case !f.loggedIn(r):
+		http.Redirect(w, r, "/login", http.StatusFound)
+	default:
+		id := r.PostFormValue("session")
+		switch {
+		case id == "unconfirmed":
+			fmt.Fprint(w, "<html><body>OK</body></html>")
+		case f.sessions[id]:
+			delete(f.sessions, id)
+			fmt.Fprintf(w, "<html><body>Session %s terminated</body></html>", id)
+		default:
+			fmt.Fprintf(w, "<html><body>Session %s does not exist</body></html>", id)
+		}
+	}
+}
+
+func newTestAdminTerminator(t *testing.T, server *httptest.Server, password string, admin AdminInterface) *AdminTerminator {
+	t.Helper()
+
+	at, err := NewAdminTerminator(server.URL, testAdminUsername, password, admin)
+	if err != nil {
+		t.Fatalf("NewAdminTerminator() error = %v", err)
+	}
+
+	if err := at.SetHTTPClient(server.Client()); err != nil {
+		t.Fatalf("SetHTTPClient() error = %v", err)
+	}
+
+	return at
+}
+
+func TestAdminTerminatorTerminateSession(t *testing.T) {
+
+	fake, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo")
+	at := newTestAdminTerminator(t, server, testAdminPassword, testAdminInterface)
+
+	tests := []struct {
+		name      string
+		sessionID string
+		exitCode  int
+		outcome   Outcome
+		wantErr   error
+	}{
+		{"terminated", "aBcDeFgHiJkLmNo", KillSubCmdExitCodeSessionTerminated, OutcomeTerminated, nil},
+		{"unknown session", "pQrStUvWxYz0123", KillSubCmdExitCodeSessionDoesNotExist, OutcomeNotFound, nil},
+		{"already terminated", "aBcDeFgHiJkLmNo", KillSubCmdExitCodeSessionDoesNotExist, OutcomeNotFound, nil},
+		{"unconfirmed", "unconfirmed", -1, OutcomeUnknown, ErrOutcomeUnknown},
+		{"not specified", "", KillSubCmdExitCodeSessionNotSpecified, OutcomeNotSpecified, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := at.TerminateSession(context.Background(), UserSession{SessionID: tt.sessionID})
+
+			if result.ExitCode != tt.exitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.exitCode)
+			}
+
+			if got := result.Outcome(); got != tt.outcome {
+				t.Errorf("Outcome() = %v, want %v", got, tt.outcome)
+			}
+
+			switch {
+			case tt.wantErr == nil && result.Error != nil:
+				t.Errorf("Error = %v, want nil", result.Error)
+			case !errors.Is(result.Error, tt.wantErr):
+				t.Errorf("Error = %v, want %v", result.Error, tt.wantErr)
+			}
+		})
+	}
+
+	// the admin session is reused for each request
+	if logins, _ := fake.counts(); logins != 1 {
+		t.Errorf("logins = %d, want 1", logins)
+	}
+}
+
+func TestAdminTerminatorFailedLogin(t *testing.T) {
+
+	fake, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo")
+	at := newTestAdminTerminator(t, server, "wrong", testAdminInterface)
+
+	result := at.TerminateSession(context.Background(), UserSession{SessionID: "aBcDeFgHiJkLmNo"})
+
+	if result.Error == nil {
+		t.Fatal("Error = nil, want login failure")
+	}
+
+	if got := result.Outcome(); got != OutcomeExecFailed {
+		t.Errorf("Outcome() = %v, want %v", got, OutcomeExecFailed)
+	}
+
+	if logins, kills := fake.counts(); logins != 1 || kills != 0 {
+		t.Errorf("logins, kills = %d, %d, want 1, 0", logins, kills)
+	}
+}
+
+func TestAdminTerminatorRelogin(t *testing.T) {
+
+	fake, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo", "pQrStUvWxYz0123")
+	at := newTestAdminTerminator(t, server, testAdminPassword, testAdminInterface)
+
+	if err := at.TerminateSession(context.Background(), UserSession{SessionID: "aBcDeFgHiJkLmNo"}).Err(); err != nil {
+		t.Fatalf("first TerminateSession() error = %v", err)
+	}
+
+	// the expired admin session is redirected to the login page with a 200
+	// status after following the redirect
+	fake.expire()
+
+	result := at.TerminateSession(context.Background(), UserSession{SessionID: "pQrStUvWxYz0123"})
+	if err := result.Err(); err != nil {
+		t.Fatalf("second TerminateSession() error = %v", err)
+	}
+
+	if logins, kills := fake.counts(); logins != 2 || kills != 3 {
+		t.Errorf("logins, kills = %d, %d, want 2, 3", logins, kills)
+	}
+}
+
+func TestAdminTerminatorLoginPageResponse(t *testing.T) {
+
+	fake, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo")
+	fake.denyKill = true
+	at := newTestAdminTerminator(t, server, testAdminPassword, testAdminInterface)
+
+	result := at.TerminateSession(context.Background(), UserSession{SessionID: "aBcDeFgHiJkLmNo"})
+
+	if result.Error == nil {
+		t.Fatal("Error = nil, want access denied error")
+	}
+
+	if got := result.Outcome(); got != OutcomeExecFailed {
+		t.Errorf("Outcome() = %v, want %v", got, OutcomeExecFailed)
+	}
+
+	// logged in again once before giving up
+	if logins, kills := fake.counts(); logins != 2 || kills != 2 {
+		t.Errorf("logins, kills = %d, %d, want 2, 2", logins, kills)
+	}
+}
+
+func TestAdminTerminatorNotFoundStatus(t *testing.T) {
+
+	_, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo")
+
+	admin := testAdminInterface
+	admin.KillPath = "/missing"
+	at := newTestAdminTerminator(t, server, testAdminPassword, admin)
+
+	result := at.TerminateSession(context.Background(), UserSession{SessionID: "aBcDeFgHiJkLmNo"})
+
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "404") {
+		t.Errorf("Error = %v, want HTTP status 404 error", result.Error)
+	}
+
+	if got := result.Outcome(); got != OutcomeExecFailed {
+		t.Errorf("Outcome() = %v, want %v", got, OutcomeExecFailed)
+	}
+}
+
+func TestNewAdminTerminatorInvalid(t *testing.T) {
+
+	missingKillPath := testAdminInterface
+	missingKillPath.KillPath = ""
+
+	missingTerminatedText := testAdminInterface
+	missingTerminatedText.TerminatedText = ""
+
+	tests := []struct {
+		name    string
+		baseURL string
+		admin   AdminInterface
+	}{
+		{"http scheme", "http://ezproxy.example.com", testAdminInterface},
+		{"missing host", "https://", testAdminInterface},
+		{"missing admin interface", "https://ezproxy.example.com", AdminInterface{}},
+		{"missing kill path", "https://ezproxy.example.com", missingKillPath},
+		{"missing terminated text", "https://ezproxy.example.com", missingTerminatedText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAdminTerminator(tt.baseURL, testAdminUsername, testAdminPassword, tt.admin); err == nil {
+				t.Error("NewAdminTerminator() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestNewTerminator(t *testing.T) {
+
+	_, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo")
+	at := newTestAdminTerminator(t, server, testAdminPassword, testAdminInterface)
+
+	terminator, err := NewTerminator(
+		context.Background(),
+		at,
+		UserSession{SessionID: "aBcDeFgHiJkLmNo"},
+		UserSession{SessionID: "pQrStUvWxYz0123"},
+	)
+	if err != nil {
+		t.Fatalf("NewTerminator() error = %v", err)
+	}
+
+	results := terminator.Terminate()
+
+	want := []Outcome{OutcomeTerminated, OutcomeNotFound}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+
+	for idx, result := range results {
+		if got := result.Outcome(); got != want[idx] {
+			t.Errorf("result %d Outcome() = %v, want %v", idx, got, want[idx])
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultBulkConcurrency is the default number of user sessions terminated
+// concurrently by a BulkTerminator.
+const DefaultBulkConcurrency int = 4
+
+// BulkTerminator terminates many user sessions concurrently using a pool of
+// workers, each calling the `kill` subcommand of the ezproxy binary (or using
+// another SessionTerminator backend). The rate at which calls are started can
+// be limited in order to avoid overloading the EZproxy host.
+type BulkTerminator struct {
+
+	// backend is used to terminate each user session.
+	backend SessionTerminator
+
+	// concurrency is the number of user sessions terminated concurrently.
+	concurrency int
+
+	// interval is the minimum delay between starting each `kill` subcommand
+	// call. Zero disables rate limiting.
+	interval time.Duration
+
+	// timeout is the maximum time allowed for each `kill` subcommand call.
+	// Zero disables the per-session timeout.
+	timeout time.Duration
+
+	// options are the optional settings applied when terminating user
+	// sessions.
+	options TerminateOptions
+}
+
+// NewBulkTerminator creates a new BulkTerminator which uses the provided
+// executable to terminate user sessions. By default DefaultBulkConcurrency
+// sessions are terminated concurrently without a rate limit or per-session
+// timeout.
+func NewBulkTerminator(executable string) (*BulkTerminator, error) {
+
+	backend, err := NewExecTerminator(executable)
+	if err != nil {
+		return nil, fmt.Errorf("func NewBulkTerminator: %w", err)
+	}
+
+	return &BulkTerminator{
+		backend:     backend,
+		concurrency: DefaultBulkConcurrency,
+	}, nil
+}
+
+// SetBackend is a helper method for setting the backend used to terminate
+// each user session (e.g., an AdminTerminator instead of the default
+// ExecTerminator).
+func (bt *BulkTerminator) SetBackend(backend SessionTerminator) error {
+	if backend == nil {
+		return errors.New("func SetBackend: missing backend")
+	}
+
+	bt.backend = backend
+
+	return nil
+}
+
+// SetConcurrency is a helper method for setting the number of user sessions
+// terminated concurrently.
+func (bt *BulkTerminator) SetConcurrency(concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("func SetConcurrency: %d is not a valid concurrency value", concurrency)
+	}
+
+	bt.concurrency = concurrency
+
+	return nil
+}
+
+// SetRateLimit is a helper method for setting the maximum number of `kill`
+// subcommand calls started per second. Zero disables rate limiting.
+func (bt *BulkTerminator) SetRateLimit(callsPerSecond int) error {
+	if callsPerSecond < 0 {
+		return fmt.Errorf("func SetRateLimit: %d is not a valid rate limit", callsPerSecond)
+	}
+
+	bt.interval = 0
+	if callsPerSecond > 0 {
+		bt.interval = time.Second / time.Duration(callsPerSecond)
+	}
+
+	return nil
+}
+
+// SetTimeout is a helper method for setting the maximum time allowed for
+// each `kill` subcommand call. Zero disables the per-session timeout.
+func (bt *BulkTerminator) SetTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("func SetTimeout: %v is not a valid timeout", timeout)
+	}
+
+	bt.timeout = timeout
+
+	return nil
+}
+
+// SetDryRun is a helper method for enabling or disabling dry-run mode. In
+// dry-run mode sessions are validated using the backend, but not terminated.
+// See NewDryRunTerminator for details.
+func (bt *BulkTerminator) SetDryRun(dryRun bool) {
+	bt.options.DryRun = dryRun
+}
+
+// SetVerifier is a helper method for setting the reader used to confirm
+// that terminated sessions are no longer listed, using the search retries
+// and search delay settings of the reader. A nil reader disables
+// verification.
+func (bt *BulkTerminator) SetVerifier(reader SessionsReader) {
+	bt.options.Verifier = reader
+}
+
+// Terminate terminates the provided user sessions concurrently, returning
+// the results in the same order as the provided sessions. Sessions not yet
+// processed when the provided context is done are not terminated; the
+// context error is recorded in the result for each of them. If a verifier
+// is set, the results are verified once all sessions have been processed.
+func (bt *BulkTerminator) Terminate(ctx context.Context, sessions ...UserSession) TerminateUserSessionResults {
+
+	results := make(TerminateUserSessionResults, len(sessions))
+
+	if ctx == nil {
+		for idx := range sessions {
+			results[idx] = TerminateUserSessionResult{
+				UserSession: sessions[idx],
+				ExitCode:    -1,
+				Error:       errors.New("func Terminate: missing context"),
+			}
+		}
+		return results
+	}
+
+	Logger.Printf(
+		"Terminating %d sessions (concurrency: %d, interval: %v, timeout: %v, dry-run: %t)\n",
+		len(sessions),
+		bt.concurrency,
+		bt.interval,
+		bt.timeout,
+		bt.options.DryRun,
+	)
+
+	backend := bt.options.backend(bt.backend)
+
+	// A nil channel blocks forever; ticks is only used when rate limiting.
+	var ticks <-chan time.Time
+	if bt.interval > 0 {
+		ticker := time.NewTicker(bt.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < bt.concurrency && i < len(sessions); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = bt.terminate(ctx, backend, sessions[idx])
+			}
+		}()
+	}
+
+	for idx := range sessions {
+
+		// The first call is started immediately, later calls wait for the
+		// rate limit (if any) unless the context is done; the workers
+		// record the context error for the remaining sessions.
+		if idx > 0 && ticks != nil {
+			select {
+			case <-ticks:
+			case <-ctx.Done():
+			}
+		}
+
+		jobs <- idx
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	bt.options.verify(ctx, results)
+
+	return results
+}
+
+// terminate terminates the specified user session using the provided
+// backend, applying the per-session timeout (if any).
+func (bt *BulkTerminator) terminate(ctx context.Context, backend SessionTerminator, session UserSession) TerminateUserSessionResult {
+
+	if bt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bt.timeout)
+		defer cancel()
+	}
+
+	return backend.TerminateSession(ctx, session)
+}
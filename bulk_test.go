@@ -0,0 +1,276 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBulkTerminator creates a BulkTerminator using the provided backend.
+func newTestBulkTerminator(t *testing.T, backend SessionTerminator) *BulkTerminator {
+	t.Helper()
+
+	bt, err := NewBulkTerminator("ezproxy")
+	if err != nil {
+		t.Fatalf("NewBulkTerminator() error = %v", err)
+	}
+
+	if err := bt.SetBackend(backend); err != nil {
+		t.Fatalf("SetBackend() error = %v", err)
+	}
+
+	return bt
+}
+
+// numberedSessions returns the specified number of user sessions with
+// distinct, valid session IDs.
+func numberedSessions(count int) UserSessions {
+	sessions := make(UserSessions, 0, count)
+	for i := 0; i < count; i++ {
+		sessions = append(sessions, UserSession{SessionID: fmt.Sprintf("session%08d", i)})
+	}
+	return sessions
+}
+
+func TestBulkTerminatorResultOrder(t *testing.T) {
+
+	sessions := numberedSessions(8)
+
+	// Later sessions complete sooner, so results are recorded out of order.
+	backend := testTerminatorFunc(func(ctx context.Context, session UserSession) TerminateUserSessionResult {
+		for idx := range sessions {
+			if sessions[idx].SessionID == session.SessionID {
+				time.Sleep(time.Duration(len(sessions)-idx) * time.Millisecond)
+			}
+		}
+		return terminatedResult(session.SessionID)
+	})
+
+	bt := newTestBulkTerminator(t, backend)
+	if err := bt.SetConcurrency(len(sessions)); err != nil {
+		t.Fatalf("SetConcurrency() error = %v", err)
+	}
+
+	results := bt.Terminate(context.Background(), sessions...)
+
+	if len(results) != len(sessions) {
+		t.Fatalf("got %d results, want %d", len(results), len(sessions))
+	}
+
+	for idx := range results {
+		if results[idx].SessionID != sessions[idx].SessionID {
+			t.Errorf("result %d is for session %q, want %q", idx, results[idx].SessionID, sessions[idx].SessionID)
+		}
+	}
+}
+
+func TestBulkTerminatorConcurrency(t *testing.T) {
+
+	const concurrency = 3
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	full := make(chan struct{})
+
+	// Each call waits until the pool is full (or gives up after a while) so
+	// that the maximum number of concurrent calls is reached.
+	backend := testTerminatorFunc(func(ctx context.Context, session UserSession) TerminateUserSessionResult {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		if inFlight == concurrency && maxInFlight == concurrency {
+			select {
+			case <-full:
+			default:
+				close(full)
+			}
+		}
+		mu.Unlock()
+
+		select {
+		case <-full:
+		case <-time.After(5 * time.Second):
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		return terminatedResult(session.SessionID)
+	})
+
+	bt := newTestBulkTerminator(t, backend)
+	if err := bt.SetConcurrency(concurrency); err != nil {
+		t.Fatalf("SetConcurrency() error = %v", err)
+	}
+
+	results := bt.Terminate(context.Background(), numberedSessions(10)...)
+
+	if results.HasError() {
+		t.Errorf("Terminate() results have errors: %+v", results)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight != concurrency {
+		t.Errorf("maximum concurrent calls = %d, want %d", maxInFlight, concurrency)
+	}
+}
+
+func TestBulkTerminatorRateLimit(t *testing.T) {
+
+	const callsPerSecond = 50
+	interval := time.Second / callsPerSecond
+
+	var mu sync.Mutex
+	starts := make([]time.Time, 0, 5)
+
+	backend := testTerminatorFunc(func(ctx context.Context, session UserSession) TerminateUserSessionResult {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		return terminatedResult(session.SessionID)
+	})
+
+	bt := newTestBulkTerminator(t, backend)
+	if err := bt.SetConcurrency(5); err != nil {
+		t.Fatalf("SetConcurrency() error = %v", err)
+	}
+	if err := bt.SetRateLimit(callsPerSecond); err != nil {
+		t.Fatalf("SetRateLimit() error = %v", err)
+	}
+
+	bt.Terminate(context.Background(), numberedSessions(5)...)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(starts) != 5 {
+		t.Fatalf("got %d calls, want 5", len(starts))
+	}
+
+	// The first call starts immediately and each later call waits for the
+	// next tick; allow some leeway for timer precision.
+	elapsed := starts[len(starts)-1].Sub(starts[0])
+	if want := 4*interval - 5*time.Millisecond; elapsed < want {
+		t.Errorf("calls started over %v, want at least %v", elapsed, want)
+	}
+}
+
+func TestBulkTerminatorTimeout(t *testing.T) {
+
+	// The backend waits for the context to be done, as the ExecTerminator
+	// does when the `kill` subcommand hangs.
+	backend := testTerminatorFunc(func(ctx context.Context, session UserSession) TerminateUserSessionResult {
+		<-ctx.Done()
+		return TerminateUserSessionResult{
+			UserSession: session,
+			ExitCode:    -1,
+			Error:       ctx.Err(),
+		}
+	})
+
+	bt := newTestBulkTerminator(t, backend)
+	if err := bt.SetTimeout(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetTimeout() error = %v", err)
+	}
+
+	results := bt.Terminate(context.Background(), numberedSessions(2)...)
+
+	for idx := range results {
+		if got := results[idx].Outcome(); got != OutcomeTimeout {
+			t.Errorf("result %d Outcome() = %v, want %v", idx, got, OutcomeTimeout)
+		}
+		if err := results[idx].Err(); !errors.Is(err, ErrTerminationTimeout) {
+			t.Errorf("result %d Err() = %v, want %v", idx, err, ErrTerminationTimeout)
+		}
+	}
+}
+
+func TestBulkTerminatorCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first session cancels the context; the remaining sessions are not
+	// terminated.
+	backend := testTerminatorFunc(func(ctx context.Context, session UserSession) TerminateUserSessionResult {
+		if err := ctx.Err(); err != nil {
+			return TerminateUserSessionResult{UserSession: session, ExitCode: -1, Error: err}
+		}
+		cancel()
+		return terminatedResult(session.SessionID)
+	})
+
+	bt := newTestBulkTerminator(t, backend)
+	if err := bt.SetConcurrency(1); err != nil {
+		t.Fatalf("SetConcurrency() error = %v", err)
+	}
+	if err := bt.SetRateLimit(1); err != nil {
+		t.Fatalf("SetRateLimit() error = %v", err)
+	}
+
+	start := time.Now()
+	results := bt.Terminate(ctx, numberedSessions(3)...)
+
+	// The rate limit is not waited for once the context is done.
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Terminate() took %v after cancellation", elapsed)
+	}
+
+	want := []Outcome{OutcomeTerminated, OutcomeExecFailed, OutcomeExecFailed}
+	for idx := range results {
+		if got := results[idx].Outcome(); got != want[idx] {
+			t.Errorf("result %d Outcome() = %v, want %v", idx, got, want[idx])
+		}
+	}
+}
+
+func TestBulkTerminatorSettings(t *testing.T) {
+
+	if _, err := NewBulkTerminator(""); err == nil {
+		t.Error("NewBulkTerminator() error = nil for missing executable, want error")
+	}
+
+	bt := newTestBulkTerminator(t, &recordingTerminator{})
+
+	if err := bt.SetConcurrency(0); err == nil {
+		t.Error("SetConcurrency() error = nil for zero, want error")
+	}
+	if err := bt.SetRateLimit(-1); err == nil {
+		t.Error("SetRateLimit() error = nil for negative value, want error")
+	}
+	if err := bt.SetTimeout(-time.Second); err == nil {
+		t.Error("SetTimeout() error = nil for negative value, want error")
+	}
+	if err := bt.SetBackend(nil); err == nil {
+		t.Error("SetBackend() error = nil for missing backend, want error")
+	}
+
+	//nolint:staticcheck // a nil context is rejected
+	results := bt.Terminate(nil, numberedSessions(2)...)
+	if len(results) != 2 || results[0].Error == nil || results[1].Error == nil {
+		t.Errorf("Terminate() = %+v for missing context, want errors", results)
+	}
+}
@@ -34,9 +34,32 @@ Process EZproxy related files and sessions.
     usernames or just for a specific username
   - generate a list of active sessions using the audit log using entires
     without a corresponding logout event type
+  - follow the current audit log file, switching to new daily files as they
+    appear
+  - read audit history across a directory of daily audit log files for a span
+    of time
   - generate a list of active sessions using the active file for all usernames
     or just for a specific username
+  - model the full contents of the active file, including hosts and groups
+  - watch the active file for sessions being started or ended
   - terminate single user session or bulk user sessions
+  - terminate many user sessions concurrently with a rate limit and
+    per-session timeouts
+  - terminate user sessions via the EZproxy admin web interface (over HTTPS)
+    instead of the ezproxy binary
+  - determine the outcome of each session termination attempt, with sentinel
+    errors for use with errors.Is
+  - verify that terminated user sessions are no longer listed
+  - preview user session termination in dry-run mode using any backend,
+    recording the command line (or admin request) which would have been run
+  - terminate idle or long-lived user sessions, with dry-run mode and an
+    allowlist of usernames which are never terminated
+  - generate a list of traffic log entries recorded using the NCSA common or
+    combined log formats
+  - offline GeoIP and ASN enrichment of user sessions, audit log events and
+    traffic log entries
+  - transparently read gzip, bzip2 or zstd compressed input files, or read
+    input from any io.Reader
 
 # Overview
 
@@ -53,6 +76,19 @@ General workflow:
 4. Using the new reader, generate a UserSessions collection
 5. Use the Terminate method to terminate user sessions
 
+Context-aware variants of the reader methods (e.g.,
+MatchingUserSessionsContext) and of TerminateUserSession
+(TerminateUserSessionContext) allow callers to cancel a search or enforce a
+deadline. TerminateUserSessionWithOptions (and the TerminateWithOptions
+method) accept a TerminateOptions value in order to validate sessions in
+dry-run mode or to verify that terminated sessions are no longer listed. Any
+SessionTerminator backend may be wrapped using NewDryRunTerminator, and the
+BulkTerminator and Reaper types provide the same settings.
+
+Readers may also be created from an io.Reader (NewReaderFrom) or from an
+OpenFunc which opens the input for each read attempt (NewReaderFunc). The
+username is optional when only sessions for ALL usernames are needed.
+
 If using the ezproxy/auditlog package, you can also generate a SessionEntries
 collection representing all SessionEntry values from a specified audit log
 file or just the values applicable to a specifc user.
@@ -64,9 +100,8 @@ found within the audit logs and active user file relevant to our purposes.
 
 # Future
 
-This package currently provides functionality for working with an active user
-or audit log file, but not for EZproxy traffic log files. Having minimal
-support for traffic log files could provide a way to die activity for a
+This package currently provides minimal support for EZproxy traffic log files
+via the ezproxy/trafficlog package. This provides a way to tie activity for a
 specific user account to specifc resources accessed by that user account. This
 could prove invaluable where automation is used to automatically terminate
 user sessions; after account termination, a report could be generated for the
@@ -0,0 +1,107 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// sessionIDRegex is used to validate session IDs in dry-run mode.
+var sessionIDRegex = regexp.MustCompile("^" + SessionIDRegex + "$")
+
+// DryRunner is implemented by SessionTerminator backends which are able to
+// describe how a user session would be terminated without terminating it.
+type DryRunner interface {
+
+	// DryRunSession returns the result of preparing to terminate the
+	// specified user session, with the Command field set to the action which
+	// would have been taken (e.g., the command line which would have been
+	// run). The Error field is set if the session could not have been
+	// terminated.
+	DryRunSession(session UserSession) TerminateUserSessionResult
+}
+
+// dryRunTerminator is a SessionTerminator which validates user sessions
+// using a backend without terminating them.
+type dryRunTerminator struct {
+	backend SessionTerminator
+}
+
+// NewDryRunTerminator creates a new SessionTerminator which validates user
+// sessions without terminating them, for use anywhere a SessionTerminator is
+// accepted (e.g., NewTerminator or BulkTerminator.SetBackend). Each session
+// ID is validated against SessionIDRegex and, if the backend implements
+// DryRunner, the backend records the action it would have taken in the
+// Command field of the result. Each result has the DryRun field set; those
+// without an error have the OutcomeDryRun outcome.
+func NewDryRunTerminator(backend SessionTerminator) (SessionTerminator, error) {
+
+	if backend == nil {
+		return nil, errors.New("func NewDryRunTerminator: missing backend")
+	}
+
+	return &dryRunTerminator{backend: backend}, nil
+}
+
+// TerminateSession validates the specified user session without
+// terminating it.
+func (dt *dryRunTerminator) TerminateSession(ctx context.Context, session UserSession) TerminateUserSessionResult {
+
+	result := TerminateUserSessionResult{
+		UserSession: session,
+		ExitCode:    -1,
+	}
+
+	switch {
+	case ctx == nil:
+		result.Error = errors.New("func TerminateSession: missing context")
+
+	case ctx.Err() != nil:
+		result.Error = ctx.Err()
+
+	case session.SessionID == "":
+		result.Error = fmt.Errorf("func TerminateSession: %w", ErrSessionNotSpecified)
+
+	case !sessionIDRegex.MatchString(session.SessionID):
+		result.Error = fmt.Errorf(
+			"func TerminateSession: %w: %q does not match %q",
+			ErrInvalidSessionID,
+			session.SessionID,
+			SessionIDRegex,
+		)
+
+	default:
+		if dr, ok := dt.backend.(DryRunner); ok {
+			result = dr.DryRunSession(session)
+		}
+	}
+
+	result.DryRun = true
+
+	Logger.Printf(
+		"Dry-run: session %q for username %q: command: %q, error: %v\n",
+		session.SessionID,
+		session.Username,
+		result.Command,
+		result.Error,
+	)
+
+	return result
+}
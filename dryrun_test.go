@@ -0,0 +1,234 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTerminatorFunc is a SessionTerminator backend which calls the
+// function to terminate each user session.
+type testTerminatorFunc func(ctx context.Context, session UserSession) TerminateUserSessionResult
+
+func (f testTerminatorFunc) TerminateSession(ctx context.Context, session UserSession) TerminateUserSessionResult {
+	return f(ctx, session)
+}
+
+func TestDryRunTerminator(t *testing.T) {
+
+	executable := writeFakeExecutable(t)
+
+	backend, err := NewExecTerminator(executable)
+	if err != nil {
+		t.Fatalf("NewExecTerminator() error = %v", err)
+	}
+
+	dryRun, err := NewDryRunTerminator(backend)
+	if err != nil {
+		t.Fatalf("NewDryRunTerminator() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		command string
+		outcome Outcome
+		err     error
+	}{
+		{
+			name:    "valid",
+			session: "aBcDeFgHiJkLmNo",
+			command: executable + " " + SubCmdNameSessionTerminate + " aBcDeFgHiJkLmNo",
+			outcome: OutcomeDryRun,
+			err:     ErrDryRun,
+		},
+		{
+			name:    "not specified",
+			outcome: OutcomeNotSpecified,
+			err:     ErrSessionNotSpecified,
+		},
+		{
+			name:    "invalid",
+			session: "not-a-session",
+			outcome: OutcomeExecFailed,
+			err:     ErrInvalidSessionID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := dryRun.TerminateSession(context.Background(), UserSession{SessionID: tt.session})
+
+			if !result.DryRun {
+				t.Error("DryRun = false, want true")
+			}
+
+			if result.Command != tt.command {
+				t.Errorf("Command = %q, want %q", result.Command, tt.command)
+			}
+
+			if got := result.Outcome(); got != tt.outcome {
+				t.Errorf("Outcome() = %v, want %v", got, tt.outcome)
+			}
+
+			if err := result.Err(); !errors.Is(err, tt.err) {
+				t.Errorf("Err() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if got := executedSessions(t, executable); len(got) != 0 {
+		t.Errorf("executed sessions = %v in dry-run mode, want none", got)
+	}
+}
+
+func TestDryRunTerminatorMissingExecutable(t *testing.T) {
+
+	executable := filepath.Join(t.TempDir(), "ezproxy")
+
+	backend, err := NewExecTerminator(executable)
+	if err != nil {
+		t.Fatalf("NewExecTerminator() error = %v", err)
+	}
+
+	result := TerminateUserSessionWithOptions(
+		context.Background(),
+		executable,
+		TerminateOptions{DryRun: true},
+		UserSession{SessionID: "aBcDeFgHiJkLmNo"},
+	)[0]
+
+	if result.Error == nil || result.Outcome() != OutcomeExecFailed {
+		t.Errorf("got outcome %v, error %v; want %v with error", result.Outcome(), result.Error, OutcomeExecFailed)
+	}
+
+	if result := backend.DryRunSession(UserSession{SessionID: "aBcDeFgHiJkLmNo"}); result.Error == nil {
+		t.Error("DryRunSession() error = nil for missing executable, want error")
+	}
+}
+
+func TestDryRunTerminatorAdmin(t *testing.T) {
+
+	fake, server := newFakeEZproxy(t, "aBcDeFgHiJkLmNo")
+	at := newTestAdminTerminator(t, server, testAdminPassword, testAdminInterface)
+
+	dryRun, err := NewDryRunTerminator(at)
+	if err != nil {
+		t.Fatalf("NewDryRunTerminator() error = %v", err)
+	}
+
+	terminator, err := NewTerminator(context.Background(), dryRun, UserSession{SessionID: "aBcDeFgHiJkLmNo"})
+	if err != nil {
+		t.Fatalf("NewTerminator() error = %v", err)
+	}
+
+	results := terminator.Terminate()
+
+	want := "POST " + server.URL + "/kill session=aBcDeFgHiJkLmNo"
+	if len(results) != 1 || results[0].Command != want || results[0].Outcome() != OutcomeDryRun {
+		t.Fatalf("Terminate() = %+v, want dry-run result with command %q", results, want)
+	}
+
+	if logins, kills := fake.counts(); logins != 0 || kills != 0 {
+		t.Errorf("got %d logins and %d kill requests in dry-run mode, want none", logins, kills)
+	}
+}
+
+func TestDryRunTerminatorWithoutDryRunner(t *testing.T) {
+
+	dryRun, err := NewDryRunTerminator(testTerminatorFunc(func(ctx context.Context, session UserSession) TerminateUserSessionResult {
+		t.Errorf("backend called for session %q in dry-run mode", session.SessionID)
+		return TerminateUserSessionResult{UserSession: session}
+	}))
+	if err != nil {
+		t.Fatalf("NewDryRunTerminator() error = %v", err)
+	}
+
+	result := dryRun.TerminateSession(context.Background(), UserSession{SessionID: "aBcDeFgHiJkLmNo"})
+	if result.Command != "" || result.Outcome() != OutcomeDryRun {
+		t.Errorf("TerminateSession() = %+v, want dry-run result without command", result)
+	}
+
+	if _, err := NewDryRunTerminator(nil); err == nil {
+		t.Error("NewDryRunTerminator() error = nil for missing backend, want error")
+	}
+}
+
+func TestDryRunOption(t *testing.T) {
+
+	executable := writeFakeExecutable(t)
+	sessions := testSessions("aaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbb")
+
+	checkDryRun := func(t *testing.T, results TerminateUserSessionResults) {
+		t.Helper()
+
+		if len(results) != len(sessions) {
+			t.Fatalf("got %d results, want %d", len(results), len(sessions))
+		}
+
+		for idx := range results {
+			if results[idx].SessionID != sessions[idx].SessionID || results[idx].Outcome() != OutcomeDryRun {
+				t.Errorf("result %d = %+v, want dry-run result for session %q", idx, results[idx], sessions[idx].SessionID)
+			}
+		}
+
+		if got := executedSessions(t, executable); len(got) != 0 {
+			t.Errorf("executed sessions = %v in dry-run mode, want none", got)
+		}
+	}
+
+	t.Run("TerminateWithOptions", func(t *testing.T) {
+		checkDryRun(t, sessions.TerminateWithOptions(context.Background(), executable, TerminateOptions{DryRun: true}))
+	})
+
+	t.Run("BulkTerminator", func(t *testing.T) {
+		bt, err := NewBulkTerminator(executable)
+		if err != nil {
+			t.Fatalf("NewBulkTerminator() error = %v", err)
+		}
+		bt.SetDryRun(true)
+
+		checkDryRun(t, bt.Terminate(context.Background(), sessions...))
+	})
+
+	t.Run("Reaper", func(t *testing.T) {
+		r, err := NewReaper(executable)
+		if err != nil {
+			t.Fatalf("NewReaper() error = %v", err)
+		}
+		if err := r.SetMaxIdle(time.Minute); err != nil {
+			t.Fatalf("SetMaxIdle() error = %v", err)
+		}
+		r.SetDryRun(true)
+
+		idle := make(UserSessions, 0, len(sessions))
+		for _, session := range sessions {
+			session.LastAccessed = time.Now().Add(-time.Hour)
+			idle = append(idle, session)
+		}
+
+		report := r.Reap(idle)
+		if !report.DryRun {
+			t.Error("ReapReport.DryRun = false, want true")
+		}
+		checkDryRun(t, report.Results)
+	})
+}
@@ -17,6 +17,7 @@
 package ezproxy
 
 import (
+	"context"
 	"io"
 	"log"
 	"os"
@@ -148,6 +149,19 @@ type UserSession struct {
 	SessionID string
 	IPAddress string
 	Username  string
+
+	// Created is when the session was created. This is only recorded in the
+	// active file; the zero value is used if not available.
+	Created time.Time
+
+	// LastAccessed is when the session was last accessed. This is only
+	// recorded in the active file; the zero value is used if not available.
+	LastAccessed time.Time
+
+	// MaxLifetime is the EZproxy "MaxLifetime" value for the session; the
+	// session expires after being idle for this long. This is only recorded
+	// in the active file; zero is used if not available.
+	MaxLifetime time.Duration
 }
 
 // UserSessions is a collection of UserSession values. Intended for
@@ -177,3 +191,29 @@ type SessionsReader interface {
 	// search attempts.
 	SetSearchDelay(delay int) error
 }
+
+// SessionsReaderContext is an interface used as the API for retrieving user
+// sessions from one of the audit log or active users and hosts files with
+// support for cancellation and deadlines via a context.Context.
+type SessionsReaderContext interface {
+	SessionsReader
+
+	// AllUserSessionsContext behaves like AllUserSessions, returning early
+	// with the context error if the provided context is done.
+	AllUserSessionsContext(ctx context.Context) (UserSessions, error)
+
+	// MatchingUserSessionsContext behaves like MatchingUserSessions,
+	// returning early with the context error if the provided context is done
+	// (e.g., while delaying between search attempts).
+	MatchingUserSessionsContext(ctx context.Context) (UserSessions, error)
+}
+
+// SearchSettings is an interface used to retrieve the search retries and
+// search delay settings of a SessionsReader. These settings are reused when
+// verifying that terminated sessions are no longer listed.
+type SearchSettings interface {
+
+	// SearchSettings returns the number of additional search attempts and
+	// the delay between search attempts.
+	SearchSettings() (retries int, delay time.Duration)
+}
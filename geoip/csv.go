@@ -0,0 +1,288 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// These are the recognized CSV range database column names. Column names are
+// matched case-insensitively. Either the network column or both of the start
+// and end columns are required. The column names used by the MaxMind
+// GeoLite2 CSV files (e.g., GeoLite2-ASN-Blocks-IPv4.csv) are supported.
+var (
+	csvColumnsNetwork      = []string{"network", "cidr"}
+	csvColumnsStart        = []string{"start", "start_ip", "ip_start", "range_start"}
+	csvColumnsEnd          = []string{"end", "end_ip", "ip_end", "range_end"}
+	csvColumnsCountryCode  = []string{"country_code", "country_iso_code", "iso_code"}
+	csvColumnsCountry      = []string{"country", "country_name"}
+	csvColumnsCity         = []string{"city", "city_name"}
+	csvColumnsLatitude     = []string{"latitude", "lat"}
+	csvColumnsLongitude    = []string{"longitude", "lon", "lng"}
+	csvColumnsASN          = []string{"asn", "autonomous_system_number"}
+	csvColumnsOrganization = []string{"organization", "org", "autonomous_system_organization", "as_org"}
+)
+
+// csvRange is a range of IP Addresses along with the details recorded for
+// that range. IP Addresses are stored in their 16-byte form. The maxEnd
+// value is the highest end address of this and all earlier (sorted) ranges.
+type csvRange struct {
+	start   net.IP
+	end     net.IP
+	maxEnd  net.IP
+	details Details
+}
+
+// CSVDatabase is an in-memory copy of a CSV range database. Each row of the
+// database provides the details for a range of IP Addresses, specified
+// either as a network in CIDR notation or as start and end IP Addresses. The
+// first row must be a header row naming the columns.
+type CSVDatabase struct {
+
+	// Filename is the name of the file the database was loaded from.
+	Filename string
+
+	ranges []csvRange
+}
+
+// OpenCSV loads the specified CSV range database into memory.
+func OpenCSV(filename string) (*CSVDatabase, error) {
+
+	if filename == "" {
+		return nil, errors.New("func OpenCSV: missing filename")
+	}
+
+	ezproxy.Logger.Printf(
+		"OpenCSV: Attempting to read sanitized version of file %q\n",
+		filepath.Clean(filename),
+	)
+
+	buf, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, fmt.Errorf("func OpenCSV: error encountered reading file %q: %w", filename, err)
+	}
+
+	db, err := NewCSVDatabase(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("func OpenCSV: failed to load database %q: %w", filename, err)
+	}
+	db.Filename = filename
+
+	return db, nil
+}
+
+// NewCSVDatabase creates a CSVDatabase from the CSV range database provided
+// by the given io.Reader.
+func NewCSVDatabase(r io.Reader) (*CSVDatabase, error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read header row: %v", ErrInvalidDatabase, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for idx, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+
+	column := func(names []string) int {
+		for _, name := range names {
+			if idx, ok := columns[name]; ok {
+				return idx
+			}
+		}
+		return -1
+	}
+
+	networkCol := column(csvColumnsNetwork)
+	startCol, endCol := column(csvColumnsStart), column(csvColumnsEnd)
+	if networkCol < 0 && (startCol < 0 || endCol < 0) {
+		return nil, fmt.Errorf(
+			"%w: header row must include a network column or start and end columns",
+			ErrInvalidDatabase,
+		)
+	}
+
+	countryCodeCol := column(csvColumnsCountryCode)
+	countryCol := column(csvColumnsCountry)
+	cityCol := column(csvColumnsCity)
+	latCol, lonCol := column(csvColumnsLatitude), column(csvColumnsLongitude)
+	asnCol := column(csvColumnsASN)
+	orgCol := column(csvColumnsOrganization)
+
+	var db CSVDatabase
+	rowNum := 1
+
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		rowNum++
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read row %d: %v", ErrInvalidDatabase, rowNum, err)
+		}
+
+		field := func(idx int) string {
+			if idx < 0 || idx >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[idx])
+		}
+
+		var ipRange csvRange
+
+		switch {
+		case networkCol >= 0 && field(networkCol) != "":
+			_, network, err := net.ParseCIDR(field(networkCol))
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid network in row %d: %v", ErrInvalidDatabase, rowNum, err)
+			}
+			ipRange.start, ipRange.end = networkBounds(network)
+
+		default:
+			ipRange.start = parseRangeIP(field(startCol))
+			ipRange.end = parseRangeIP(field(endCol))
+			if ipRange.start == nil || ipRange.end == nil {
+				return nil, fmt.Errorf("%w: invalid IP Address range in row %d", ErrInvalidDatabase, rowNum)
+			}
+			if bytes.Compare(ipRange.start, ipRange.end) > 0 {
+				return nil, fmt.Errorf("%w: range start after range end in row %d", ErrInvalidDatabase, rowNum)
+			}
+		}
+
+		ipRange.details.CountryCode = field(countryCodeCol)
+		ipRange.details.Country = field(countryCol)
+		ipRange.details.City = field(cityCol)
+		ipRange.details.Organization = field(orgCol)
+
+		if v := strings.TrimPrefix(strings.ToUpper(field(asnCol)), "AS"); v != "" {
+			asn, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid ASN in row %d: %v", ErrInvalidDatabase, rowNum, err)
+			}
+			ipRange.details.ASN = uint(asn)
+		}
+
+		lat, latErr := strconv.ParseFloat(field(latCol), 64)
+		lon, lonErr := strconv.ParseFloat(field(lonCol), 64)
+		if latErr == nil && lonErr == nil {
+			ipRange.details.Latitude = lat
+			ipRange.details.Longitude = lon
+			ipRange.details.HasCoordinates = true
+		}
+
+		db.ranges = append(db.ranges, ipRange)
+	}
+
+	sort.SliceStable(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+
+	for idx := range db.ranges {
+		db.ranges[idx].maxEnd = db.ranges[idx].end
+		if idx > 0 && bytes.Compare(db.ranges[idx-1].maxEnd, db.ranges[idx].end) > 0 {
+			db.ranges[idx].maxEnd = db.ranges[idx-1].maxEnd
+		}
+	}
+
+	ezproxy.Logger.Printf("Loaded %d IP Address ranges\n", len(db.ranges))
+
+	return &db, nil
+}
+
+// Resolve returns the details recorded for the given IP Address. If ranges
+// overlap, the range with the latest start address containing the IP
+// Address (generally the most specific range) is used.
+func (db *CSVDatabase) Resolve(ipAddress string) (Details, error) {
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return Details{}, fmt.Errorf("func Resolve: %q is not a valid IP Address", ipAddress)
+	}
+	ip = ip.To16()
+
+	// index of the first range starting after the IP Address
+	idx := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	})
+
+	// Earlier ranges can only contain the IP Address if one of them ends at
+	// or after it; the search stops as soon as none can.
+	for i := idx - 1; i >= 0 && bytes.Compare(ip, db.ranges[i].maxEnd) <= 0; i-- {
+		if bytes.Compare(ip, db.ranges[i].end) <= 0 {
+			return db.ranges[i].details, nil
+		}
+	}
+
+	return Details{}, ErrNotFound
+}
+
+// Locate returns the location details recorded for the given IP Address.
+func (db *CSVDatabase) Locate(ipAddress string) (Location, error) {
+	details, err := db.Resolve(ipAddress)
+	if err != nil {
+		return Location{}, err
+	}
+	return details.Location, nil
+}
+
+// parseRangeIP parses an IP Address recorded as the start or end of a range.
+// Both the dotted (or colon) notation and the decimal integer notation used
+// by some IPv4 range databases are supported.
+func parseRangeIP(s string) net.IP {
+
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.To16()
+	}
+
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To16()
+	}
+
+	return nil
+}
+
+// networkBounds returns the first and last IP Addresses of the given network
+// in their 16-byte form.
+func networkBounds(network *net.IPNet) (net.IP, net.IP) {
+
+	start := network.IP.Mask(network.Mask)
+	end := make(net.IP, len(start))
+	for i := range start {
+		end[i] = start[i] | ^network.Mask[i]
+	}
+
+	return start.To16(), end.To16()
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package geoip is intended for offline geolocation of IP Addresses recorded
+in EZproxy related files.
+
+# Overview
+
+Client applications provide a local MaxMind DB format database (e.g., the
+GeoLite2-City.mmdb file) which is loaded into memory and searched directly;
+no network access or external lookup service is required. Only the Go
+standard library is used to decode the database.
+
+# Databases
+
+Two database formats are supported:
+
+  - MaxMind DB format (.mmdb) City, Country and ASN databases
+  - CSV range databases, where each row provides the details for a network in
+    CIDR notation or a start/end IP Address range; the first row names the
+    columns (the MaxMind GeoLite2 CSV column names are recognized)
+
+Multiple databases (e.g., a City database and an ASN database) can be
+combined using the Resolvers type. Wrapping a database with a Cache is
+recommended when resolving the same IP Addresses repeatedly.
+
+# Enrichment
+
+Details values (country, ASN and organization) can be added to user sessions
+via EnrichUserSessions, to audit log events and session entries via the
+ezproxy/auditlog package and to traffic log entries via the
+ezproxy/trafficlog package. This allows reports to flag sessions from
+unexpected networks (e.g., a hosting provider ASN) without an external lookup
+service.
+
+Location values are also used by the ezproxy/auditlog package in order to
+detect sessions which move between distant locations faster than is
+physically possible (impossible travel).
+*/
+package geoip
@@ -0,0 +1,304 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// Details reflects the geolocation and network ownership details recorded for
+// an IP Address.
+type Details struct {
+	Location
+
+	// ASN is the autonomous system number of the network the IP Address
+	// belongs to. Zero if not recorded.
+	ASN uint
+
+	// Organization is the organization associated with the autonomous
+	// system (e.g., a hosting provider or ISP).
+	Organization string
+}
+
+// Resolver is the API for retrieving geolocation and network ownership
+// details for an IP Address from an offline database.
+type Resolver interface {
+
+	// Resolve returns the details recorded for the given IP Address.
+	// ErrNotFound is returned if the database has no record for the IP
+	// Address.
+	Resolve(ipAddress string) (Details, error)
+}
+
+// Resolvers is a collection of Resolver values which are consulted in order,
+// merging the details found in each. This allows for example a City database
+// and an ASN database to be used together.
+type Resolvers []Resolver
+
+// Resolve returns the merged details recorded for the given IP Address. Values
+// found in earlier databases take precedence. ErrNotFound is returned if none
+// of the databases have a record for the IP Address.
+func (r Resolvers) Resolve(ipAddress string) (Details, error) {
+
+	var details Details
+	var found bool
+
+	for _, resolver := range r {
+		d, err := resolver.Resolve(ipAddress)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			continue
+		case err != nil:
+			return Details{}, err
+		}
+
+		found = true
+		details = mergeDetails(details, d)
+	}
+
+	if !found {
+		return Details{}, ErrNotFound
+	}
+
+	return details, nil
+}
+
+// Locate returns the location details recorded for the given IP Address.
+func (r Resolvers) Locate(ipAddress string) (Location, error) {
+	details, err := r.Resolve(ipAddress)
+	if err != nil {
+		return Location{}, err
+	}
+	return details.Location, nil
+}
+
+// mergeDetails fills any empty fields in a using the values from b.
+func mergeDetails(a Details, b Details) Details {
+	if a.CountryCode == "" {
+		a.CountryCode = b.CountryCode
+	}
+	if a.Country == "" {
+		a.Country = b.Country
+	}
+	if a.City == "" {
+		a.City = b.City
+	}
+	if !a.HasCoordinates && b.HasCoordinates {
+		a.Latitude = b.Latitude
+		a.Longitude = b.Longitude
+		a.HasCoordinates = true
+	}
+	if a.ASN == 0 {
+		a.ASN = b.ASN
+	}
+	if a.Organization == "" {
+		a.Organization = b.Organization
+	}
+	return a
+}
+
+// Resolve returns the details recorded for the given IP Address. The
+// database may be a City, Country or ASN database.
+func (db *MMDB) Resolve(ipAddress string) (Details, error) {
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return Details{}, fmt.Errorf("func Resolve: %q is not a valid IP Address", ipAddress)
+	}
+
+	record, err := db.Lookup(ip)
+	if err != nil {
+		return Details{}, fmt.Errorf("func Resolve: failed to lookup %s: %w", ipAddress, err)
+	}
+
+	if record == nil {
+		return Details{}, ErrNotFound
+	}
+
+	details := Details{
+		Location: locationFromRecord(record),
+	}
+
+	if asn, ok := toUint(lookupPath(record, "autonomous_system_number")); ok {
+		details.ASN = asn
+	}
+	details.Organization, _ = lookupPath(record, "autonomous_system_organization").(string)
+
+	return details, nil
+}
+
+// EnrichedSession is a UserSession along with the details recorded for the
+// IP Address of the session.
+type EnrichedSession struct {
+	ezproxy.UserSession
+	Details
+
+	// Error is the error (if any) from the attempt to resolve the IP
+	// Address. ErrNotFound is recorded if the database has no record for the
+	// IP Address.
+	Error error
+}
+
+// EnrichedSessions is a collection of EnrichedSession values. Intended for
+// aggregation before bulk processing of some kind.
+type EnrichedSessions []EnrichedSession
+
+// EnrichUserSessions resolves the details for the IP Address of each given
+// user session using the provided offline database.
+func EnrichUserSessions(resolver Resolver, sessions ezproxy.UserSessions) EnrichedSessions {
+
+	enriched := make(EnrichedSessions, 0, len(sessions))
+
+	for _, session := range sessions {
+		details, err := ResolveIPAddress(resolver, session.IPAddress)
+		enriched = append(enriched, EnrichedSession{
+			UserSession: session,
+			Details:     details,
+			Error:       err,
+		})
+	}
+
+	return enriched
+}
+
+// MatchingASNs returns the sessions with an IP Address belonging to one of the
+// specified autonomous system numbers (e.g., those of known hosting
+// providers).
+func (es EnrichedSessions) MatchingASNs(asns ...uint) EnrichedSessions {
+
+	matching := make(EnrichedSessions, 0, len(es))
+
+	for idx := range es {
+		for _, asn := range asns {
+			if es[idx].ASN != 0 && es[idx].ASN == asn {
+				matching = append(matching, es[idx])
+				break
+			}
+		}
+	}
+
+	return matching
+}
+
+// MatchingOrganization returns the sessions with an IP Address belonging to an
+// organization whose name contains the specified text (case-insensitive).
+func (es EnrichedSessions) MatchingOrganization(text string) EnrichedSessions {
+
+	matching := make(EnrichedSessions, 0, len(es))
+
+	for idx := range es {
+		if strings.Contains(strings.ToLower(es[idx].Organization), strings.ToLower(text)) {
+			matching = append(matching, es[idx])
+		}
+	}
+
+	return matching
+}
+
+// UserSessions returns the UserSession values for the enriched sessions. The
+// returned collection is suitable for use with the
+// ezproxy.UserSessions.Terminate method.
+func (es EnrichedSessions) UserSessions() ezproxy.UserSessions {
+
+	userSessions := make(ezproxy.UserSessions, 0, len(es))
+
+	for idx := range es {
+		userSessions = append(userSessions, es[idx].UserSession)
+	}
+
+	return userSessions
+}
+
+// ResolveIPAddress resolves the details for the given IP Address using the
+// provided offline database. ErrNotFound is returned for an empty IP Address
+// (e.g., for audit log events which do not record an IP Address) instead of
+// consulting the database.
+func ResolveIPAddress(resolver Resolver, ipAddress string) (Details, error) {
+	if resolver == nil {
+		return Details{}, errors.New("func ResolveIPAddress: missing resolver")
+	}
+	if ipAddress == "" {
+		return Details{}, ErrNotFound
+	}
+	return resolver.Resolve(ipAddress)
+}
+
+// Cache is a Resolver which records the details (or ErrNotFound results)
+// returned by another Resolver. This is useful when enriching traffic log
+// entries where the same IP Address is often recorded many times.
+type Cache struct {
+	resolver Resolver
+	mu       sync.Mutex
+	results  map[string]cacheResult
+}
+
+// cacheResult is a result recorded by a Cache.
+type cacheResult struct {
+	details Details
+	err     error
+}
+
+// NewCache creates a new Cache for the given Resolver.
+func NewCache(resolver Resolver) (*Cache, error) {
+	if resolver == nil {
+		return nil, errors.New("func NewCache: missing resolver")
+	}
+	return &Cache{
+		resolver: resolver,
+		results:  make(map[string]cacheResult, ezproxy.AllUsersSessionsLimit),
+	}, nil
+}
+
+// Resolve returns the details recorded for the given IP Address, consulting
+// the underlying Resolver only for IP Addresses not seen before.
+func (c *Cache) Resolve(ipAddress string) (Details, error) {
+
+	c.mu.Lock()
+	result, ok := c.results[ipAddress]
+	c.mu.Unlock()
+
+	if ok {
+		return result.details, result.err
+	}
+
+	details, err := c.resolver.Resolve(ipAddress)
+
+	// Only successful lookups and "not found" results are recorded; other
+	// errors may be transient.
+	if err == nil || errors.Is(err, ErrNotFound) {
+		c.mu.Lock()
+		c.results[ipAddress] = cacheResult{details: details, err: err}
+		c.mu.Unlock()
+	}
+
+	return details, err
+}
+
+// Locate returns the location details recorded for the given IP Address.
+func (c *Cache) Locate(ipAddress string) (Location, error) {
+	details, err := c.Resolve(ipAddress)
+	if err != nil {
+		return Location{}, err
+	}
+	return details.Location, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"errors"
+	"math"
+)
+
+// earthRadiusKm is the mean radius of the Earth in kilometers.
+const earthRadiusKm float64 = 6371.0
+
+// ErrNotFound indicates that a database has no record for an IP Address.
+var ErrNotFound = errors.New("IP Address not found")
+
+// Location reflects the geolocation details recorded for an IP Address.
+type Location struct {
+
+	// CountryCode is the two-character ISO 3166-1 country code (e.g., US).
+	CountryCode string
+
+	// Country is the English name of the country.
+	Country string
+
+	// City is the English name of the city.
+	City string
+
+	// Latitude is the approximate latitude of the location.
+	Latitude float64
+
+	// Longitude is the approximate longitude of the location.
+	Longitude float64
+
+	// HasCoordinates indicates whether Latitude and Longitude were recorded.
+	HasCoordinates bool
+}
+
+// Locator is the API for retrieving geolocation details for an IP Address
+// from an offline database.
+type Locator interface {
+
+	// Locate returns the location details recorded for the given IP
+	// Address. ErrNotFound is returned if the database has no record for the
+	// IP Address.
+	Locate(ipAddress string) (Location, error)
+}
+
+// Distance returns the great-circle distance in kilometers between two
+// locations. Zero is returned if either location does not have coordinates.
+func Distance(a Location, b Location) float64 {
+
+	if !a.HasCoordinates || !b.HasCoordinates {
+		return 0
+	}
+
+	toRadians := func(deg float64) float64 {
+		return deg * math.Pi / 180
+	}
+
+	lat1, lat2 := toRadians(a.Latitude), toRadians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := toRadians(b.Longitude - a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// locationFromRecord converts a decoded City or Country database record to
+// a Location value.
+func locationFromRecord(record interface{}) Location {
+
+	var location Location
+
+	country := lookupPath(record, "country")
+	if country == nil {
+		country = lookupPath(record, "registered_country")
+	}
+	location.CountryCode, _ = lookupPath(country, "iso_code").(string)
+	location.Country, _ = lookupPath(country, "names", "en").(string)
+	location.City, _ = lookupPath(record, "city", "names", "en").(string)
+
+	latitude, latOK := lookupPath(record, "location", "latitude").(float64)
+	longitude, lonOK := lookupPath(record, "location", "longitude").(float64)
+	if latOK && lonOK {
+		location.Latitude = latitude
+		location.Longitude = longitude
+		location.HasCoordinates = true
+	}
+
+	return location
+}
+
+// lookupPath returns the value found by following the given map keys within
+// a decoded database record, or nil if the path does not exist.
+func lookupPath(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
@@ -0,0 +1,490 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// mmdbMetadataMarker is the sequence of bytes which precedes the metadata
+// section at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDataSectionSeparatorSize is the number of zero bytes between the search
+// tree and the data section of a MaxMind DB file.
+const mmdbDataSectionSeparatorSize int = 16
+
+// mmdbMaxDepth limits the nesting depth of decoded data structures in order
+// to guard against malformed files.
+const mmdbMaxDepth int = 32
+
+// These are the MaxMind DB data section field types.
+const (
+	mmdbTypeExtended  int = 0
+	mmdbTypePointer   int = 1
+	mmdbTypeString    int = 2
+	mmdbTypeDouble    int = 3
+	mmdbTypeBytes     int = 4
+	mmdbTypeUint16    int = 5
+	mmdbTypeUint32    int = 6
+	mmdbTypeMap       int = 7
+	mmdbTypeInt32     int = 8
+	mmdbTypeUint64    int = 9
+	mmdbTypeUint128   int = 10
+	mmdbTypeArray     int = 11
+	mmdbTypeContainer int = 12
+	mmdbTypeEndMarker int = 13
+	mmdbTypeBool      int = 14
+	mmdbTypeFloat     int = 15
+)
+
+// ErrInvalidDatabase indicates that a database file could not be decoded.
+var ErrInvalidDatabase = errors.New("invalid database")
+
+// MMDB is an in-memory copy of a MaxMind DB format database (e.g.,
+// GeoLite2-City.mmdb). Only the features of the format needed by this
+// package are supported; no network access is required.
+type MMDB struct {
+
+	// Filename is the name of the file the database was loaded from.
+	Filename string
+
+	// DatabaseType is the database type recorded in the database metadata
+	// (e.g., GeoLite2-City, GeoLite2-ASN).
+	DatabaseType string
+
+	// IPVersion is the IP version (4 or 6) of the search tree.
+	IPVersion int
+
+	nodeCount     uint
+	recordSize    uint
+	tree          []byte
+	data          []byte
+	ipv4StartNode uint
+}
+
+// OpenMMDB loads the specified MaxMind DB format database into memory.
+func OpenMMDB(filename string) (*MMDB, error) {
+
+	if filename == "" {
+		return nil, errors.New("func OpenMMDB: missing filename")
+	}
+
+	ezproxy.Logger.Printf(
+		"OpenMMDB: Attempting to read sanitized version of file %q\n",
+		filepath.Clean(filename),
+	)
+
+	buf, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, fmt.Errorf("func OpenMMDB: error encountered reading file %q: %w", filename, err)
+	}
+
+	db, err := NewMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("func OpenMMDB: failed to load database %q: %w", filename, err)
+	}
+	db.Filename = filename
+
+	return db, nil
+}
+
+// NewMMDB creates an MMDB from the given MaxMind DB format database contents.
+func NewMMDB(buf []byte) (*MMDB, error) {
+
+	markerIdx := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if markerIdx < 0 {
+		return nil, fmt.Errorf("%w: metadata marker not found", ErrInvalidDatabase)
+	}
+
+	metadataDecoder := mmdbDecoder{buf: buf[markerIdx+len(mmdbMetadataMarker):]}
+	value, _, err := metadataDecoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode metadata: %v", ErrInvalidDatabase, err)
+	}
+
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	nodeCount, nodeCountOK := toUint(metadata["node_count"])
+	recordSize, recordSizeOK := toUint(metadata["record_size"])
+	ipVersion, ipVersionOK := toUint(metadata["ip_version"])
+	databaseType, _ := metadata["database_type"].(string)
+
+	if !nodeCountOK || !recordSizeOK || !ipVersionOK {
+		return nil, fmt.Errorf("%w: required metadata missing", ErrInvalidDatabase)
+	}
+
+	switch recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, recordSize)
+	}
+
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidDatabase, ipVersion)
+	}
+
+	// Each node holds two records. The node count is checked before
+	// calculating the size of the search tree in order to guard against
+	// overflow from a malformed node count.
+	nodeSize := recordSize / 4
+	if nodeCount > uint(markerIdx)/nodeSize {
+		return nil, fmt.Errorf("%w: node count %d exceeds file size", ErrInvalidDatabase, nodeCount)
+	}
+
+	treeSize := nodeCount * nodeSize
+	dataStart := treeSize + uint(mmdbDataSectionSeparatorSize)
+	if dataStart > uint(markerIdx) {
+		return nil, fmt.Errorf("%w: search tree exceeds file size", ErrInvalidDatabase)
+	}
+
+	db := MMDB{
+		DatabaseType: databaseType,
+		IPVersion:    int(ipVersion),
+		nodeCount:    nodeCount,
+		recordSize:   recordSize,
+		tree:         buf[:treeSize],
+		data:         buf[dataStart:markerIdx],
+	}
+
+	// IPv4 addresses are found within an IPv6 search tree by following the
+	// left (zero bit) record for the first 96 bits.
+	if db.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readRecord(node, 0)
+		}
+		db.ipv4StartNode = node
+	}
+
+	return &db, nil
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record for the given
+// node in the search tree.
+func (db *MMDB) readRecord(node uint, bit uint) uint {
+
+	switch db.recordSize {
+	case 24:
+		offset := node*6 + bit*3
+		b := db.tree[offset : offset+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+
+	case 28:
+		offset := node * 7
+		b := db.tree[offset : offset+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+
+	default:
+		offset := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(db.tree[offset : offset+4]))
+	}
+}
+
+// Lookup returns the decoded data record for the given IP Address. A nil
+// value is returned (without error) if the database has no record for the
+// IP Address. Maps are decoded as map[string]interface{} values and arrays
+// as []interface{} values.
+func (db *MMDB) Lookup(ip net.IP) (interface{}, error) {
+
+	if ip == nil {
+		return nil, errors.New("func Lookup: missing IP Address")
+	}
+
+	var bits []byte
+	node := uint(0)
+
+	switch ipv4 := ip.To4(); {
+	case ipv4 != nil && db.IPVersion == 6:
+		bits = ipv4
+		node = db.ipv4StartNode
+	case ipv4 != nil:
+		bits = ipv4
+	case db.IPVersion == 4:
+		return nil, fmt.Errorf("func Lookup: IPv6 Address %s cannot be found in an IPv4 database", ip)
+	default:
+		bits = ip.To16()
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.readRecord(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, fmt.Errorf("%w: search tree ended without a record", ErrInvalidDatabase)
+	}
+
+	offset := node - db.nodeCount - uint(mmdbDataSectionSeparatorSize)
+	if offset >= uint(len(db.data)) {
+		return nil, fmt.Errorf("%w: record offset out of range", ErrInvalidDatabase)
+	}
+
+	decoder := mmdbDecoder{buf: db.data}
+	value, _, err := decoder.decode(offset, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode record: %v", ErrInvalidDatabase, err)
+	}
+
+	return value, nil
+}
+
+// Locate returns the location details recorded for the given IP Address.
+// The database is expected to be a City or Country database.
+func (db *MMDB) Locate(ipAddress string) (Location, error) {
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return Location{}, fmt.Errorf("func Locate: %q is not a valid IP Address", ipAddress)
+	}
+
+	record, err := db.Lookup(ip)
+	if err != nil {
+		return Location{}, fmt.Errorf("func Locate: failed to lookup %s: %w", ipAddress, err)
+	}
+
+	if record == nil {
+		return Location{}, ErrNotFound
+	}
+
+	return locationFromRecord(record), nil
+}
+
+// mmdbDecoder decodes values from the data (or metadata) section of a
+// MaxMind DB file. Pointer values are offsets within buf.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// decode decodes the value at the given offset, returning the value and the
+// offset immediately following it.
+func (d mmdbDecoder) decode(offset uint, depth int) (interface{}, uint, error) {
+
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("maximum data structure depth exceeded")
+	}
+
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+
+	fieldType := int(ctrl >> 5)
+
+	if fieldType == mmdbTypePointer {
+		pointer, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	if fieldType == mmdbTypeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		fieldType = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		extra := int(size) - 28
+		if offset+uint(extra) > uint(len(d.buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		b := d.buf[offset : offset+uint(extra)]
+		offset += uint(extra)
+
+		switch extra {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	// Each array element takes at least one byte and each map entry takes
+	// at least two (a key and a value); reject sizes which cannot fit in the
+	// remaining data before allocating.
+	remaining := uint(len(d.buf)) - offset
+
+	switch fieldType {
+	case mmdbTypeMap:
+		if size > remaining/2 {
+			return nil, 0, fmt.Errorf("map size %d exceeds remaining data", size)
+		}
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[keyString] = value
+			offset = next
+		}
+		return m, offset, nil
+
+	case mmdbTypeArray:
+		if size > remaining {
+			return nil, 0, fmt.Errorf("array size %d exceeds remaining data", size)
+		}
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+
+	case mmdbTypeBool:
+		return size != 0, offset, nil
+
+	case mmdbTypeContainer, mmdbTypeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+
+	switch fieldType {
+	case mmdbTypeString:
+		return string(b), offset, nil
+
+	case mmdbTypeBytes:
+		value := make([]byte, len(b))
+		copy(value, b)
+		return value, offset, nil
+
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid unsigned integer size %d", size)
+		}
+		var value uint64
+		for _, v := range b {
+			value = value<<8 | uint64(v)
+		}
+		return value, offset, nil
+
+	case mmdbTypeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid signed integer size %d", size)
+		}
+		var value uint32
+		for _, v := range b {
+			value = value<<8 | uint32(v)
+		}
+		return int64(int32(value)), offset, nil
+
+	case mmdbTypeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid unsigned integer size %d", size)
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+
+	default:
+		return nil, 0, fmt.Errorf("unsupported field type %d", fieldType)
+	}
+}
+
+// decodePointer decodes the pointer with the given control byte whose value
+// begins at the given offset, returning the pointer and the offset
+// immediately following it.
+func (d mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+
+	pointerSize := uint((ctrl>>3)&0x3) + 1
+	if offset+pointerSize > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+pointerSize]
+	offset += pointerSize
+
+	prefix := uint(ctrl & 0x7)
+
+	var pointer uint
+	switch pointerSize {
+	case 1:
+		pointer = prefix<<8 | uint(b[0])
+	case 2:
+		pointer = (prefix<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		pointer = (prefix<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		pointer = uint(binary.BigEndian.Uint32(b))
+	}
+
+	return pointer, offset, nil
+}
+
+// toUint converts a decoded unsigned integer value to a uint.
+func toUint(value interface{}) (uint, bool) {
+	v, ok := value.(uint64)
+	if !ok {
+		return 0, false
+	}
+	return uint(v), true
+}
@@ -0,0 +1,460 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"testing"
+)
+
+// mmdbPointer is a data section pointer written by mmdbWriter.
+type mmdbPointer uint
+
+// mmdbWriter generates small MaxMind DB format databases for use in tests.
+type mmdbWriter struct {
+	ipVersion  int
+	recordSize uint
+	root       *mmdbNode
+	data       bytes.Buffer
+}
+
+// mmdbNode is a node of the search tree built by mmdbWriter. A record is
+// either a child node or (if the child is nil) a data section offset; -1
+// indicates no data.
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  [2]int
+}
+
+func newMMDBNode() *mmdbNode {
+	return &mmdbNode{data: [2]int{-1, -1}}
+}
+
+func newMMDBWriter(ipVersion int, recordSize uint) *mmdbWriter {
+	return &mmdbWriter{
+		ipVersion:  ipVersion,
+		recordSize: recordSize,
+		root:       newMMDBNode(),
+	}
+}
+
+// addData writes the given value to the data section, returning its offset.
+func (w *mmdbWriter) addData(value interface{}) int {
+	offset := w.data.Len()
+	encodeMMDBValue(&w.data, value)
+	return offset
+}
+
+// insert records the data section offset for the given network. IPv4
+// networks are inserted into IPv6 databases within ::/96.
+func (w *mmdbWriter) insert(t *testing.T, cidr string, offset int) {
+	t.Helper()
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("invalid network %q: %v", cidr, err)
+	}
+
+	ip := []byte(network.IP)
+	ones, _ := network.Mask.Size()
+	if w.ipVersion == 6 && len(ip) == net.IPv4len {
+		ip = append(make([]byte, 12), ip...)
+		ones += 96
+	}
+
+	node := w.root
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if i == ones-1 {
+			node.data[bit] = offset
+			return
+		}
+		if node.child[bit] == nil {
+			node.child[bit] = newMMDBNode()
+		}
+		node = node.child[bit]
+	}
+}
+
+// bytes returns the database contents using the given metadata overrides.
+func (w *mmdbWriter) bytes(metadata map[string]interface{}) []byte {
+
+	var nodes []*mmdbNode
+	index := make(map[*mmdbNode]uint)
+	queue := []*mmdbNode{w.root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		index[node] = uint(len(nodes))
+		nodes = append(nodes, node)
+		for _, child := range node.child {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+	nodeCount := uint(len(nodes))
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		var records [2]uint
+		for bit := range records {
+			switch {
+			case node.child[bit] != nil:
+				records[bit] = index[node.child[bit]]
+			case node.data[bit] >= 0:
+				records[bit] = nodeCount + uint(mmdbDataSectionSeparatorSize) + uint(node.data[bit])
+			default:
+				records[bit] = nodeCount
+			}
+		}
+		buf.Write(encodeMMDBNode(records, w.recordSize))
+	}
+
+	buf.Write(make([]byte, mmdbDataSectionSeparatorSize))
+	buf.Write(w.data.Bytes())
+	buf.Write(mmdbMetadataMarker)
+
+	meta := map[string]interface{}{
+		"node_count":    uint64(nodeCount),
+		"record_size":   uint64(w.recordSize),
+		"ip_version":    uint64(w.ipVersion),
+		"database_type": "Test-City",
+	}
+	for key, value := range metadata {
+		meta[key] = value
+	}
+	encodeMMDBValue(&buf, meta)
+
+	return buf.Bytes()
+}
+
+// encodeMMDBNode encodes the left and right records of a search tree node.
+func encodeMMDBNode(records [2]uint, recordSize uint) []byte {
+	left, right := records[0], records[1]
+	switch recordSize {
+	case 24:
+		return []byte{
+			byte(left >> 16), byte(left >> 8), byte(left),
+			byte(right >> 16), byte(right >> 8), byte(right),
+		}
+	case 28:
+		return []byte{
+			byte(left >> 16), byte(left >> 8), byte(left),
+			byte((left>>20)&0xF0) | byte((right>>24)&0x0F),
+			byte(right >> 16), byte(right >> 8), byte(right),
+		}
+	default:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint32(b[:4], uint32(left))
+		binary.BigEndian.PutUint32(b[4:], uint32(right))
+		return b
+	}
+}
+
+// encodeMMDBControl writes the control byte(s) for a value of the given type
+// and size.
+func encodeMMDBControl(buf *bytes.Buffer, fieldType int, size int) {
+
+	var ctrl byte
+	var extended []byte
+	if fieldType > 7 {
+		extended = []byte{byte(fieldType - 7)}
+	} else {
+		ctrl = byte(fieldType << 5)
+	}
+
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl |= 30
+		sizeBytes = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		ctrl |= 31
+		v := size - 65821
+		sizeBytes = []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+
+	buf.WriteByte(ctrl)
+	buf.Write(extended)
+	buf.Write(sizeBytes)
+}
+
+// encodeMMDBValue writes the given value using the MaxMind DB data section
+// format.
+func encodeMMDBValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case mmdbPointer:
+		// 1 byte pointers only; sufficient for the small test databases.
+		buf.WriteByte(byte(mmdbTypePointer<<5) | byte((v>>8)&0x7))
+		buf.WriteByte(byte(v))
+
+	case string:
+		encodeMMDBControl(buf, mmdbTypeString, len(v))
+		buf.WriteString(v)
+
+	case float64:
+		encodeMMDBControl(buf, mmdbTypeDouble, 8)
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		buf.Write(b)
+
+	case uint64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		b = bytes.TrimLeft(b, "\x00")
+		fieldType := mmdbTypeUint32
+		if len(b) > 4 {
+			fieldType = mmdbTypeUint64
+		}
+		encodeMMDBControl(buf, fieldType, len(b))
+		buf.Write(b)
+
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		encodeMMDBControl(buf, mmdbTypeBool, size)
+
+	case []interface{}:
+		encodeMMDBControl(buf, mmdbTypeArray, len(v))
+		for _, item := range v {
+			encodeMMDBValue(buf, item)
+		}
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		encodeMMDBControl(buf, mmdbTypeMap, len(v))
+		for _, key := range keys {
+			encodeMMDBValue(buf, key)
+			encodeMMDBValue(buf, v[key])
+		}
+
+	default:
+		panic("unsupported value type")
+	}
+}
+
+// newTestMMDB generates a database with city and ASN records. The country
+// map is shared by the city records using a pointer.
+func newTestMMDB(t *testing.T, ipVersion int, recordSize uint) []byte {
+	t.Helper()
+
+	w := newMMDBWriter(ipVersion, recordSize)
+
+	country := w.addData(map[string]interface{}{
+		"iso_code": "US",
+		"names":    map[string]interface{}{"en": "United States"},
+	})
+
+	chicago := w.addData(map[string]interface{}{
+		"country": mmdbPointer(country),
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Chicago"}},
+		"location": map[string]interface{}{
+			"latitude":  41.85,
+			"longitude": -87.65,
+		},
+		"is_anycast": false,
+		"subdivisions": []interface{}{
+			map[string]interface{}{"iso_code": "IL"},
+		},
+	})
+
+	hosting := w.addData(map[string]interface{}{
+		"autonomous_system_number":       uint64(64500),
+		"autonomous_system_organization": "Example Hosting",
+	})
+
+	w.insert(t, "192.0.2.0/24", chicago)
+	w.insert(t, "198.51.100.0/25", hosting)
+	if ipVersion == 6 {
+		w.insert(t, "2001:db8::/32", hosting)
+	}
+
+	return w.bytes(nil)
+}
+
+func TestMMDBResolve(t *testing.T) {
+
+	chicago := Details{
+		Location: Location{
+			CountryCode:    "US",
+			Country:        "United States",
+			City:           "Chicago",
+			Latitude:       41.85,
+			Longitude:      -87.65,
+			HasCoordinates: true,
+		},
+	}
+	hosting := Details{ASN: 64500, Organization: "Example Hosting"}
+
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []uint{24, 28, 32} {
+
+			db, err := NewMMDB(newTestMMDB(t, ipVersion, recordSize))
+			if err != nil {
+				t.Fatalf("IPv%d/%d: failed to load database: %v", ipVersion, recordSize, err)
+			}
+
+			if db.DatabaseType != "Test-City" || db.IPVersion != ipVersion {
+				t.Errorf("IPv%d/%d: got metadata %q/%d", ipVersion, recordSize, db.DatabaseType, db.IPVersion)
+			}
+
+			tests := []struct {
+				ipAddress string
+				want      Details
+				wantErr   error
+			}{
+				{"192.0.2.1", chicago, nil},
+				{"192.0.2.255", chicago, nil},
+				{"198.51.100.1", hosting, nil},
+				{"198.51.100.200", Details{}, ErrNotFound},
+				{"203.0.113.1", Details{}, ErrNotFound},
+			}
+			if ipVersion == 6 {
+				tests = append(tests, struct {
+					ipAddress string
+					want      Details
+					wantErr   error
+				}{"2001:db8::1", hosting, nil})
+			}
+
+			for _, tt := range tests {
+				got, err := db.Resolve(tt.ipAddress)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("IPv%d/%d: Resolve(%s) error = %v, want %v", ipVersion, recordSize, tt.ipAddress, err, tt.wantErr)
+					continue
+				}
+				if got != tt.want {
+					t.Errorf("IPv%d/%d: Resolve(%s) = %+v, want %+v", ipVersion, recordSize, tt.ipAddress, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestMMDBLookupDecodesValues(t *testing.T) {
+
+	db, err := NewMMDB(newTestMMDB(t, 6, 28))
+	if err != nil {
+		t.Fatalf("failed to load database: %v", err)
+	}
+
+	record, err := db.Lookup(net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatalf("failed to lookup: %v", err)
+	}
+
+	if v, ok := lookupPath(record, "is_anycast").(bool); !ok || v {
+		t.Errorf("got is_anycast %v, want false", lookupPath(record, "is_anycast"))
+	}
+
+	subdivisions, ok := lookupPath(record, "subdivisions").([]interface{})
+	if !ok || len(subdivisions) != 1 || lookupPath(subdivisions[0], "iso_code") != "IL" {
+		t.Errorf("got subdivisions %v, want [map[iso_code:IL]]", lookupPath(record, "subdivisions"))
+	}
+}
+
+func TestMMDBLookupIPv6InIPv4Database(t *testing.T) {
+
+	db, err := NewMMDB(newTestMMDB(t, 4, 24))
+	if err != nil {
+		t.Fatalf("failed to load database: %v", err)
+	}
+
+	if _, err := db.Lookup(net.ParseIP("2001:db8::1")); err == nil {
+		t.Error("expected error looking up an IPv6 Address in an IPv4 database")
+	}
+}
+
+func TestNewMMDBInvalid(t *testing.T) {
+
+	valid := newTestMMDB(t, 4, 24)
+	markerIdx := bytes.LastIndex(valid, mmdbMetadataMarker)
+
+	withMetadata := func(metadata map[string]interface{}) []byte {
+		w := newMMDBWriter(4, 24)
+		w.insert(t, "192.0.2.0/24", w.addData(map[string]interface{}{"city": "x"}))
+		return w.bytes(metadata)
+	}
+
+	tests := map[string][]byte{
+		"empty":                nil,
+		"missing marker":       valid[:markerIdx],
+		"truncated metadata":   valid[:markerIdx+len(mmdbMetadataMarker)+3],
+		"huge node count":      withMetadata(map[string]interface{}{"node_count": uint64(1) << 62}),
+		"node count too large": withMetadata(map[string]interface{}{"node_count": uint64(1000)}),
+		"bad record size":      withMetadata(map[string]interface{}{"record_size": uint64(20)}),
+		"bad ip version":       withMetadata(map[string]interface{}{"ip_version": uint64(5)}),
+		"missing node count":   withMetadata(map[string]interface{}{"node_count": "many"}),
+	}
+
+	for name, buf := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewMMDB(buf)
+			if !errors.Is(err, ErrInvalidDatabase) {
+				t.Errorf("got error %v, want ErrInvalidDatabase", err)
+			}
+		})
+	}
+}
+
+func TestMMDBDecodeRejectsOversizedContainers(t *testing.T) {
+
+	const huge = 65821 + 0xFFFFFF
+
+	tests := []struct {
+		name      string
+		fieldType int
+		size      int
+	}{
+		{"huge map", mmdbTypeMap, huge},
+		{"huge array", mmdbTypeArray, huge},
+		{"map larger than data", mmdbTypeMap, 3},
+		{"array larger than data", mmdbTypeArray, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			encodeMMDBControl(&buf, tt.fieldType, tt.size)
+
+			// a few bytes of data follow the control bytes
+			buf.Write([]byte{0x41, 'a', 0x41, 'b'})
+
+			d := mmdbDecoder{buf: buf.Bytes()}
+			if _, _, err := d.decode(0, 0); err == nil {
+				t.Error("decode() error = nil, want error")
+			}
+		})
+	}
+}
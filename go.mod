@@ -16,4 +16,6 @@
 
 module github.com/atc0005/go-ezproxy
 
-go 1.19
+go 1.22
+
+require github.com/klauspost/compress v1.18.0
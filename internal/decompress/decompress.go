@@ -0,0 +1,167 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnsupportedFormat indicates that the input is compressed using a format
+// which cannot be decompressed.
+var ErrUnsupportedFormat = errors.New("unsupported compression format")
+
+// Format is a compression format detected using the magic bytes at the start
+// of the input.
+type Format string
+
+// These are the compression formats which are detected.
+const (
+	FormatNone  Format = ""
+	FormatGzip  Format = "gzip"
+	FormatBzip2 Format = "bzip2"
+	FormatZstd  Format = "zstd"
+	FormatXZ    Format = "xz"
+)
+
+// These are the magic bytes found at the start of input compressed using each
+// format.
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicXZ    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// These are the magic bytes which follow the bzip2 stream header: the first
+// block of the stream or, for an empty stream, the end of stream marker.
+var (
+	magicBzip2Block     = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	magicBzip2StreamEnd = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// maxMagicLength is the length of the longest magic byte sequence; the bzip2
+// stream header ("BZh" and the block size) is followed by a 6 byte marker.
+const maxMagicLength int = 10
+
+// Detect returns the compression format indicated by the given leading
+// bytes of the input.
+func Detect(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, magicGzip):
+		return FormatGzip
+	case isBzip2(header):
+		return FormatBzip2
+	case bytes.HasPrefix(header, magicZstd):
+		return FormatZstd
+	case bytes.HasPrefix(header, magicXZ):
+		return FormatXZ
+	default:
+		return FormatNone
+	}
+}
+
+// isBzip2 indicates whether the given leading bytes of the input are a bzip2
+// stream header. As the "BZh" magic bytes alone also match plain text, the
+// block size digit and the marker which follows it are also required.
+func isBzip2(header []byte) bool {
+	if len(header) < maxMagicLength || !bytes.HasPrefix(header, magicBzip2) {
+		return false
+	}
+
+	if header[3] < '1' || header[3] > '9' {
+		return false
+	}
+
+	marker := header[4:maxMagicLength]
+
+	return bytes.Equal(marker, magicBzip2Block) || bytes.Equal(marker, magicBzip2StreamEnd)
+}
+
+// NewReader returns an io.Reader which provides the decompressed content of
+// the given input if it is compressed, or the input as-is otherwise. The
+// content is decompressed while it is read. ErrUnsupportedFormat is returned
+// for input compressed using a format without a decoder (e.g., xz).
+func NewReader(r io.Reader) (io.Reader, error) {
+
+	if r == nil {
+		return nil, errors.New("func NewReader: missing reader")
+	}
+
+	br := bufio.NewReader(r)
+
+	// A short read is expected for small (e.g., empty) inputs; any other
+	// error is reported when the content is read.
+	header, _ := br.Peek(maxMagicLength)
+
+	switch format := Detect(header); format {
+	case FormatNone:
+		return br, nil
+
+	case FormatGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("func NewReader: failed to read gzip header: %w", err)
+		}
+		return gz, nil
+
+	case FormatBzip2:
+		return bzip2.NewReader(br), nil
+
+	case FormatZstd:
+		// A single goroutine is used so that decoding happens as the
+		// content is read instead of in the background.
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("func NewReader: failed to create zstd decoder: %w", err)
+		}
+		return &zstdReader{decoder: zr}, nil
+
+	default:
+		return nil, fmt.Errorf("func NewReader: %w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+// zstdReader releases the resources held by a zstd decoder once all of the
+// content has been read (or an error occurs), as callers of NewReader are
+// only provided an io.Reader.
+type zstdReader struct {
+	decoder *zstd.Decoder
+	closed  bool
+}
+
+// Read reads decompressed content from the zstd decoder.
+func (zr *zstdReader) Read(p []byte) (int, error) {
+	if zr.closed {
+		return 0, io.EOF
+	}
+
+	n, err := zr.decoder.Read(p)
+	if err != nil {
+		zr.decoder.Close()
+		zr.closed = true
+	}
+
+	return n, err
+}
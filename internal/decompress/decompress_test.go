@@ -0,0 +1,151 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const testContent = "S aBcDeFgHiJkLmNo 1600000000 1600000100 1 120 192.0.2.10 0 0 0 *\nL alice\n"
+
+// testContentBzip2 is testContent compressed using bzip2, as the standard
+// library does not provide a bzip2 encoder.
+const testContentBzip2 = "425a683931415926535908ddff8b00001c5f00241040117120155508002aaea0" +
+	"00545000001a0d0694fd269343d4d1a1a0644354a093597ae73710429895721701" +
+	"a86839026b102bdcfcf78fac78134a686f83e980fc5dc914e142402377fe2c"
+
+func TestNewReader(t *testing.T) {
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write([]byte(testContent)); err != nil {
+		t.Fatalf("failed to write gzip content: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	bz2, err := hex.DecodeString(testContentBzip2)
+	if err != nil {
+		t.Fatalf("failed to decode bzip2 content: %v", err)
+	}
+
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatalf("failed to create zstd writer: %v", err)
+	}
+	if _, err := zw.Write([]byte(testContent)); err != nil {
+		t.Fatalf("failed to write zstd content: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zstd writer: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		input  []byte
+		format Format
+	}{
+		{"plain", []byte(testContent), FormatNone},
+		{"empty", nil, FormatNone},
+		{"gzip", gz.Bytes(), FormatGzip},
+		{"bzip2", bz2, FormatBzip2},
+		{"zstd", zst.Bytes(), FormatZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.input); got != tt.format {
+				t.Errorf("Detect() = %q, want %q", got, tt.format)
+			}
+
+			r, err := NewReader(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to read content: %v", err)
+			}
+
+			want := testContent
+			if tt.input == nil {
+				want = ""
+			}
+			if string(got) != want {
+				t.Errorf("got content %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDetectBzip2(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		input  string
+		format Format
+	}{
+		{"empty stream", "BZh9\x17\x72\x45\x38\x50\x90\x00\x00\x00\x00", FormatBzip2},
+		{"block", "BZh1\x31\x41\x59\x26\x53\x59", FormatBzip2},
+		{"text", "BZh is not compressed\n", FormatNone},
+		{"missing block size", "BZh\x31\x41\x59\x26\x53\x59", FormatNone},
+		{"invalid block size", "BZh0\x31\x41\x59\x26\x53\x59", FormatNone},
+		{"missing block marker", "BZh9", FormatNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect([]byte(tt.input)); got != tt.format {
+				t.Errorf("Detect() = %q, want %q", got, tt.format)
+			}
+		})
+	}
+}
+
+func TestNewReaderCorruptZstd(t *testing.T) {
+
+	input := append([]byte{0x28, 0xb5, 0x2f, 0xfd}, []byte(strings.Repeat("x", 32))...)
+
+	r, err := NewReader(bytes.NewReader(input))
+	if err != nil {
+		return
+	}
+
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("expected error reading corrupt zstd content")
+	}
+}
+
+func TestNewReaderUnsupported(t *testing.T) {
+
+	input := []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}
+
+	if _, err := NewReader(bytes.NewReader(input)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got error %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package decompress is an internal package that contains helper functions
+// for transparently reading compressed input files. Compression is detected
+// using the magic bytes at the start of the input; gzip and bzip2 compressed
+// input is decompressed using the standard library and zstd compressed input
+// using the pure Go github.com/klauspost/compress/zstd package. Input
+// compressed using xz is detected and rejected as no decoder is provided for
+// it.
+package decompress
@@ -0,0 +1,101 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// OpenFunc opens the input read by a SessionsReader. Readers call this
+// function for each read attempt (e.g., for each search retry) and close the
+// returned io.ReadCloser once done reading.
+type OpenFunc func() (io.ReadCloser, error)
+
+// FileOpener returns an OpenFunc which opens the specified file.
+func FileOpener(filename string) OpenFunc {
+	return func() (io.ReadCloser, error) {
+
+		Logger.Printf(
+			"FileOpener: Attempting to open sanitized version of file %q\n",
+			filepath.Clean(filename),
+		)
+
+		f, err := os.Open(filepath.Clean(filename))
+		if err != nil {
+			return nil, fmt.Errorf("error encountered opening file %q: %w", filename, err)
+		}
+
+		return f, nil
+	}
+}
+
+// ErrReaderConsumed indicates that an OpenFunc returned by ReaderOpener was
+// called again for an io.Reader which cannot be rewound.
+var ErrReaderConsumed = errors.New("reader has already been read")
+
+// ReaderOpener returns an OpenFunc which provides the content of the given
+// io.Reader. The content is streamed from the reader rather than read into
+// memory, so unless the reader can be rewound (i.e., it implements io.Seeker
+// and seeking succeeds) it can only be opened once; later calls return
+// ErrReaderConsumed. Otherwise each call after the first rewinds the reader
+// to its position at the time of the first call so that each call provides
+// the same content. Closing the returned io.ReadCloser does not close the
+// reader.
+func ReaderOpener(r io.Reader) OpenFunc {
+
+	var mu sync.Mutex
+	var opened bool
+	var seeker io.Seeker
+	var start int64
+
+	return func() (io.ReadCloser, error) {
+
+		if r == nil {
+			return nil, errors.New("missing reader")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case !opened:
+			// Seeking fails for some readers which implement io.Seeker
+			// (e.g., an *os.File for a pipe); these are opened once.
+			if s, ok := r.(io.Seeker); ok {
+				if offset, err := s.Seek(0, io.SeekCurrent); err == nil {
+					seeker, start = s, offset
+				}
+			}
+			opened = true
+
+		case seeker == nil:
+			return nil, ErrReaderConsumed
+
+		default:
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to rewind reader: %w", err)
+			}
+		}
+
+		return io.NopCloser(r), nil
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// countingReader records the number of bytes read from the wrapped reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func readOpened(t *testing.T, open OpenFunc) string {
+	t.Helper()
+
+	rc, err := open()
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read content: %v", err)
+	}
+
+	return string(content)
+}
+
+func TestReaderOpenerStreams(t *testing.T) {
+
+	cr := &countingReader{r: strings.NewReader("streamed content")}
+	open := ReaderOpener(cr)
+
+	rc, err := open()
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if cr.n != 0 {
+		t.Errorf("read %d bytes before the content was requested", cr.n)
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		t.Fatalf("failed to read content: %v", err)
+	}
+	if cr.n != len(buf) {
+		t.Errorf("read %d bytes, want %d", cr.n, len(buf))
+	}
+
+	if _, err := open(); !errors.Is(err, ErrReaderConsumed) {
+		t.Errorf("second open() error = %v, want ErrReaderConsumed", err)
+	}
+}
+
+func TestReaderOpenerSeeker(t *testing.T) {
+
+	r := strings.NewReader("skipped|content")
+	if _, err := r.Seek(int64(len("skipped|")), io.SeekStart); err != nil {
+		t.Fatalf("failed to seek: %v", err)
+	}
+
+	open := ReaderOpener(r)
+
+	for i := 0; i < 2; i++ {
+		if got := readOpened(t, open); got != "content" {
+			t.Errorf("open %d: got content %q, want %q", i+1, got, "content")
+		}
+	}
+}
+
+func TestReaderOpenerPipe(t *testing.T) {
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer pr.Close()
+
+	go func() {
+		_, _ = pw.WriteString("piped content")
+		_ = pw.Close()
+	}()
+
+	open := ReaderOpener(pr)
+
+	if got := readOpened(t, open); got != "piped content" {
+		t.Errorf("got content %q, want %q", got, "piped content")
+	}
+
+	if _, err := open(); !errors.Is(err, ErrReaderConsumed) {
+		t.Errorf("second open() error = %v, want ErrReaderConsumed", err)
+	}
+}
+
+func TestReaderOpenerNilReader(t *testing.T) {
+
+	if _, err := ReaderOpener(nil)(); err == nil {
+		t.Error("expected error for nil reader")
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// These are the sentinel errors for the outcomes of attempts to terminate a
+// user session. Use errors.Is with the error returned by the Err method of a
+// TerminateUserSessionResult to check for a specific outcome.
+var (
+	ErrSessionNotFound     = errors.New("session does not exist")
+	ErrSessionNotSpecified = errors.New("session not specified")
+	ErrTerminationFailed   = errors.New("session termination call failed")
+	ErrTerminationTimeout  = errors.New("session termination timed out")
+	ErrOutcomeUnknown      = errors.New("unknown session termination outcome")
+	ErrInvalidSessionID    = errors.New("invalid session id")
+	ErrDryRun              = errors.New("session not terminated in dry-run mode")
+)
+
+// Outcome is the outcome of an attempt to terminate a user session.
+type Outcome int
+
+const (
+
+	// OutcomeUnknown indicates that the outcome could not be determined
+	// (e.g., an unexpected exit code or an exit code and output text which
+	// do not agree).
+	OutcomeUnknown Outcome = iota
+
+	// OutcomeTerminated indicates that the session was terminated.
+	OutcomeTerminated
+
+	// OutcomeNotFound indicates that EZproxy does not believe the session
+	// exists.
+	OutcomeNotFound
+
+	// OutcomeNotSpecified indicates that a session id was not provided.
+	OutcomeNotSpecified
+
+	// OutcomeExecFailed indicates that the termination call could not be
+	// made or did not complete (e.g., the executable was not found, the
+	// admin web interface could not be reached, the context was cancelled
+	// or, in dry-run mode, the session id is invalid).
+	OutcomeExecFailed
+
+	// OutcomeTimeout indicates that the termination call did not complete
+	// before the context deadline.
+	OutcomeTimeout
+
+	// OutcomeDryRun indicates that the session was validated in dry-run
+	// mode, but not terminated.
+	OutcomeDryRun
+)
+
+// String returns the name of the outcome.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeUnknown:
+		return "Unknown"
+	case OutcomeTerminated:
+		return "Terminated"
+	case OutcomeNotFound:
+		return "NotFound"
+	case OutcomeNotSpecified:
+		return "NotSpecified"
+	case OutcomeExecFailed:
+		return "ExecFailed"
+	case OutcomeTimeout:
+		return "Timeout"
+	case OutcomeDryRun:
+		return "DryRun"
+	default:
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
+}
+
+// Err returns the sentinel error for the outcome, or nil for
+// OutcomeTerminated.
+func (o Outcome) Err() error {
+	switch o {
+	case OutcomeTerminated:
+		return nil
+	case OutcomeNotFound:
+		return ErrSessionNotFound
+	case OutcomeNotSpecified:
+		return ErrSessionNotSpecified
+	case OutcomeExecFailed:
+		return ErrTerminationFailed
+	case OutcomeTimeout:
+		return ErrTerminationTimeout
+	case OutcomeDryRun:
+		return ErrDryRun
+	default:
+		return ErrOutcomeUnknown
+	}
+}
+
+// OutcomeError is the error returned by the Err method of a
+// TerminateUserSessionResult for any outcome other than OutcomeTerminated.
+// It matches the sentinel error for the outcome when used with errors.Is and
+// wraps the error (if any) recorded for the termination attempt.
+type OutcomeError struct {
+
+	// Outcome is the outcome of the termination attempt.
+	Outcome Outcome
+
+	// SessionID is the session id the termination attempt was made for.
+	SessionID string
+
+	// Err is the error (if any) recorded for the termination attempt.
+	Err error
+}
+
+// Error returns the text of the error.
+func (oe *OutcomeError) Error() string {
+	if oe.Err == nil {
+		return fmt.Sprintf("session %q: %v", oe.SessionID, oe.Outcome.Err())
+	}
+	return fmt.Sprintf("session %q: %v: %v", oe.SessionID, oe.Outcome.Err(), oe.Err)
+}
+
+// Unwrap returns the error recorded for the termination attempt.
+func (oe *OutcomeError) Unwrap() error {
+	return oe.Err
+}
+
+// Is reports whether the target is the sentinel error for the outcome.
+func (oe *OutcomeError) Is(target error) bool {
+	return target != nil && target == oe.Outcome.Err()
+}
+
+// Outcome returns the outcome of the termination attempt. The outcome is
+// derived from both the exit code and the output text of the `kill`
+// subcommand (or the equivalent values recorded by other SessionTerminator
+// backends). If both are recognized but do not agree, OutcomeUnknown is
+// returned.
+func (tusr TerminateUserSessionResult) Outcome() Outcome {
+
+	switch {
+	case errors.Is(tusr.Error, context.DeadlineExceeded):
+		return OutcomeTimeout
+
+	case errors.Is(tusr.Error, ErrSessionNotSpecified):
+		return OutcomeNotSpecified
+
+	case tusr.DryRun && tusr.Error == nil:
+		return OutcomeDryRun
+
+	// a response was received, but did not confirm the outcome
+	case errors.Is(tusr.Error, ErrOutcomeUnknown):
+		return OutcomeUnknown
+
+	// the call did not produce an exit code
+	case tusr.Error != nil && tusr.ExitCode == -1:
+		return OutcomeExecFailed
+	}
+
+	textOutcome := outcomeFromText(tusr.SessionID, tusr.StdOut)
+	codeOutcome := outcomeFromExitCode(tusr.ExitCode)
+
+	switch {
+	case textOutcome == OutcomeUnknown:
+		return codeOutcome
+	case codeOutcome == OutcomeUnknown, textOutcome == codeOutcome:
+		return textOutcome
+	default:
+		return OutcomeUnknown
+	}
+}
+
+// Err returns nil if the session was terminated, otherwise an *OutcomeError
+// matching the sentinel error for the outcome of the termination attempt.
+func (tusr TerminateUserSessionResult) Err() error {
+
+	outcome := tusr.Outcome()
+	if outcome == OutcomeTerminated {
+		return nil
+	}
+
+	return &OutcomeError{
+		Outcome:   outcome,
+		SessionID: tusr.SessionID,
+		Err:       tusr.Error,
+	}
+}
+
+// MatchingOutcome returns the results with one of the specified outcomes.
+func (tusr TerminateUserSessionResults) MatchingOutcome(outcomes ...Outcome) TerminateUserSessionResults {
+
+	matching := make(TerminateUserSessionResults, 0, len(tusr))
+
+	for idx := range tusr {
+		outcome := tusr[idx].Outcome()
+		for _, o := range outcomes {
+			if outcome == o {
+				matching = append(matching, tusr[idx])
+				break
+			}
+		}
+	}
+
+	return matching
+}
+
+// outcomeFromExitCode returns the outcome indicated by the exit code of the
+// `kill` subcommand.
+func outcomeFromExitCode(exitCode int) Outcome {
+	switch exitCode {
+	case KillSubCmdExitCodeSessionTerminated:
+		return OutcomeTerminated
+	case KillSubCmdExitCodeSessionNotSpecified:
+		return OutcomeNotSpecified
+	case KillSubCmdExitCodeSessionDoesNotExist:
+		return OutcomeNotFound
+	default:
+		return OutcomeUnknown
+	}
+}
+
+// outcomeFromText returns the outcome indicated by the output text of the
+// `kill` subcommand for the specified session id.
+func outcomeFromText(sessionID string, text string) Outcome {
+
+	text = strings.TrimSpace(text)
+
+	switch {
+	case text == "":
+		return OutcomeUnknown
+	case strings.Contains(text, KillSubCmdExitTextSessionNotSpecified):
+		return OutcomeNotSpecified
+	case sessionID == "":
+		return OutcomeUnknown
+	case strings.Contains(text, fmt.Sprintf(KillSubCmdExitTextTemplateSessionTerminated, sessionID)):
+		return OutcomeTerminated
+	case strings.Contains(text, fmt.Sprintf(KillSubCmdExitTextTemplateSessionDoesNotExist, sessionID)):
+		return OutcomeNotFound
+	default:
+		return OutcomeUnknown
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTerminateUserSessionResultOutcome(t *testing.T) {
+
+	const sessionID = "aBcDeFgHiJkLmNo"
+
+	terminatedText := fmt.Sprintf(KillSubCmdExitTextTemplateSessionTerminated, sessionID)
+	notFoundText := fmt.Sprintf(KillSubCmdExitTextTemplateSessionDoesNotExist, sessionID)
+	exitErr := errors.New("exit status 3")
+
+	tests := []struct {
+		name     string
+		result   TerminateUserSessionResult
+		outcome  Outcome
+		sentinel error
+	}{
+		{
+			name:    "terminated",
+			result:  TerminateUserSessionResult{ExitCode: KillSubCmdExitCodeSessionTerminated, StdOut: terminatedText},
+			outcome: OutcomeTerminated,
+		},
+		{
+			name:    "terminated exit code without text",
+			result:  TerminateUserSessionResult{ExitCode: KillSubCmdExitCodeSessionTerminated},
+			outcome: OutcomeTerminated,
+		},
+		{
+			name:     "not found",
+			result:   TerminateUserSessionResult{ExitCode: KillSubCmdExitCodeSessionDoesNotExist, StdOut: notFoundText, Error: exitErr},
+			outcome:  OutcomeNotFound,
+			sentinel: ErrSessionNotFound,
+		},
+		{
+			name:     "not specified",
+			result:   TerminateUserSessionResult{ExitCode: KillSubCmdExitCodeSessionNotSpecified, StdOut: KillSubCmdExitTextSessionNotSpecified},
+			outcome:  OutcomeNotSpecified,
+			sentinel: ErrSessionNotSpecified,
+		},
+		{
+			name:     "not specified error",
+			result:   TerminateUserSessionResult{ExitCode: -1, Error: fmt.Errorf("wrapped: %w", ErrSessionNotSpecified)},
+			outcome:  OutcomeNotSpecified,
+			sentinel: ErrSessionNotSpecified,
+		},
+		{
+			name:     "text with unexpected exit code",
+			result:   TerminateUserSessionResult{ExitCode: 7, StdOut: notFoundText},
+			outcome:  OutcomeNotFound,
+			sentinel: ErrSessionNotFound,
+		},
+		{
+			name:     "exit code and text disagree",
+			result:   TerminateUserSessionResult{ExitCode: KillSubCmdExitCodeSessionTerminated, StdOut: notFoundText},
+			outcome:  OutcomeUnknown,
+			sentinel: ErrOutcomeUnknown,
+		},
+		{
+			name:     "text for another session",
+			result:   TerminateUserSessionResult{ExitCode: 7, StdOut: fmt.Sprintf(KillSubCmdExitTextTemplateSessionTerminated, "pQrStUvWxYz0123")},
+			outcome:  OutcomeUnknown,
+			sentinel: ErrOutcomeUnknown,
+		},
+		{
+			name:     "unexpected exit code",
+			result:   TerminateUserSessionResult{ExitCode: 9},
+			outcome:  OutcomeUnknown,
+			sentinel: ErrOutcomeUnknown,
+		},
+		{
+			name:     "unconfirmed response",
+			result:   TerminateUserSessionResult{ExitCode: -1, Error: fmt.Errorf("wrapped: %w", ErrOutcomeUnknown)},
+			outcome:  OutcomeUnknown,
+			sentinel: ErrOutcomeUnknown,
+		},
+		{
+			name:     "exec failed",
+			result:   TerminateUserSessionResult{ExitCode: -1, Error: &exec.Error{Name: "ezproxy", Err: exec.ErrNotFound}},
+			outcome:  OutcomeExecFailed,
+			sentinel: ErrTerminationFailed,
+		},
+		{
+			name:     "cancelled",
+			result:   TerminateUserSessionResult{ExitCode: -1, Error: context.Canceled},
+			outcome:  OutcomeExecFailed,
+			sentinel: ErrTerminationFailed,
+		},
+		{
+			name:     "timeout",
+			result:   TerminateUserSessionResult{ExitCode: -1, Error: fmt.Errorf("%w: signal: killed", context.DeadlineExceeded)},
+			outcome:  OutcomeTimeout,
+			sentinel: ErrTerminationTimeout,
+		},
+		{
+			name:     "dry run",
+			result:   TerminateUserSessionResult{ExitCode: -1, DryRun: true},
+			outcome:  OutcomeDryRun,
+			sentinel: ErrDryRun,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.result.SessionID = sessionID
+
+			if got := tt.result.Outcome(); got != tt.outcome {
+				t.Errorf("Outcome() = %v, want %v", got, tt.outcome)
+			}
+
+			err := tt.result.Err()
+
+			if tt.sentinel == nil {
+				if err != nil {
+					t.Errorf("Err() = %v, want nil", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("Err() = %v, want %v", err, tt.sentinel)
+			}
+
+			var outcomeErr *OutcomeError
+			if !errors.As(err, &outcomeErr) || outcomeErr.Outcome != tt.outcome || outcomeErr.SessionID != sessionID {
+				t.Errorf("Err() = %#v, want *OutcomeError for outcome %v", err, tt.outcome)
+			}
+
+			// The error recorded for the attempt remains available.
+			if tt.result.Error != nil && !errors.Is(err, tt.result.Error) {
+				t.Errorf("Err() = %v does not wrap %v", err, tt.result.Error)
+			}
+		})
+	}
+}
+
+func TestExecTerminatorOutcome(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+
+	// The script behaves like the `kill` subcommand of the ezproxy binary
+	// for a session which does not exist and for a missing session ID.
+	executable := filepath.Join(t.TempDir(), "ezproxy")
+	script := "#!/bin/sh\n" +
+		"if [ -z \"$2\" ]; then echo \"Session must be specified\"; exit 1; fi\n" +
+		"echo \"Session $2 does not exist\"\nexit 3\n"
+
+	// #nosec G306
+	if err := os.WriteFile(executable, []byte(script), 0o700); err != nil {
+		t.Fatalf("failed to write executable: %v", err)
+	}
+
+	results := TerminateUserSession(
+		executable,
+		UserSession{SessionID: "aBcDeFgHiJkLmNo"},
+		UserSession{},
+	)
+
+	want := []Outcome{OutcomeNotFound, OutcomeNotSpecified}
+	for idx := range results {
+		if got := results[idx].Outcome(); got != want[idx] {
+			t.Errorf("result %d Outcome() = %v, want %v (exit code %d, stdout %q)",
+				idx, got, want[idx], results[idx].ExitCode, results[idx].StdOut)
+		}
+	}
+
+	results = TerminateUserSession(filepath.Join(t.TempDir(), "missing"), UserSession{SessionID: "aBcDeFgHiJkLmNo"})
+	if got := results[0].Outcome(); got != OutcomeExecFailed {
+		t.Errorf("Outcome() = %v for missing executable, want %v", got, OutcomeExecFailed)
+	}
+}
+
+func TestOutcomeString(t *testing.T) {
+
+	outcomes := map[Outcome]string{
+		OutcomeUnknown:      "Unknown",
+		OutcomeTerminated:   "Terminated",
+		OutcomeNotFound:     "NotFound",
+		OutcomeNotSpecified: "NotSpecified",
+		OutcomeExecFailed:   "ExecFailed",
+		OutcomeTimeout:      "Timeout",
+		OutcomeDryRun:       "DryRun",
+		Outcome(42):         "Outcome(42)",
+	}
+
+	for outcome, want := range outcomes {
+		if got := outcome.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+
+	if err := Outcome(42).Err(); !errors.Is(err, ErrOutcomeUnknown) {
+		t.Errorf("Err() = %v for unknown outcome, want %v", err, ErrOutcomeUnknown)
+	}
+}
+
+func TestMatchingOutcome(t *testing.T) {
+
+	results := TerminateUserSessionResults{
+		terminatedResult("aaaaaaaaaaaaaaa"),
+		notFoundResult("bbbbbbbbbbbbbbb"),
+		terminatedResult("ccccccccccccccc"),
+		{UserSession: UserSession{SessionID: "ddddddddddddddd"}, ExitCode: 9},
+	}
+
+	matching := results.MatchingOutcome(OutcomeTerminated, OutcomeUnknown)
+
+	want := []string{"aaaaaaaaaaaaaaa", "ccccccccccccccc", "ddddddddddddddd"}
+	got := make([]string, 0, len(matching))
+	for idx := range matching {
+		got = append(got, matching[idx].SessionID)
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("MatchingOutcome() = %v, want %v", got, want)
+	}
+
+	if results.HasError() {
+		t.Error("HasError() = true, want false")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reaper selects user sessions which have been idle for too long or which
+// have existed for too long and terminates them using the `kill` subcommand
+// of the ezproxy binary (or using another SessionTerminator backend).
+// Sessions without a known last access or creation time (e.g., those
+// retrieved from the audit log) are never selected.
+type Reaper struct {
+
+	// backend is used to terminate each user session.
+	backend SessionTerminator
+
+	// maxIdle is the maximum time a session may be idle before it is
+	// selected for termination. Zero disables this check.
+	maxIdle time.Duration
+
+	// maxAge is the maximum time a session may exist before it is selected
+	// for termination. Zero disables this check.
+	maxAge time.Duration
+
+	// allowlist is the list of usernames whose sessions are never
+	// terminated.
+	allowlist []string
+
+	// options are the optional settings applied when terminating user
+	// sessions.
+	options TerminateOptions
+}
+
+// ReapReport reflects the result of a Reaper processing a collection of user
+// sessions.
+type ReapReport struct {
+
+	// Selected is the collection of sessions selected for termination.
+	Selected UserSessions
+
+	// Skipped is the collection of sessions which would have been selected
+	// for termination if not for the allowlist.
+	Skipped UserSessions
+
+	// Results is the collection of termination results for the selected
+	// sessions. In dry-run mode these are the results of validating the
+	// sessions (see NewDryRunTerminator).
+	Results TerminateUserSessionResults
+
+	// DryRun indicates whether the sessions were selected in dry-run mode.
+	DryRun bool
+}
+
+// NewReaper creates a new Reaper which uses the provided executable to
+// terminate user sessions. At least one of the idle or age limits must be
+// set before sessions are selected for termination.
+func NewReaper(executable string) (*Reaper, error) {
+
+	backend, err := NewExecTerminator(executable)
+	if err != nil {
+		return nil, fmt.Errorf("func NewReaper: %w", err)
+	}
+
+	return &Reaper{
+		backend:   backend,
+		allowlist: make([]string, 0, SessionsLimit),
+	}, nil
+}
+
+// SetBackend is a helper method for setting the backend used to terminate
+// each user session (e.g., an AdminTerminator instead of the default
+// ExecTerminator).
+func (r *Reaper) SetBackend(backend SessionTerminator) error {
+	if backend == nil {
+		return errors.New("func SetBackend: missing backend")
+	}
+
+	r.backend = backend
+
+	return nil
+}
+
+// SetMaxIdle is a helper method for setting the maximum time a session may be
+// idle before it is selected for termination. Zero disables this check.
+func (r *Reaper) SetMaxIdle(maxIdle time.Duration) error {
+	if maxIdle < 0 {
+		return fmt.Errorf("func SetMaxIdle: %v is not a valid maximum idle time", maxIdle)
+	}
+
+	r.maxIdle = maxIdle
+
+	return nil
+}
+
+// SetMaxAge is a helper method for setting the maximum time a session may
+// exist before it is selected for termination. Zero disables this check.
+func (r *Reaper) SetMaxAge(maxAge time.Duration) error {
+	if maxAge < 0 {
+		return fmt.Errorf("func SetMaxAge: %v is not a valid maximum session age", maxAge)
+	}
+
+	r.maxAge = maxAge
+
+	return nil
+}
+
+// SetDryRun is a helper method for enabling or disabling dry-run mode. In
+// dry-run mode sessions are selected for termination, but not terminated.
+func (r *Reaper) SetDryRun(dryRun bool) {
+	r.options.DryRun = dryRun
+}
+
+// SetVerifier is a helper method for setting the reader used to confirm
+// that terminated sessions are no longer listed, using the search retries
+// and search delay settings of the reader. A nil reader disables
+// verification.
+func (r *Reaper) SetVerifier(reader SessionsReader) {
+	r.options.Verifier = reader
+}
+
+// SetAllowlist is a helper method for setting the list of usernames whose
+// sessions must never be terminated. Usernames are matched
+// case-insensitively.
+func (r *Reaper) SetAllowlist(usernames ...string) {
+	r.allowlist = append(r.allowlist[:0], usernames...)
+}
+
+// allowed reports whether the specified username is in the allowlist.
+func (r *Reaper) allowed(username string) bool {
+	for _, allowed := range r.allowlist {
+		if strings.EqualFold(allowed, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether the session exceeds the idle or age limits as of
+// the given time.
+func (r *Reaper) expired(session UserSession, now time.Time) bool {
+
+	if r.maxIdle > 0 && !session.LastAccessed.IsZero() &&
+		session.IdleTime(now) > r.maxIdle {
+		return true
+	}
+
+	if r.maxAge > 0 && !session.Created.IsZero() &&
+		session.Age(now) > r.maxAge {
+		return true
+	}
+
+	return false
+}
+
+// Select returns the sessions which exceed the idle or age limits as of the
+// given time. Sessions for usernames in the allowlist are returned
+// separately as skipped sessions.
+func (r *Reaper) Select(sessions UserSessions, now time.Time) (UserSessions, UserSessions) {
+
+	selected := make(UserSessions, 0, len(sessions))
+	skipped := make(UserSessions, 0, SessionsLimit)
+
+	for idx := range sessions {
+
+		if !r.expired(sessions[idx], now) {
+			continue
+		}
+
+		if r.allowed(sessions[idx].Username) {
+			Logger.Printf(
+				"Skipping session %q for allowlisted username %q\n",
+				sessions[idx].SessionID,
+				sessions[idx].Username,
+			)
+			skipped = append(skipped, sessions[idx])
+			continue
+		}
+
+		selected = append(selected, sessions[idx])
+	}
+
+	return selected, skipped
+}
+
+// Reap selects the sessions which exceed the idle or age limits as of the
+// current time and terminates them (unless in dry-run mode).
+func (r *Reaper) Reap(sessions UserSessions) ReapReport {
+	return r.ReapContext(context.Background(), sessions)
+}
+
+// ReapContext behaves like Reap, but uses the provided context to end the
+// `kill` subcommand calls if the context is done before they complete.
+func (r *Reaper) ReapContext(ctx context.Context, sessions UserSessions) ReapReport {
+
+	selected, skipped := r.Select(sessions, time.Now())
+
+	Logger.Printf(
+		"Selected %d of %d sessions for termination (%d skipped, dry-run: %t)\n",
+		len(selected),
+		len(sessions),
+		len(skipped),
+		r.options.DryRun,
+	)
+
+	report := ReapReport{
+		Selected: selected,
+		Skipped:  skipped,
+		DryRun:   r.options.DryRun,
+	}
+
+	if len(selected) == 0 {
+		return report
+	}
+
+	report.Results = terminateSessions(ctx, r.backend, r.options, selected)
+
+	return report
+}
+
+// HasError returns true if any errors were recorded when terminating user
+// sessions, false otherwise.
+func (rr ReapReport) HasError() bool {
+	return rr.Results.HasError()
+}
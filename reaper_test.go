@@ -0,0 +1,221 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTerminator is a SessionTerminator backend which reports each
+// user session as terminated and records the sessions in the order that
+// they were received.
+type recordingTerminator struct {
+	mu       sync.Mutex
+	sessions []string
+}
+
+func (rt *recordingTerminator) TerminateSession(ctx context.Context, session UserSession) TerminateUserSessionResult {
+	rt.mu.Lock()
+	rt.sessions = append(rt.sessions, session.SessionID)
+	rt.mu.Unlock()
+
+	result := terminatedResult(session.SessionID)
+	result.UserSession = session
+
+	return result
+}
+
+// received returns the session IDs received by the backend.
+func (rt *recordingTerminator) received() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]string(nil), rt.sessions...)
+}
+
+// sessionIDs returns the session ID of each user session.
+func sessionIDs(sessions UserSessions) []string {
+	ids := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.SessionID)
+	}
+	return ids
+}
+
+func TestReaperSelect(t *testing.T) {
+
+	now := time.Date(2020, time.September, 25, 12, 0, 0, 0, time.UTC)
+
+	session := func(id string, username string, created time.Duration, idle time.Duration) UserSession {
+		us := UserSession{SessionID: id, Username: username}
+		if created > 0 {
+			us.Created = now.Add(-created)
+		}
+		if idle > 0 {
+			us.LastAccessed = now.Add(-idle)
+		}
+		return us
+	}
+
+	sessions := UserSessions{
+		session("active", "alice", time.Hour, time.Minute),
+		session("idle-limit", "alice", time.Hour, 30*time.Minute),
+		session("idle", "bob", time.Hour, 31*time.Minute),
+		session("age-limit", "carol", 8*time.Hour, time.Minute),
+		session("old", "carol", 8*time.Hour+time.Second, time.Minute),
+		session("unknown", "dave", 0, 0),
+		session("allowed", "Admin", 24*time.Hour, 24*time.Hour),
+	}
+
+	tests := []struct {
+		name     string
+		maxIdle  time.Duration
+		maxAge   time.Duration
+		selected []string
+		skipped  []string
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name:     "idle",
+			maxIdle:  30 * time.Minute,
+			selected: []string{"idle"},
+			skipped:  []string{"allowed"},
+		},
+		{
+			name:     "age",
+			maxAge:   8 * time.Hour,
+			selected: []string{"old"},
+			skipped:  []string{"allowed"},
+		},
+		{
+			name:     "idle or age",
+			maxIdle:  30 * time.Minute,
+			maxAge:   8 * time.Hour,
+			selected: []string{"idle", "old"},
+			skipped:  []string{"allowed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReaper("ezproxy")
+			if err != nil {
+				t.Fatalf("NewReaper() error = %v", err)
+			}
+			if err := r.SetMaxIdle(tt.maxIdle); err != nil {
+				t.Fatalf("SetMaxIdle() error = %v", err)
+			}
+			if err := r.SetMaxAge(tt.maxAge); err != nil {
+				t.Fatalf("SetMaxAge() error = %v", err)
+			}
+			r.SetAllowlist("admin")
+
+			selected, skipped := r.Select(sessions, now)
+
+			if got := sessionIDs(selected); fmt.Sprint(got) != fmt.Sprint(tt.selected) {
+				t.Errorf("selected = %v, want %v", got, tt.selected)
+			}
+			if got := sessionIDs(skipped); fmt.Sprint(got) != fmt.Sprint(tt.skipped) {
+				t.Errorf("skipped = %v, want %v", got, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestReaperReap(t *testing.T) {
+
+	now := time.Now()
+
+	sessions := UserSessions{
+		{SessionID: "aaaaaaaaaaaaaaa", Username: "alice", LastAccessed: now.Add(-2 * time.Hour)},
+		{SessionID: "bbbbbbbbbbbbbbb", Username: "bob", LastAccessed: now},
+		{SessionID: "ccccccccccccccc", Username: "carol", LastAccessed: now.Add(-3 * time.Hour)},
+		{SessionID: "ddddddddddddddd", Username: "admin", LastAccessed: now.Add(-3 * time.Hour)},
+	}
+
+	r, err := NewReaper("ezproxy")
+	if err != nil {
+		t.Fatalf("NewReaper() error = %v", err)
+	}
+	if err := r.SetMaxIdle(time.Hour); err != nil {
+		t.Fatalf("SetMaxIdle() error = %v", err)
+	}
+	r.SetAllowlist("ADMIN")
+
+	backend := &recordingTerminator{}
+	if err := r.SetBackend(backend); err != nil {
+		t.Fatalf("SetBackend() error = %v", err)
+	}
+
+	report := r.Reap(sessions)
+
+	want := []string{"aaaaaaaaaaaaaaa", "ccccccccccccccc"}
+	if got := backend.received(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("terminated sessions = %v, want %v", got, want)
+	}
+
+	if got := sessionIDs(report.Selected); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Selected = %v, want %v", got, want)
+	}
+
+	if got := sessionIDs(report.Skipped); fmt.Sprint(got) != "[ddddddddddddddd]" {
+		t.Errorf("Skipped = %v, want [ddddddddddddddd]", got)
+	}
+
+	if len(report.Results) != len(want) || report.HasError() || report.DryRun {
+		t.Fatalf("Results = %+v, want %d terminated sessions", report.Results, len(want))
+	}
+
+	for idx := range report.Results {
+		if report.Results[idx].SessionID != want[idx] || report.Results[idx].Outcome() != OutcomeTerminated {
+			t.Errorf("result %d = %+v, want session %q terminated", idx, report.Results[idx], want[idx])
+		}
+	}
+}
+
+func TestReaperSettings(t *testing.T) {
+
+	if _, err := NewReaper(""); err == nil {
+		t.Error("NewReaper() error = nil for missing executable, want error")
+	}
+
+	r, err := NewReaper("ezproxy")
+	if err != nil {
+		t.Fatalf("NewReaper() error = %v", err)
+	}
+
+	if err := r.SetMaxIdle(-time.Second); err == nil {
+		t.Error("SetMaxIdle() error = nil for negative value, want error")
+	}
+	if err := r.SetMaxAge(-time.Second); err == nil {
+		t.Error("SetMaxAge() error = nil for negative value, want error")
+	}
+	if err := r.SetBackend(nil); err == nil {
+		t.Error("SetBackend() error = nil for missing backend, want error")
+	}
+
+	// Without limits no sessions are selected and nothing is terminated.
+	report := r.Reap(UserSessions{{SessionID: "aaaaaaaaaaaaaaa", LastAccessed: time.Now().Add(-24 * time.Hour)}})
+	if len(report.Selected) != 0 || report.Results != nil {
+		t.Errorf("Reap() = %+v, want no sessions selected", report)
+	}
+}
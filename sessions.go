@@ -0,0 +1,104 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"sort"
+	"time"
+)
+
+// IdleTime returns how long the session has been idle as of the given time.
+// Zero is returned if the last access time is not known.
+func (us UserSession) IdleTime(now time.Time) time.Duration {
+	if us.LastAccessed.IsZero() {
+		return 0
+	}
+	return now.Sub(us.LastAccessed)
+}
+
+// Age returns how long the session has existed as of the given time. Zero is
+// returned if the creation time is not known.
+func (us UserSession) Age(now time.Time) time.Duration {
+	if us.Created.IsZero() {
+		return 0
+	}
+	return now.Sub(us.Created)
+}
+
+// Expires returns when the session will expire if it remains idle. The zero
+// value is returned if the last access time or MaxLifetime value is not
+// known.
+func (us UserSession) Expires() time.Time {
+	if us.LastAccessed.IsZero() || us.MaxLifetime <= 0 {
+		return time.Time{}
+	}
+	return us.LastAccessed.Add(us.MaxLifetime)
+}
+
+// IdleSessions returns the sessions which have been idle for at least the
+// specified duration as of the given time. Sessions without a known last
+// access time are excluded.
+func (uss UserSessions) IdleSessions(idle time.Duration, now time.Time) UserSessions {
+
+	sessions := make(UserSessions, 0, len(uss))
+
+	for idx := range uss {
+		if !uss[idx].LastAccessed.IsZero() && uss[idx].IdleTime(now) >= idle {
+			sessions = append(sessions, uss[idx])
+		}
+	}
+
+	return sessions
+}
+
+// ExpiringSessions returns the sessions which will expire within the
+// specified duration of the given time if they remain idle. Sessions without
+// a known expiration time are excluded.
+func (uss UserSessions) ExpiringSessions(within time.Duration, now time.Time) UserSessions {
+
+	sessions := make(UserSessions, 0, len(uss))
+
+	for idx := range uss {
+		expires := uss[idx].Expires()
+		if !expires.IsZero() && !expires.After(now.Add(within)) {
+			sessions = append(sessions, uss[idx])
+		}
+	}
+
+	return sessions
+}
+
+// OldestSessions returns a copy of the sessions sorted by creation time,
+// oldest first. Sessions without a known creation time are listed last.
+func (uss UserSessions) OldestSessions() UserSessions {
+
+	sessions := make(UserSessions, len(uss))
+	copy(sessions, uss)
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		switch {
+		case sessions[i].Created.IsZero():
+			return false
+		case sessions[j].Created.IsZero():
+			return true
+		default:
+			return sessions[i].Created.Before(sessions[j].Created)
+		}
+	})
+
+	return sessions
+}
@@ -18,18 +18,24 @@ package ezproxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os/exec"
 	"strings"
 )
 
 // Terminator is an interface that represents the ability to terminate user
-// sessions via the Terminate method.
+// sessions via the Terminate method. The Terminate method does not accept a
+// context or user sessions, so these are bound to a SessionTerminator backend
+// using NewTerminator.
 type Terminator interface {
 	Terminate() TerminateUserSessionResults
 }
 
 // TerminateUserSessionResult reflects the result of calling the `kill`
-// subcommand of the ezproxy binary to terminate a specific user session.
+// subcommand of the ezproxy binary to terminate a specific user session. Use
+// the Outcome method to interpret the exit code and output text.
 type TerminateUserSessionResult struct {
 
 	// UserSession value is embedded in an attempt to tie together termination
@@ -51,114 +57,241 @@ type TerminateUserSessionResult struct {
 	// Error is the error (if any) from the attempt to run the specified
 	// command
 	Error error
+
+	// Command is the command line run (or in dry-run mode, the command line
+	// which would have been run) to terminate the session.
+	Command string
+
+	// DryRun indicates that the session was validated, but the command was
+	// not run.
+	DryRun bool
+
+	// Verified indicates whether the session was confirmed to be no longer
+	// listed after termination. See the Verify method of
+	// TerminateUserSessionResults.
+	Verified bool
 }
 
 // TerminateUserSessionResults is a collection of user session termination
 // results. Intended for bulk processing of some kind.
 type TerminateUserSessionResults []TerminateUserSessionResult
 
+// TerminateOptions provides the optional settings used when terminating user
+// sessions. The zero value terminates the sessions without verification.
+type TerminateOptions struct {
+
+	// DryRun indicates that the sessions are validated, but not terminated.
+	// See NewDryRunTerminator for details.
+	DryRun bool
+
+	// Verifier (if set) is used to confirm that terminated sessions are no
+	// longer listed once all sessions have been processed, using the search
+	// retries and search delay settings of the reader. See the Verify method
+	// of TerminateUserSessionResults.
+	Verifier SessionsReader
+}
+
+// backend returns the provided backend, wrapped so that sessions are
+// validated without being terminated in dry-run mode.
+func (o TerminateOptions) backend(backend SessionTerminator) SessionTerminator {
+	if !o.DryRun {
+		return backend
+	}
+	if _, ok := backend.(*dryRunTerminator); ok {
+		return backend
+	}
+	return &dryRunTerminator{backend: backend}
+}
+
+// verify confirms that the terminated sessions are no longer listed if a
+// verifier is set. Failing to read the sessions is logged and leaves the
+// results unverified.
+func (o TerminateOptions) verify(ctx context.Context, results TerminateUserSessionResults) {
+
+	if o.Verifier == nil {
+		return
+	}
+
+	if err := results.Verify(ctx, o.Verifier); err != nil {
+		Logger.Printf("Failed to verify terminated sessions: %v\n", err)
+	}
+}
+
 // TerminateUserSession receives the path to an executable and one or many
 // UserSession values, calling the `kill` subcommand of that (presumably
 // ezproxy) binary. The result code, stdout, stderr output is captured for
 // each subcommand call and returned (along with other details) as a slice of
 // `TerminateUserSessionResult`.
 func TerminateUserSession(executable string, sessions ...UserSession) TerminateUserSessionResults {
+	return TerminateUserSessionContext(context.Background(), executable, sessions...)
+}
+
+// TerminateUserSessionContext behaves like TerminateUserSession, but uses the
+// provided context to end the `kill` subcommand call for a session if the
+// context is done before the call completes (e.g., to enforce a deadline).
+// Sessions not yet processed when the context is done are not terminated;
+// the context error is recorded in the result for each of them.
+func TerminateUserSessionContext(ctx context.Context, executable string, sessions ...UserSession) TerminateUserSessionResults {
+	return TerminateUserSessionWithOptions(ctx, executable, TerminateOptions{}, sessions...)
+}
+
+// TerminateUserSessionWithOptions behaves like TerminateUserSessionContext,
+// but applies the provided options (e.g., to validate the sessions in
+// dry-run mode or to verify that the terminated sessions are no longer
+// listed).
+func TerminateUserSessionWithOptions(ctx context.Context, executable string, options TerminateOptions, sessions ...UserSession) TerminateUserSessionResults {
+
+	if ctx == nil {
+		results := make(TerminateUserSessionResults, 0, len(sessions))
+		for _, session := range sessions {
+			results = append(results, TerminateUserSessionResult{
+				UserSession: session,
+				ExitCode:    -1,
+				Error:       errors.New("func TerminateUserSessionWithOptions: missing context"),
+			})
+		}
+		return results
+	}
+
+	return terminateSessions(ctx, &ExecTerminator{executable: executable}, options, sessions)
+}
+
+// terminateSessions terminates each of the provided user sessions in turn
+// using the specified backend, returning the results in the same order as
+// the sessions, with the provided options applied.
+func terminateSessions(ctx context.Context, backend SessionTerminator, options TerminateOptions, sessions []UserSession) TerminateUserSessionResults {
+
+	results := make(TerminateUserSessionResults, 0, SessionsLimit)
 
-	results := make([]TerminateUserSessionResult, 0, SessionsLimit)
+	backend = options.backend(backend)
 
 	for _, session := range sessions {
+		results = append(results, backend.TerminateSession(ctx, session))
+	}
+
+	options.verify(ctx, results)
 
+	return results
+}
+
+// terminateSession calls the `kill` subcommand of the provided executable to
+// terminate the specified user session, ending the call if the provided
+// context is done before it completes.
+func terminateSession(ctx context.Context, executable string, session UserSession) TerminateUserSessionResult {
+
+	if err := ctx.Err(); err != nil {
 		Logger.Printf(
-			"Terminating session %q for username %q ... ",
+			"Skipping termination of session %q for username %q: %v\n",
 			session.SessionID,
 			session.Username,
+			err,
 		)
-
-		// Accepting variables here is intentional; we need to provide the
-		// flexibility for client code to pass-in site-specific values. This
-		// allows for custom EZproxy installations which may place the
-		// application and associated files in a non-default location.
-		//
-		// nolint:gosec
-		cmd := exec.Command(
-			executable,
-			SubCmdNameSessionTerminate,
-			session.SessionID,
-		)
-
-		printCmdStr := func(cmd *exec.Cmd) string {
-			return strings.Join(cmd.Args, " ")
+		return TerminateUserSessionResult{
+			UserSession: session,
+			ExitCode:    -1,
+			Error:       err,
 		}
+	}
 
-		Logger.Printf("Executing: %s\n", printCmdStr(cmd))
+	Logger.Printf(
+		"Terminating session %q for username %q ... ",
+		session.SessionID,
+		session.Username,
+	)
+
+	// Accepting variables here is intentional; we need to provide the
+	// flexibility for client code to pass-in site-specific values. This
+	// allows for custom EZproxy installations which may place the
+	// application and associated files in a non-default location.
+	//
+	// nolint:gosec
+	cmd := exec.CommandContext(
+		ctx,
+		executable,
+		SubCmdNameSessionTerminate,
+		session.SessionID,
+	)
+
+	printCmdStr := func(cmd *exec.Cmd) string {
+		return strings.Join(cmd.Args, " ")
+	}
 
-		// setup buffer to capture stdout
-		var cmdStdOut bytes.Buffer
-		cmd.Stdout = &cmdStdOut
+	Logger.Printf("Executing: %s\n", printCmdStr(cmd))
 
-		// setup buffer to capture stderr
-		var cmdStdErr bytes.Buffer
-		cmd.Stderr = &cmdStdErr
+	// setup buffer to capture stdout
+	var cmdStdOut bytes.Buffer
+	cmd.Stdout = &cmdStdOut
 
-		cmdErr := cmd.Run()
-		if cmdErr != nil {
+	// setup buffer to capture stderr
+	var cmdStdErr bytes.Buffer
+	cmd.Stderr = &cmdStdErr
 
-			switch v := cmdErr.(type) {
+	cmdErr := cmd.Run()
+	if cmdErr != nil {
 
-			// returned by LookPath when it fails to classify a file as an
-			// executable.
-			case *exec.Error:
+		switch v := cmdErr.(type) {
 
-				Logger.Printf(
-					"An error occurred attempting to run %q: %v\n",
-					printCmdStr(cmd),
-					v.Error(),
-				)
+		// returned by LookPath when it fails to classify a file as an
+		// executable.
+		case *exec.Error:
 
-			// command fail; non-zero (unsuccessful) exit code
-			case *exec.ExitError:
+			Logger.Printf(
+				"An error occurred attempting to run %q: %v\n",
+				printCmdStr(cmd),
+				v.Error(),
+			)
 
-				if cmd.ProcessState.ExitCode() == -1 {
-					Logger.Println("-1 returned from ExitCode() method")
+		// command fail; non-zero (unsuccessful) exit code
+		case *exec.ExitError:
 
-					if cmd.ProcessState.Exited() {
-						Logger.Println("cmd has exited per Exited() method")
-					} else {
-						Logger.Println("cmd has NOT exited per Exited() method")
-					}
-				}
+			if cmd.ProcessState.ExitCode() == -1 {
+				Logger.Println("-1 returned from ExitCode() method")
 
-			default:
+				if cmd.ProcessState.Exited() {
+					Logger.Println("cmd has exited per Exited() method")
+				} else {
+					Logger.Println("cmd has NOT exited per Exited() method")
+				}
+			}
 
-				Logger.Printf(
-					"An unexpected error occurred attempting to run %q: [Type: %T Text: %q]\n",
-					printCmdStr(cmd),
-					cmdErr,
-					cmdErr.Error(),
-				)
+		default:
 
-			}
+			Logger.Printf(
+				"An unexpected error occurred attempting to run %q: [Type: %T Text: %q]\n",
+				printCmdStr(cmd),
+				cmdErr,
+				cmdErr.Error(),
+			)
 
 		}
 
-		Logger.Printf("Exit Code: %d\n", cmd.ProcessState.ExitCode())
-		Logger.Printf("Captured stdout: %s\n", cmdStdOut.String())
-		Logger.Printf("Captured stderr: %s\n", cmdStdErr.String())
+	}
 
-		result := TerminateUserSessionResult{
-			UserSession: session,
-			ExitCode:    cmd.ProcessState.ExitCode(),
-			StdOut:      strings.TrimSpace(cmdStdOut.String()),
-			StdErr:      strings.TrimSpace(cmdStdErr.String()),
-			Error:       cmdErr,
-		}
+	Logger.Printf("Exit Code: %d\n", cmd.ProcessState.ExitCode())
+	Logger.Printf("Captured stdout: %s\n", cmdStdOut.String())
+	Logger.Printf("Captured stderr: %s\n", cmdStdErr.String())
 
-		results = append(results, result)
+	// Report the context error rather than the "signal: killed" error
+	// when the call was ended due to the context.
+	if cmdErr != nil && ctx.Err() != nil {
+		cmdErr = fmt.Errorf("%w: %v", ctx.Err(), cmdErr)
+	}
 
+	return TerminateUserSessionResult{
+		UserSession: session,
+		ExitCode:    cmd.ProcessState.ExitCode(),
+		StdOut:      strings.TrimSpace(cmdStdOut.String()),
+		StdErr:      strings.TrimSpace(cmdStdErr.String()),
+		Error:       cmdErr,
+		Command:     commandLine(cmd.Path, session.SessionID),
 	}
 
-	return results
+}
 
+// commandLine returns the command line used to terminate the specified
+// session using the provided path to the executable.
+func commandLine(path string, sessionID string) string {
+	return strings.Join([]string{path, SubCmdNameSessionTerminate, sessionID}, " ")
 }
 
 // Terminate attempts to process each UserSession using the provided
@@ -169,6 +302,19 @@ func (us UserSessions) Terminate(executable string) TerminateUserSessionResults
 	return TerminateUserSession(executable, us...)
 }
 
+// TerminateContext behaves like Terminate, but uses the provided context to
+// end the `kill` subcommand calls if the context is done before they
+// complete.
+func (us UserSessions) TerminateContext(ctx context.Context, executable string) TerminateUserSessionResults {
+	return TerminateUserSessionContext(ctx, executable, us...)
+}
+
+// TerminateWithOptions behaves like TerminateContext, but applies the
+// provided options. See TerminateUserSessionWithOptions for details.
+func (us UserSessions) TerminateWithOptions(ctx context.Context, executable string, options TerminateOptions) TerminateUserSessionResults {
+	return TerminateUserSessionWithOptions(ctx, executable, options, us...)
+}
+
 // HasError returns true if any errors were recorded when terminating user
 // sessions, false otherwise.
 func (tusr TerminateUserSessionResults) HasError() bool {
@@ -0,0 +1,510 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+	"github.com/atc0005/go-ezproxy/internal/textutils"
+)
+
+// These are the names of the rules provided by this package.
+const (
+	RuleNamePDFDownloads       string = "pdf-downloads"
+	RuleNameDistinctArticleURL string = "distinct-article-urls"
+)
+
+// Finding reflects a user session which has triggered a detection rule.
+type Finding struct {
+
+	// Rule is the name of the rule which was triggered.
+	Rule string
+
+	// UserSession is the user session responsible for the traffic which
+	// triggered the rule. This value is suitable for use with the
+	// ezproxy.UserSessions.Terminate method.
+	UserSession ezproxy.UserSession
+
+	// Host is the target host associated with the finding, if the rule is
+	// specific to a target host.
+	Host string
+
+	// Count is the number of matching requests (or distinct URLs) observed
+	// when the rule was triggered.
+	Count int
+
+	// FirstSeen is the timestamp of the earliest matching request within the
+	// rule's window.
+	FirstSeen time.Time
+
+	// LastSeen is the timestamp of the matching request which triggered the
+	// rule.
+	LastSeen time.Time
+
+	// Message is a brief human readable summary of the finding.
+	Message string
+}
+
+// Findings is a collection of Finding values. Intended for aggregation
+// before bulk processing of some kind.
+type Findings []Finding
+
+// Rule is the API for detection rules applied to a stream of traffic log
+// entries. Rules are stateful and expect entries to be observed in the order
+// that they were recorded. Each rule reports a finding at most once for each
+// user session (and target host, if applicable).
+type Rule interface {
+
+	// Name returns the name of the rule.
+	Name() string
+
+	// Observe records the given entry, returning a Finding if the entry
+	// triggered the rule.
+	Observe(entry Entry) (Finding, bool)
+}
+
+// Detector applies a collection of detection rules to a stream of traffic log
+// entries.
+type Detector struct {
+	rules []Rule
+}
+
+// NewDetector creates a new Detector which applies the given rules.
+func NewDetector(rules ...Rule) (*Detector, error) {
+
+	if len(rules) == 0 {
+		return nil, errors.New("func NewDetector: missing rules")
+	}
+
+	for idx := range rules {
+		if rules[idx] == nil {
+			return nil, fmt.Errorf("func NewDetector: rule %d is nil", idx)
+		}
+	}
+
+	return &Detector{
+		rules: rules,
+	}, nil
+}
+
+// Observe applies each rule to the given entry, returning any findings. This
+// method is suitable for use within an EntryFunc in order to detect abuse
+// while streaming a traffic log.
+func (d *Detector) Observe(entry Entry) Findings {
+
+	var findings Findings
+
+	for _, rule := range d.rules {
+		if finding, ok := rule.Observe(entry); ok {
+			ezproxy.Logger.Printf(
+				"Rule %q triggered by session %q for username %q: %s\n",
+				finding.Rule,
+				finding.UserSession.SessionID,
+				finding.UserSession.Username,
+				finding.Message,
+			)
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+// Detect applies each rule to the given entries, returning all findings.
+func (d *Detector) Detect(entries Entries) Findings {
+
+	var findings Findings
+
+	for idx := range entries {
+		findings = append(findings, d.Observe(entries[idx])...)
+	}
+
+	return findings
+}
+
+// Process applies each rule to the entries streamed from the given Reader,
+// returning all findings.
+func (d *Detector) Process(reader Reader) (Findings, error) {
+
+	if reader == nil {
+		return nil, errors.New("func Process: missing reader")
+	}
+
+	var findings Findings
+
+	err := reader.Process(func(entry Entry) error {
+		findings = append(findings, d.Observe(entry)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("func Process: failed to process traffic log entries: %w", err)
+	}
+
+	return findings, nil
+}
+
+// UserSessions returns the distinct user sessions responsible for the
+// findings. The returned collection is suitable for use with the
+// ezproxy.UserSessions.Terminate method.
+func (f Findings) UserSessions() ezproxy.UserSessions {
+
+	userSessions := make(ezproxy.UserSessions, 0, len(f))
+	seen := make(map[string]struct{}, len(f))
+
+	for idx := range f {
+		key := f[idx].UserSession.SessionID + " " + f[idx].UserSession.Username
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		userSessions = append(userSessions, f[idx].UserSession)
+	}
+
+	return userSessions
+}
+
+// PDFDownloadRule is triggered when a user session requests more than
+// Threshold PDF documents within Window. Use NewPDFDownloadRule to validate
+// the settings; the zero value is usable, but is triggered by the first PDF
+// request.
+type PDFDownloadRule struct {
+
+	// Threshold is the number of PDF requests within Window allowed before
+	// the rule is triggered.
+	Threshold int
+
+	// Window is the sliding window within which PDF requests are counted.
+	Window time.Duration
+
+	requests map[string][]time.Time
+	reported map[string]struct{}
+}
+
+// NewPDFDownloadRule creates a new rule which is triggered when a user
+// session requests more than threshold PDF documents within window.
+func NewPDFDownloadRule(threshold int, window time.Duration) (*PDFDownloadRule, error) {
+
+	if threshold < 1 {
+		return nil, fmt.Errorf("func NewPDFDownloadRule: %d is not a valid threshold", threshold)
+	}
+
+	if window <= 0 {
+		return nil, fmt.Errorf("func NewPDFDownloadRule: %v is not a valid window", window)
+	}
+
+	return &PDFDownloadRule{
+		Threshold: threshold,
+		Window:    window,
+		requests:  make(map[string][]time.Time, ezproxy.AllUsersSessionsLimit),
+		reported:  make(map[string]struct{}, ezproxy.AllUsersSessionsLimit),
+	}, nil
+}
+
+// Name returns the name of the rule.
+func (r *PDFDownloadRule) Name() string {
+	return RuleNamePDFDownloads
+}
+
+// Observe records the given entry, returning a Finding if the entry
+// triggered the rule.
+func (r *PDFDownloadRule) Observe(entry Entry) (Finding, bool) {
+
+	if !entry.IsPDF() || !entry.IsSuccess() {
+		return Finding{}, false
+	}
+
+	if r.requests == nil {
+		r.requests = make(map[string][]time.Time, ezproxy.AllUsersSessionsLimit)
+		r.reported = make(map[string]struct{}, ezproxy.AllUsersSessionsLimit)
+	}
+
+	key := sessionKey(entry)
+	if _, ok := r.reported[key]; ok {
+		return Finding{}, false
+	}
+
+	requests := pruneWindow(r.requests[key], entry.Time, r.Window)
+	requests = append(requests, entry.Time)
+	r.requests[key] = requests
+
+	if len(requests) <= r.Threshold {
+		return Finding{}, false
+	}
+
+	r.reported[key] = struct{}{}
+	delete(r.requests, key)
+
+	return Finding{
+		Rule:        r.Name(),
+		UserSession: entry.UserSession(),
+		Count:       len(requests),
+		FirstSeen:   requests[0],
+		LastSeen:    entry.Time,
+		Message: fmt.Sprintf(
+			"%d PDF requests within %v (threshold %d)",
+			len(requests),
+			r.Window,
+			r.Threshold,
+		),
+	}, true
+}
+
+// DistinctArticleURLRule is triggered when a user session requests more than
+// Threshold distinct article URLs from a single target host. If Window is
+// non-zero only requests within that sliding window are counted. Only
+// requests accepted by IsArticle are counted, so that the static assets
+// loaded along with each page (e.g., stylesheets, scripts and images) do not
+// count towards the threshold. Use NewDistinctArticleURLRule to validate the
+// settings; the zero value is usable, but is triggered by the first article
+// URL requested.
+type DistinctArticleURLRule struct {
+
+	// Threshold is the number of distinct article URLs on a single target
+	// host allowed before the rule is triggered.
+	Threshold int
+
+	// Window is the optional sliding window within which distinct article
+	// URLs are counted. If zero, all requests for the session are counted.
+	Window time.Duration
+
+	// IsArticle is an optional predicate which reports whether the entry is
+	// a request for an article. If not set, the Entry.IsArticle method is
+	// used.
+	IsArticle func(Entry) bool
+
+	sessions map[string]*articleRequests
+	reported map[string]struct{}
+}
+
+// articleRequest is a request for an article URL recorded by the
+// DistinctArticleURLRule.
+type articleRequest struct {
+	url  string
+	time time.Time
+}
+
+// articleRequests reflects the article URLs requested by a user session from
+// a single target host. The individual requests are only recorded when a
+// window is used; otherwise only the distinct URLs and the time of the first
+// request are kept.
+type articleRequests struct {
+	requests []articleRequest
+	counts   map[string]int
+	first    time.Time
+}
+
+// NewDistinctArticleURLRule creates a new rule which is triggered when a user
+// session requests more than threshold distinct article URLs from a single
+// target host within window. A zero window counts all requests for the
+// session.
+func NewDistinctArticleURLRule(threshold int, window time.Duration) (*DistinctArticleURLRule, error) {
+
+	if threshold < 1 {
+		return nil, fmt.Errorf("func NewDistinctArticleURLRule: %d is not a valid threshold", threshold)
+	}
+
+	if window < 0 {
+		return nil, fmt.Errorf("func NewDistinctArticleURLRule: %v is not a valid window", window)
+	}
+
+	return &DistinctArticleURLRule{
+		Threshold: threshold,
+		Window:    window,
+		sessions:  make(map[string]*articleRequests, ezproxy.AllUsersSessionsLimit),
+		reported:  make(map[string]struct{}, ezproxy.AllUsersSessionsLimit),
+	}, nil
+}
+
+// Name returns the name of the rule.
+func (r *DistinctArticleURLRule) Name() string {
+	return RuleNameDistinctArticleURL
+}
+
+// Observe records the given entry, returning a Finding if the entry
+// triggered the rule.
+func (r *DistinctArticleURLRule) Observe(entry Entry) (Finding, bool) {
+
+	if !entry.IsSuccess() {
+		return Finding{}, false
+	}
+
+	isArticle := r.IsArticle
+	if isArticle == nil {
+		isArticle = Entry.IsArticle
+	}
+	if !isArticle(entry) {
+		return Finding{}, false
+	}
+
+	host := entry.Host()
+	articleURL := entry.ArticleURL()
+	if host == "" || articleURL == "" {
+		return Finding{}, false
+	}
+
+	if r.sessions == nil {
+		r.sessions = make(map[string]*articleRequests, ezproxy.AllUsersSessionsLimit)
+		r.reported = make(map[string]struct{}, ezproxy.AllUsersSessionsLimit)
+	}
+
+	key := sessionKey(entry) + " " + host
+	if _, ok := r.reported[key]; ok {
+		return Finding{}, false
+	}
+
+	session, ok := r.sessions[key]
+	if !ok {
+		session = &articleRequests{counts: make(map[string]int)}
+		r.sessions[key] = session
+	}
+
+	if r.Window > 0 {
+		cutoff := entry.Time.Add(-r.Window)
+		pruned := 0
+		for _, req := range session.requests {
+			if !req.time.Before(cutoff) {
+				break
+			}
+			session.counts[req.url]--
+			if session.counts[req.url] == 0 {
+				delete(session.counts, req.url)
+			}
+			pruned++
+		}
+		session.requests = append(session.requests[pruned:], articleRequest{url: articleURL, time: entry.Time})
+		session.first = session.requests[0].time
+	} else if len(session.counts) == 0 {
+		session.first = entry.Time
+	}
+	session.counts[articleURL]++
+
+	distinct := len(session.counts)
+	if distinct <= r.Threshold {
+		return Finding{}, false
+	}
+
+	r.reported[key] = struct{}{}
+	delete(r.sessions, key)
+
+	return Finding{
+		Rule:        r.Name(),
+		UserSession: entry.UserSession(),
+		Host:        host,
+		Count:       distinct,
+		FirstSeen:   session.first,
+		LastSeen:    entry.Time,
+		Message: fmt.Sprintf(
+			"%d distinct article URLs requested from %s (threshold %d)",
+			distinct,
+			host,
+			r.Threshold,
+		),
+	}, true
+}
+
+// IsPDF reports whether the entry is a request for a PDF document. The path
+// must either use the .pdf extension or include a path segment named pdf
+// (e.g., /doi/pdf/10.1000/xyz123).
+func (e Entry) IsPDF() bool {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return false
+	}
+	path := strings.ToLower(u.Path)
+	if strings.HasSuffix(path, ".pdf") {
+		return true
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "pdf" {
+			return true
+		}
+	}
+	return false
+}
+
+// staticAssetExtensions is the list of path extensions used by the static
+// assets (e.g., stylesheets, scripts, images and fonts) loaded along with
+// pages on publisher sites.
+var staticAssetExtensions = []string{
+	".css", ".js", ".mjs", ".map", ".json",
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".bmp",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+}
+
+// IsArticle reports whether the entry is likely a request for an article (or
+// other content) rather than for a static asset (e.g., a stylesheet, script,
+// image or font) or the root of the site. This is the default predicate used
+// by the DistinctArticleURLRule.
+func (e Entry) IsArticle() bool {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return false
+	}
+
+	p := strings.ToLower(u.Path)
+	if p == "" || p == "/" {
+		return false
+	}
+
+	return !textutils.InList(path.Ext(p), staticAssetExtensions)
+}
+
+// IsSuccess reports whether the entry recorded a successful (2xx) response.
+func (e Entry) IsSuccess() bool {
+	return e.Status >= 200 && e.Status < 300
+}
+
+// ArticleURL returns the requested URL without any query string or fragment,
+// or an empty string if the URL could not be parsed. Query strings are
+// dropped so that repeated requests for the same article are only counted
+// once.
+func (e Entry) ArticleURL() string {
+	u, err := url.Parse(e.URL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Host) + u.Path
+}
+
+// sessionKey returns the key used to track the user session associated with
+// the entry. The session ID is preferred; the username and client IP Address
+// are used if the session ID was not recorded.
+func sessionKey(entry Entry) string {
+	if entry.SessionID != "" {
+		return entry.SessionID
+	}
+	return strings.ToLower(entry.Username) + "@" + entry.ClientIP
+}
+
+// pruneWindow removes timestamps which fall outside of the window ending at
+// the given timestamp.
+func pruneWindow(times []time.Time, end time.Time, window time.Duration) []time.Time {
+	cutoff := end.Add(-window)
+	kept := times[:0]
+	for _, t := range times {
+		if !t.Before(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,317 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// detectFixture is a synthetic traffic log recorded using the NCSA common log
+// format. Requests are one minute apart.
+const detectFixture = `192.0.2.10 - alice [01/Jan/2021:10:00:00 -0600] "GET http://www.example.com/doi/pdf/10.1000/1 HTTP/1.1" 200 1024
+192.0.2.10 - alice [01/Jan/2021:10:01:00 -0600] "GET http://www.example.com/doi/pdf/10.1000/2 HTTP/1.1" 200 1024
+192.0.2.20 - bob [01/Jan/2021:10:01:30 -0600] "GET http://www.example.com/pdfviewer/help HTTP/1.1" 200 512
+192.0.2.10 - alice [01/Jan/2021:10:02:00 -0600] "GET http://www.example.com/files/3.PDF?download=1 HTTP/1.1" 200 1024
+192.0.2.20 - bob [01/Jan/2021:10:02:30 -0600] "GET http://www.example.com/pdf-help HTTP/1.1" 200 512
+192.0.2.10 - alice [01/Jan/2021:10:03:00 -0600] "GET http://www.example.com/doi/pdf/10.1000/4 HTTP/1.1" 404 0
+192.0.2.10 - alice [01/Jan/2021:10:04:00 -0600] "GET http://www.example.com/doi/pdf/10.1000/5 HTTP/1.1" 200 1024
+192.0.2.10 - alice [01/Jan/2021:10:10:00 -0600] "GET http://www.example.com/doi/pdf/10.1000/6 HTTP/1.1" 200 1024
+192.0.2.20 - bob [01/Jan/2021:10:11:00 -0600] "GET http://journals.example.org/article/1?page=1 HTTP/1.1" 200 2048
+192.0.2.20 - bob [01/Jan/2021:10:12:00 -0600] "GET http://journals.example.org/article/1?page=2 HTTP/1.1" 200 2048
+192.0.2.20 - bob [01/Jan/2021:10:13:00 -0600] "GET http://journals.example.org/article/2 HTTP/1.1" 200 2048
+192.0.2.20 - bob [01/Jan/2021:10:14:00 -0600] "GET http://www.example.com/article/3 HTTP/1.1" 200 2048
+192.0.2.20 - bob [01/Jan/2021:10:15:00 -0600] "GET http://journals.example.org/article/3 HTTP/1.1" 200 2048
+192.0.2.20 - bob [01/Jan/2021:10:16:00 -0600] "GET http://journals.example.org/article/4 HTTP/1.1" 500 0
+192.0.2.20 - bob [01/Jan/2021:10:30:00 -0600] "GET http://journals.example.org/article/5 HTTP/1.1" 200 2048
+`
+
+func readDetectFixture(t *testing.T) Entries {
+	t.Helper()
+
+	entries, err := ReadEntries(strings.NewReader(detectFixture), nil)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	if len(entries) != strings.Count(detectFixture, "\n") {
+		t.Fatalf("read %d entries, want %d", len(entries), strings.Count(detectFixture, "\n"))
+	}
+
+	return entries
+}
+
+func TestEntryIsPDF(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://www.example.com/doi/pdf/10.1000/1", true},
+		{"http://www.example.com/files/3.PDF?download=1", true},
+		{"http://www.example.com/pdf", true},
+		{"http://www.example.com/pdfviewer/help", false},
+		{"http://www.example.com/pdf-help", false},
+		{"http://www.example.com/article/pdfs", false},
+		{"http://www.example.com/article/1", false},
+	}
+
+	for _, tt := range tests {
+		if got := (Entry{URL: tt.url}).IsPDF(); got != tt.want {
+			t.Errorf("IsPDF(%q) = %t, want %t", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPDFDownloadRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      *PDFDownloadRule
+		wantUser  string
+		wantCount int
+		wantFirst string
+		wantLast  string
+	}{
+		{
+			name:      "threshold exceeded within window",
+			rule:      &PDFDownloadRule{Threshold: 3, Window: 5 * time.Minute},
+			wantUser:  "alice",
+			wantCount: 4,
+			wantFirst: "10:00:00",
+			wantLast:  "10:04:00",
+		},
+		{
+			name: "window too short",
+			rule: &PDFDownloadRule{Threshold: 3, Window: 2 * time.Minute},
+		},
+		{
+			name: "threshold not exceeded",
+			rule: &PDFDownloadRule{Threshold: 5, Window: time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := detectAll(t, tt.rule)
+
+			if tt.wantUser == "" {
+				if len(findings) != 0 {
+					t.Fatalf("got %d findings, want none: %+v", len(findings), findings)
+				}
+				return
+			}
+
+			if len(findings) != 1 {
+				t.Fatalf("got %d findings, want 1: %+v", len(findings), findings)
+			}
+			checkFinding(t, findings[0], RuleNamePDFDownloads, tt.wantUser, tt.wantCount, tt.wantFirst, tt.wantLast)
+		})
+	}
+}
+
+func TestDistinctArticleURLRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      *DistinctArticleURLRule
+		wantUser  string
+		wantHost  string
+		wantCount int
+		wantFirst string
+		wantLast  string
+	}{
+		{
+			name:      "no window",
+			rule:      &DistinctArticleURLRule{Threshold: 3},
+			wantUser:  "bob",
+			wantHost:  "journals.example.org",
+			wantCount: 4,
+			wantFirst: "10:11:00",
+			wantLast:  "10:30:00",
+		},
+		{
+			name: "window excludes earlier requests",
+			rule: &DistinctArticleURLRule{Threshold: 3, Window: 10 * time.Minute},
+		},
+		{
+			name:      "window includes all requests",
+			rule:      &DistinctArticleURLRule{Threshold: 2, Window: 10 * time.Minute},
+			wantUser:  "bob",
+			wantHost:  "journals.example.org",
+			wantCount: 3,
+			wantFirst: "10:11:00",
+			wantLast:  "10:15:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := detectAll(t, tt.rule)
+
+			// The PDF requests from alice are requests for distinct URLs
+			// on www.example.com; only findings for bob are of interest.
+			var matching Findings
+			for _, finding := range findings {
+				if finding.UserSession.Username == "bob" {
+					matching = append(matching, finding)
+				}
+			}
+
+			if tt.wantUser == "" {
+				if len(matching) != 0 {
+					t.Fatalf("got %d findings, want none: %+v", len(matching), matching)
+				}
+				return
+			}
+
+			if len(matching) != 1 {
+				t.Fatalf("got %d findings, want 1: %+v", len(matching), matching)
+			}
+			if matching[0].Host != tt.wantHost {
+				t.Errorf("got host %q, want %q", matching[0].Host, tt.wantHost)
+			}
+			checkFinding(t, matching[0], RuleNameDistinctArticleURL, tt.wantUser, tt.wantCount, tt.wantFirst, tt.wantLast)
+		})
+	}
+}
+
+func detectAll(t *testing.T, rule Rule) Findings {
+	t.Helper()
+
+	detector, err := NewDetector(rule)
+	if err != nil {
+		t.Fatalf("failed to create detector: %v", err)
+	}
+
+	return detector.Detect(readDetectFixture(t))
+}
+
+func checkFinding(t *testing.T, finding Finding, rule string, username string, count int, first string, last string) {
+	t.Helper()
+
+	const layout = "15:04:05"
+
+	if finding.Rule != rule {
+		t.Errorf("got rule %q, want %q", finding.Rule, rule)
+	}
+	if finding.UserSession.Username != username {
+		t.Errorf("got username %q, want %q", finding.UserSession.Username, username)
+	}
+	if finding.Count != count {
+		t.Errorf("got count %d, want %d", finding.Count, count)
+	}
+	if got := finding.FirstSeen.Format(layout); got != first {
+		t.Errorf("got first seen %s, want %s", got, first)
+	}
+	if got := finding.LastSeen.Format(layout); got != last {
+		t.Errorf("got last seen %s, want %s", got, last)
+	}
+}
+
+func TestEntryIsArticle(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://journals.example.org/article/1", true},
+		{"http://journals.example.org/doi/10.1000/xyz123", true},
+		{"http://journals.example.org/doi/pdf/10.1000/xyz123", true},
+		{"http://journals.example.org/content/article.html?page=2", true},
+		{"http://journals.example.org/", false},
+		{"http://journals.example.org", false},
+		{"http://journals.example.org/static/site.CSS", false},
+		{"http://journals.example.org/static/app.js?v=3", false},
+		{"http://journals.example.org/images/logo.png", false},
+		{"http://journals.example.org/images/cover.jpeg", false},
+		{"http://journals.example.org/favicon.ico", false},
+		{"http://journals.example.org/fonts/body.woff2", false},
+	}
+
+	for _, tt := range tests {
+		if got := (Entry{URL: tt.url}).IsArticle(); got != tt.want {
+			t.Errorf("IsArticle(%q) = %t, want %t", tt.url, got, tt.want)
+		}
+	}
+}
+
+// pageLoadFixture is a synthetic traffic log for a single page load on a
+// publisher site, including the static assets loaded along with the page.
+const pageLoadFixture = `192.0.2.30 - carol [01/Jan/2021:10:00:00 -0600] "GET http://journals.example.org/article/1 HTTP/1.1" 200 2048
+192.0.2.30 - carol [01/Jan/2021:10:00:01 -0600] "GET http://journals.example.org/static/site.css HTTP/1.1" 200 512
+192.0.2.30 - carol [01/Jan/2021:10:00:01 -0600] "GET http://journals.example.org/static/app.js HTTP/1.1" 200 512
+192.0.2.30 - carol [01/Jan/2021:10:00:01 -0600] "GET http://journals.example.org/static/vendor.js HTTP/1.1" 200 512
+192.0.2.30 - carol [01/Jan/2021:10:00:02 -0600] "GET http://journals.example.org/images/logo.png HTTP/1.1" 200 512
+192.0.2.30 - carol [01/Jan/2021:10:00:02 -0600] "GET http://journals.example.org/images/cover.jpg HTTP/1.1" 200 512
+192.0.2.30 - carol [01/Jan/2021:10:00:02 -0600] "GET http://journals.example.org/fonts/body.woff2 HTTP/1.1" 200 512
+192.0.2.30 - carol [01/Jan/2021:10:00:03 -0600] "GET http://journals.example.org/article/2 HTTP/1.1" 200 2048
+`
+
+func TestDistinctArticleURLRuleIgnoresStaticAssets(t *testing.T) {
+
+	entries, err := ReadEntries(strings.NewReader(pageLoadFixture), nil)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		rule      *DistinctArticleURLRule
+		wantCount int
+	}{
+		{
+			name: "default predicate",
+			rule: &DistinctArticleURLRule{Threshold: 2},
+		},
+		{
+			name:      "all requests counted",
+			rule:      &DistinctArticleURLRule{Threshold: 2, IsArticle: func(Entry) bool { return true }},
+			wantCount: 3,
+		},
+		{
+			name: "custom predicate",
+			rule: &DistinctArticleURLRule{
+				Threshold: 1,
+				IsArticle: func(e Entry) bool { return strings.Contains(e.URL, "/article/") },
+			},
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewDetector(tt.rule)
+			if err != nil {
+				t.Fatalf("failed to create detector: %v", err)
+			}
+
+			findings := detector.Detect(entries)
+
+			if tt.wantCount == 0 {
+				if len(findings) != 0 {
+					t.Fatalf("got %d findings, want none: %+v", len(findings), findings)
+				}
+				return
+			}
+
+			if len(findings) != 1 {
+				t.Fatalf("got %d findings, want 1: %+v", len(findings), findings)
+			}
+			if findings[0].Count != tt.wantCount {
+				t.Errorf("got count %d, want %d", findings[0].Count, tt.wantCount)
+			}
+		})
+	}
+}
@@ -14,7 +14,69 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package trafficlog is intended for the processing of EZproxy traffic log
-// files. As of this writing, this package is a placeholder for potential
-// future work.
+/*
+Package trafficlog is intended for the processing of EZproxy traffic log
+files.
+
+# Overview
+
+EZproxy records each proxied request to a traffic log file. By default these
+entries are recorded using the NCSA common log format, though many sites
+adjust the LogFormat directive to record additional details such as the
+session ID or the Referer and User-Agent headers (the NCSA combined log
+format).
+
+Entries from a traffic log can be used to tie activity for a specific user
+account to the specific resources accessed by that user account (e.g., PDF
+downloads, total bandwidth, etc.).
+
+# Field Types
+
+The NCSA common log format is composed of 7 space-separated fields:
+
+01) Client IP Address (%h)
+02) Remote logname (%l); many sites record the session ID here instead
+03) Username (%u)
+04) Date/Time, enclosed in square brackets (%t)
+05) Request line, enclosed in double quotes (%r)
+06) HTTP status code (%s)
+07) Response size in bytes (%b)
+
+The NCSA combined log format adds 2 additional fields:
+
+08) Referer header, enclosed in double quotes (%{Referer}i)
+09) User-Agent header, enclosed in double quotes (%{User-Agent}i)
+
+Fields without a value are recorded using a single dash (-).
+
+# Custom Log Formats
+
+Sites which set a custom LogFormat directive value within the EZproxy
+config.txt file can provide that value to a Reader via the SetLogFormat
+method (or compile it directly via CompileLogFormat). The directive value is
+compiled into a field extractor which fills the matching Entry fields; values
+for directives without a matching Entry field (e.g., %{ezproxy-groups}i) are
+recorded in the Entry.Extras map instead of causing a parsing failure.
+Directives must be separated by some text (e.g., a space) so that their
+values can be told apart; %t is the exception, as its value is enclosed in
+square brackets.
+
+# Aggregation
+
+Entries can be aggregated into a Report which groups request counts, total
+bytes, distinct target hosts and first/last seen times by username, session
+ID and target host. Session statistics retain the associated user session
+details, so a heavy user's sessions can be passed directly to the
+ezproxy.UserSessions.Terminate method.
+
+# Detection
+
+A Detector applies configurable rules to a stream of entries in order to
+detect bulk-download or content-scraping activity (e.g., more than N PDF
+requests per session within M minutes or more than N distinct article URLs
+on one target host). Requests for static assets such as stylesheets, scripts,
+images and fonts are not counted as article URLs by default. Each Finding
+names the offending user session so that it can be terminated before
+publishers suspend access.
+*/
 package trafficlog
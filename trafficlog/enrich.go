@@ -0,0 +1,62 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"github.com/atc0005/go-ezproxy/geoip"
+)
+
+// EnrichedEntry is an Entry along with the details recorded for the client IP
+// Address of the entry.
+type EnrichedEntry struct {
+	Entry
+	geoip.Details
+
+	// Error is the error (if any) from the attempt to resolve the IP
+	// Address. geoip.ErrNotFound is recorded if the database has no record
+	// for the IP Address.
+	Error error
+}
+
+// EnrichedEntries is a collection of EnrichedEntry values that is intended
+// for aggregation before bulk processing of some kind.
+type EnrichedEntries []EnrichedEntry
+
+// Enrich resolves the details for the client IP Address of the entry using
+// the provided offline database.
+func (e Entry) Enrich(resolver geoip.Resolver) EnrichedEntry {
+	details, err := geoip.ResolveIPAddress(resolver, e.ClientIP)
+	return EnrichedEntry{
+		Entry:   e,
+		Details: details,
+		Error:   err,
+	}
+}
+
+// Enrich resolves the details for the client IP Address of each entry using
+// the provided offline database. Wrapping the database with a geoip.Cache is
+// recommended as the same client IP Address is often recorded many times.
+func (entries Entries) Enrich(resolver geoip.Resolver) EnrichedEntries {
+
+	enriched := make(EnrichedEntries, 0, len(entries))
+
+	for idx := range entries {
+		enriched = append(enriched, entries[idx].Enrich(resolver))
+	}
+
+	return enriched
+}
@@ -0,0 +1,328 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// These are the LogFormat directive values for the NCSA log formats that
+// EZproxy writes by default.
+const (
+
+	// FormatCommon is the LogFormat directive value for the NCSA common log
+	// format. This is the default format used by EZproxy.
+	FormatCommon string = `%h %l %u %t "%r" %s %b`
+
+	// FormatCombined is the LogFormat directive value for the NCSA combined
+	// log format.
+	FormatCombined string = `%h %l %u %t "%r" %s %b "%{Referer}i" "%{User-Agent}i"`
+)
+
+// These are the LogFormat directives with a matching Entry field. Any other
+// directive found in a LogFormat directive value is recorded in the
+// Entry.Extras map using the directive as the key.
+const (
+	directiveClientHost     string = "%h"
+	directiveClientIP       string = "%a"
+	directiveRemoteLogname  string = "%l"
+	directiveUsername       string = "%u"
+	directiveTime           string = "%t"
+	directiveRequestLine    string = "%r"
+	directiveStatus         string = "%s"
+	directiveFinalStatus    string = "%>s"
+	directiveOriginalStatus string = "%<s"
+	directiveBytesCLF       string = "%b"
+	directiveBytes          string = "%B"
+	directiveMethod         string = "%m"
+	directiveURLPath        string = "%U"
+	directiveProtocol       string = "%H"
+	directiveSessionID      string = "%{ezproxy-session}i"
+	directiveReferer        string = "%{referer}i"
+	directiveUserAgent      string = "%{user-agent}i"
+)
+
+// Patterns used to match the value recorded for a directive.
+const (
+	quotedValuePattern  string = `((?:[^"\\]|\\.)*)`
+	bracketValuePattern string = `\[([^\]]*)\]`
+	plainValuePattern   string = `(\S*)`
+)
+
+// directivePattern matches a single LogFormat directive, including any
+// optional modifier and {name} argument.
+var directivePattern = regexp.MustCompile(`%[<>]?(?:\{[^}]*\})?[a-zA-Z]`)
+
+// LogFormat is a compiled EZproxy LogFormat directive value. A LogFormat
+// value is used to extract Entry field values from traffic log lines
+// recorded using that format.
+type LogFormat struct {
+
+	// Format is the LogFormat directive value used to compile this value.
+	Format string
+
+	// directives is the list of directives in the order that they appear in
+	// the format; each has a matching capture group in lineRegex.
+	directives []string
+
+	lineRegex *regexp.Regexp
+}
+
+// CompileLogFormat compiles the given EZproxy LogFormat directive value into
+// a LogFormat that can be used to parse traffic log lines. Directives
+// without a matching Entry field are not treated as an error; their values
+// are recorded in the Entry.Extras map. An error is returned for formats
+// with directives which are not separated by any text (e.g., %h%u), as the
+// values recorded for those directives cannot be told apart. The %t
+// directive is the exception as its value is enclosed in square brackets.
+func CompileLogFormat(format string) (*LogFormat, error) {
+
+	if strings.TrimSpace(format) == "" {
+		return nil, errors.New("func CompileLogFormat: missing format")
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	directives := make([]string, 0, strings.Count(format, "%"))
+
+	var literal strings.Builder
+	remaining := format
+	for len(remaining) > 0 {
+
+		if strings.HasPrefix(remaining, "%%") {
+			literal.WriteString("%")
+			remaining = remaining[2:]
+			continue
+		}
+
+		loc := directivePattern.FindStringIndex(remaining)
+		if loc == nil || loc[0] != 0 {
+			literal.WriteByte(remaining[0])
+			remaining = remaining[1:]
+			continue
+		}
+
+		directive := remaining[:loc[1]]
+		remaining = remaining[loc[1]:]
+
+		if len(directives) > 0 && literal.Len() == 0 {
+			previous := directives[len(directives)-1]
+			if previous != directiveTime && directive != directiveTime {
+				return nil, fmt.Errorf(
+					"func CompileLogFormat: directives %q and %q are not separated in format %q",
+					previous,
+					directive,
+					format,
+				)
+			}
+		}
+
+		quoted := strings.HasSuffix(literal.String(), `"`) &&
+			strings.HasPrefix(remaining, `"`)
+
+		pattern.WriteString(regexp.QuoteMeta(literal.String()))
+		literal.Reset()
+
+		// The %t value is enclosed in square brackets even when the
+		// directive is also enclosed in quotes.
+		switch {
+		case directive == directiveTime:
+			pattern.WriteString(bracketValuePattern)
+		case quoted:
+			pattern.WriteString(quotedValuePattern)
+		default:
+			pattern.WriteString(plainValuePattern)
+		}
+
+		directives = append(directives, directive)
+	}
+
+	pattern.WriteString(regexp.QuoteMeta(literal.String()))
+	pattern.WriteString("$")
+
+	if len(directives) == 0 {
+		return nil, fmt.Errorf(
+			"func CompileLogFormat: no directives found in format %q",
+			format,
+		)
+	}
+
+	lineRegex, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf(
+			"func CompileLogFormat: failed to compile format %q: %w",
+			format,
+			err,
+		)
+	}
+
+	return &LogFormat{
+		Format:     format,
+		directives: directives,
+		lineRegex:  lineRegex,
+	}, nil
+}
+
+// MustCompileLogFormat is like CompileLogFormat but panics if the format
+// cannot be compiled. It simplifies safe initialization of global variables
+// holding compiled formats.
+func MustCompileLogFormat(format string) *LogFormat {
+	lf, err := CompileLogFormat(format)
+	if err != nil {
+		panic(err)
+	}
+	return lf
+}
+
+// Directives returns the LogFormat directives found in the format in the
+// order that they appear.
+func (lf *LogFormat) Directives() []string {
+	directives := make([]string, len(lf.directives))
+	copy(directives, lf.directives)
+	return directives
+}
+
+// Parse parses a single traffic log line recorded using this LogFormat.
+func (lf *LogFormat) Parse(line string) (Entry, error) {
+
+	values := lf.lineRegex.FindStringSubmatch(line)
+	if values == nil {
+		return Entry{}, fmt.Errorf("line does not match log format %q", lf.Format)
+	}
+
+	var entry Entry
+
+	// Only set from %U if a full request line (%r) is not also recorded.
+	var urlPath string
+
+	for idx, directive := range lf.directives {
+		value := fieldValue(unescape(values[idx+1]))
+
+		var err error
+
+		switch canonicalDirective(directive) {
+		case directiveClientHost, directiveClientIP:
+			entry.ClientIP = value
+
+		case directiveRemoteLogname:
+			switch sessionID := sessionIDValue(value); {
+			case sessionID != "":
+				entry.SessionID = sessionID
+			case value != "":
+				entry.setExtra(directive, value)
+			}
+
+		case directiveUsername:
+			entry.Username = value
+
+		case directiveTime:
+			entry.Time, err = time.Parse(TimeStampLayout, value)
+			if err != nil {
+				return Entry{}, fmt.Errorf("failed to parse timestamp %q: %w", value, err)
+			}
+
+		case directiveRequestLine:
+			entry.Method, entry.URL, entry.Protocol = splitRequestLine(value)
+
+		case directiveStatus, directiveFinalStatus, directiveOriginalStatus:
+			if entry.Status, err = parseStatus(value); err != nil {
+				return Entry{}, err
+			}
+
+		case directiveBytesCLF, directiveBytes:
+			if entry.Bytes, err = parseBytes(value); err != nil {
+				return Entry{}, err
+			}
+
+		case directiveMethod:
+			entry.Method = value
+
+		case directiveURLPath:
+			urlPath = value
+
+		case directiveProtocol:
+			entry.Protocol = value
+
+		case directiveSessionID:
+			entry.SessionID = value
+
+		case directiveReferer:
+			entry.Referer = value
+
+		case directiveUserAgent:
+			entry.UserAgent = value
+
+		default:
+			entry.setExtra(directive, value)
+		}
+	}
+
+	if entry.URL == "" {
+		entry.URL = urlPath
+	}
+
+	return entry, nil
+}
+
+// canonicalDirective returns the given directive with any {name} argument
+// converted to lowercase; header names are not case-sensitive.
+func canonicalDirective(directive string) string {
+	start := strings.Index(directive, "{")
+	end := strings.LastIndex(directive, "}")
+	if start < 0 || end < start {
+		return directive
+	}
+	return directive[:start] + strings.ToLower(directive[start:end]) + directive[end:]
+}
+
+// setExtra records the value for a directive without a matching Entry field.
+func (e *Entry) setExtra(directive string, value string) {
+	if e.Extras == nil {
+		e.Extras = make(map[string]string)
+	}
+	e.Extras[directive] = value
+}
+
+// These are the compiled versions of the NCSA log formats that EZproxy
+// writes by default. The combined format is attempted first as it is a
+// superset of the common format.
+var (
+	combinedLogFormat = MustCompileLogFormat(FormatCombined)
+	commonLogFormat   = MustCompileLogFormat(FormatCommon)
+)
+
+// ParseLine parses a single traffic log line recorded using either the NCSA
+// combined or common log format. See LogFormat.Parse for parsing lines
+// recorded using a custom LogFormat directive value.
+func ParseLine(line string) (Entry, error) {
+
+	if entry, err := combinedLogFormat.Parse(line); err == nil {
+		return entry, nil
+	}
+
+	entry, err := commonLogFormat.Parse(line)
+	if err != nil {
+		return Entry{}, errors.New("line does not match a supported log format")
+	}
+
+	return entry, nil
+}
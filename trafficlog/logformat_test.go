@@ -0,0 +1,238 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompileLogFormat(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format string
+		want   []string
+	}{
+		{
+			name:   "common",
+			format: FormatCommon,
+			want:   []string{"%h", "%l", "%u", "%t", "%r", "%s", "%b"},
+		},
+		{
+			name:   "combined",
+			format: FormatCombined,
+			want:   []string{"%h", "%l", "%u", "%t", "%r", "%s", "%b", "%{Referer}i", "%{User-Agent}i"},
+		},
+		{
+			name:   "custom",
+			format: `%a %{ezproxy-session}i %u %t "%m %U %H" %>s %B "%{ezproxy-groups}i"`,
+			want:   []string{"%a", "%{ezproxy-session}i", "%u", "%t", "%m", "%U", "%H", "%>s", "%B", "%{ezproxy-groups}i"},
+		},
+		{
+			name:   "literal percent",
+			format: `%h 100%% %u`,
+			want:   []string{"%h", "%u"},
+		},
+		{
+			name:   "time adjacent to directives",
+			format: `%h %t%s%t`,
+			want:   []string{"%h", "%t", "%s", "%t"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf, err := CompileLogFormat(tt.format)
+			if err != nil {
+				t.Fatalf("CompileLogFormat() error = %v", err)
+			}
+
+			if got := lf.Directives(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Directives() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileLogFormatInvalid(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{"empty", ""},
+		{"whitespace", "  \t "},
+		{"no directives", "100%% static text"},
+		{"adjacent directives", "%h%u %t"},
+		{"adjacent directives within quotes", `%h "%m%U" %s`},
+		{"adjacent header directives", `%{ezproxy-session}i%{ezproxy-groups}i`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompileLogFormat(tt.format); err == nil {
+				t.Errorf("CompileLogFormat(%q) error = nil, want error", tt.format)
+			}
+		})
+	}
+}
+
+func TestLogFormatParse(t *testing.T) {
+
+	const timestamp = "01/Jan/2021:10:00:00 -0600"
+
+	tests := []struct {
+		name   string
+		format string
+		line   string
+		want   Entry
+	}{
+		{
+			name:   "common",
+			format: FormatCommon,
+			line:   `192.0.2.10 - alice [01/Jan/2021:10:00:00 -0600] "GET http://www.example.com/a HTTP/1.1" 200 1024`,
+			want: Entry{
+				ClientIP: "192.0.2.10",
+				Username: "alice",
+				Method:   "GET",
+				URL:      "http://www.example.com/a",
+				Protocol: "HTTP/1.1",
+				Status:   200,
+				Bytes:    1024,
+			},
+		},
+		{
+			name:   "common with session ID",
+			format: FormatCommon,
+			line:   `192.0.2.10 aBcDeFgHiJkLmNo - [01/Jan/2021:10:00:00 -0600] "GET http://www.example.com/a HTTP/1.1" 304 -`,
+			want: Entry{
+				ClientIP:  "192.0.2.10",
+				SessionID: "aBcDeFgHiJkLmNo",
+				Method:    "GET",
+				URL:       "http://www.example.com/a",
+				Protocol:  "HTTP/1.1",
+				Status:    304,
+			},
+		},
+		{
+			name:   "combined with escaped quotes",
+			format: FormatCombined,
+			line:   `192.0.2.10 - alice [01/Jan/2021:10:00:00 -0600] "GET http://www.example.com/a HTTP/1.1" 200 1024 "http://www.example.com/" "Agent \"1.0\""`,
+			want: Entry{
+				ClientIP:  "192.0.2.10",
+				Username:  "alice",
+				Method:    "GET",
+				URL:       "http://www.example.com/a",
+				Protocol:  "HTTP/1.1",
+				Status:    200,
+				Bytes:     1024,
+				Referer:   "http://www.example.com/",
+				UserAgent: `Agent "1.0"`,
+			},
+		},
+		{
+			name:   "custom",
+			format: `%a %{ezproxy-session}i %u %t "%m %U %H" %>s %B "%{ezproxy-groups}i"`,
+			line:   `192.0.2.10 aBcDeFgHiJkLmNo alice [01/Jan/2021:10:00:00 -0600] "GET /a HTTP/1.1" 200 0 "Default+Staff"`,
+			want: Entry{
+				ClientIP:  "192.0.2.10",
+				SessionID: "aBcDeFgHiJkLmNo",
+				Username:  "alice",
+				Method:    "GET",
+				URL:       "/a",
+				Protocol:  "HTTP/1.1",
+				Status:    200,
+				Extras:    map[string]string{"%{ezproxy-groups}i": "Default+Staff"},
+			},
+		},
+		{
+			name:   "quoted time",
+			format: `%h "%t" "%r" %s`,
+			line:   `192.0.2.10 "[01/Jan/2021:10:00:00 -0600]" "GET /a HTTP/1.1" 200`,
+			want: Entry{
+				ClientIP: "192.0.2.10",
+				Method:   "GET",
+				URL:      "/a",
+				Protocol: "HTTP/1.1",
+				Status:   200,
+			},
+		},
+		{
+			name:   "time adjacent to directive",
+			format: `%h %t%s`,
+			line:   `192.0.2.10 [01/Jan/2021:10:00:00 -0600]200`,
+			want: Entry{
+				ClientIP: "192.0.2.10",
+				Status:   200,
+			},
+		},
+	}
+
+	wantTime, err := time.Parse(TimeStampLayout, timestamp)
+	if err != nil {
+		t.Fatalf("failed to parse timestamp: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf, err := CompileLogFormat(tt.format)
+			if err != nil {
+				t.Fatalf("CompileLogFormat() error = %v", err)
+			}
+
+			got, err := lf.Parse(tt.line)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if !got.Time.Equal(wantTime) {
+				t.Errorf("Time = %v, want %v", got.Time, wantTime)
+			}
+			got.Time = time.Time{}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogFormatParseInvalid(t *testing.T) {
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"empty", ""},
+		{"missing fields", `192.0.2.10 - alice [01/Jan/2021:10:00:00 -0600]`},
+		{"invalid timestamp", `192.0.2.10 - alice [2021-01-01 10:00:00] "GET /a HTTP/1.1" 200 1024`},
+		{"invalid status", `192.0.2.10 - alice [01/Jan/2021:10:00:00 -0600] "GET /a HTTP/1.1" OK 1024`},
+		{"invalid bytes", `192.0.2.10 - alice [01/Jan/2021:10:00:00 -0600] "GET /a HTTP/1.1" 200 many`},
+	}
+
+	lf := MustCompileLogFormat(FormatCommon)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if entry, err := lf.Parse(tt.line); err == nil {
+				t.Errorf("Parse() = %+v, want error", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+// Stats reflects aggregated traffic log statistics for a specific key (e.g.,
+// a username, session ID or target host).
+type Stats struct {
+
+	// Key is the username, session ID or target host that these statistics
+	// were aggregated for.
+	Key string
+
+	// Requests is the total number of requests recorded.
+	Requests int
+
+	// Bytes is the total size of all responses recorded.
+	Bytes int64
+
+	// HostRequests is the number of requests recorded for each distinct
+	// target host.
+	HostRequests map[string]int
+
+	// FirstSeen is the timestamp of the earliest request recorded.
+	FirstSeen time.Time
+
+	// LastSeen is the timestamp of the latest request recorded.
+	LastSeen time.Time
+}
+
+// SessionStats reflects aggregated traffic log statistics for a specific
+// session ID along with the associated user session details.
+type SessionStats struct {
+	Stats
+
+	// UserSession is the user session associated with the aggregated
+	// entries. The IP Address is the most recent one recorded for the
+	// session.
+	UserSession ezproxy.UserSession
+}
+
+// Report is a collection of traffic log statistics grouped by username,
+// session ID and target host. Entries without a value for one of these keys
+// are not included in the statistics for that grouping.
+type Report struct {
+
+	// Users is the collection of statistics grouped by username. The
+	// username is normalized to lowercase.
+	Users map[string]*Stats
+
+	// Sessions is the collection of statistics grouped by session ID.
+	Sessions map[string]*SessionStats
+
+	// Hosts is the collection of statistics grouped by target host.
+	Hosts map[string]*Stats
+}
+
+// NewReport creates a new, empty Report intended to have entries added via
+// the Add method.
+func NewReport() *Report {
+	return &Report{
+		Users:    make(map[string]*Stats, ezproxy.AllUsersSessionsLimit),
+		Sessions: make(map[string]*SessionStats, ezproxy.AllUsersSessionsLimit),
+		Hosts:    make(map[string]*Stats, ezproxy.AllUsersSessionsLimit),
+	}
+}
+
+// Aggregate generates a Report from the given traffic log entries.
+func Aggregate(entries Entries) *Report {
+	report := NewReport()
+	for idx := range entries {
+		report.Add(entries[idx])
+	}
+	return report
+}
+
+// Add records the given traffic log entry in the Report. This method is
+// suitable for use as (or within) an EntryFunc in order to aggregate entries
+// while streaming a traffic log.
+func (r *Report) Add(entry Entry) {
+
+	host := entry.Host()
+
+	if entry.Username != "" {
+		key := strings.ToLower(entry.Username)
+		stats, ok := r.Users[key]
+		if !ok {
+			stats = newStats(key)
+			r.Users[key] = stats
+		}
+		stats.add(entry, host)
+	}
+
+	if entry.SessionID != "" {
+		stats, ok := r.Sessions[entry.SessionID]
+		if !ok {
+			stats = &SessionStats{
+				Stats: *newStats(entry.SessionID),
+				UserSession: ezproxy.UserSession{
+					SessionID: entry.SessionID,
+				},
+			}
+			r.Sessions[entry.SessionID] = stats
+		}
+		stats.add(entry, host)
+
+		if entry.Username != "" {
+			stats.UserSession.Username = entry.Username
+		}
+		if entry.ClientIP != "" {
+			stats.UserSession.IPAddress = entry.ClientIP
+		}
+	}
+
+	if host != "" {
+		stats, ok := r.Hosts[host]
+		if !ok {
+			stats = newStats(host)
+			r.Hosts[host] = stats
+		}
+		stats.add(entry, host)
+	}
+}
+
+// newStats creates a new, empty Stats value for the given key.
+func newStats(key string) *Stats {
+	return &Stats{
+		Key:          key,
+		HostRequests: make(map[string]int),
+	}
+}
+
+// add records the given entry in the statistics.
+func (s *Stats) add(entry Entry, host string) {
+
+	s.Requests++
+	s.Bytes += entry.Bytes
+
+	if host != "" {
+		s.HostRequests[host]++
+	}
+
+	if entry.Time.IsZero() {
+		return
+	}
+
+	if s.FirstSeen.IsZero() || entry.Time.Before(s.FirstSeen) {
+		s.FirstSeen = entry.Time
+	}
+
+	if entry.Time.After(s.LastSeen) {
+		s.LastSeen = entry.Time
+	}
+}
+
+// DistinctHosts returns the number of distinct target hosts recorded.
+func (s Stats) DistinctHosts() int {
+	return len(s.HostRequests)
+}
+
+// Duration returns the amount of time between the earliest and latest
+// requests recorded.
+func (s Stats) Duration() time.Duration {
+	return s.LastSeen.Sub(s.FirstSeen)
+}
+
+// TopUsers returns the statistics for all usernames, ordered by total bytes
+// and then by total requests (highest first).
+func (r *Report) TopUsers() []*Stats {
+	stats := make([]*Stats, 0, len(r.Users))
+	for _, s := range r.Users {
+		stats = append(stats, s)
+	}
+	sortStats(stats)
+	return stats
+}
+
+// TopHosts returns the statistics for all target hosts, ordered by total
+// bytes and then by total requests (highest first).
+func (r *Report) TopHosts() []*Stats {
+	stats := make([]*Stats, 0, len(r.Hosts))
+	for _, s := range r.Hosts {
+		stats = append(stats, s)
+	}
+	sortStats(stats)
+	return stats
+}
+
+// TopSessions returns the statistics for all session IDs, ordered by total
+// bytes and then by total requests (highest first).
+func (r *Report) TopSessions() []*SessionStats {
+	stats := make([]*SessionStats, 0, len(r.Sessions))
+	for _, s := range r.Sessions {
+		stats = append(stats, s)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return statsLess(&stats[i].Stats, &stats[j].Stats)
+	})
+	return stats
+}
+
+// UserSessions returns the user sessions recorded for the specified username.
+// The returned collection is suitable for use with the
+// ezproxy.UserSessions.Terminate method.
+func (r *Report) UserSessions(username string) ezproxy.UserSessions {
+
+	userSessions := make(ezproxy.UserSessions, 0, ezproxy.SessionsLimit)
+
+	for _, s := range r.TopSessions() {
+		if strings.EqualFold(s.UserSession.Username, username) {
+			userSessions = append(userSessions, s.UserSession)
+		}
+	}
+
+	return userSessions
+}
+
+// sortStats orders the given statistics by total bytes and then by total
+// requests (highest first), using the key to provide a stable ordering.
+func sortStats(stats []*Stats) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		return statsLess(stats[i], stats[j])
+	})
+}
+
+// statsLess reports whether statistics a should be ordered before b.
+func statsLess(a *Stats, b *Stats) bool {
+	switch {
+	case a.Bytes != b.Bytes:
+		return a.Bytes > b.Bytes
+	case a.Requests != b.Requests:
+		return a.Requests > b.Requests
+	default:
+		return a.Key < b.Key
+	}
+}
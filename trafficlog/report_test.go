@@ -0,0 +1,179 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+)
+
+const reportFixture = `192.0.2.10 aBcDeFgHiJkLmNo alice [01/Jan/2021:10:00:00 -0600] "GET http://www.example.com/a HTTP/1.1" 200 1000
+192.0.2.20 bBcDeFgHiJkLmNo bob [01/Jan/2021:09:59:00 -0600] "GET http://WWW.EXAMPLE.COM/c HTTP/1.1" 200 2000
+192.0.2.30 - - [01/Jan/2021:10:02:00 -0600] "GET http://www.example.com/d HTTP/1.1" 304 -
+192.0.2.11 aBcDeFgHiJkLmNo Alice [01/Jan/2021:10:05:00 -0600] "GET http://journals.example.org/b HTTP/1.1" 200 500
+192.0.2.40 - carol [01/Jan/2021:10:03:00 -0600] "GET /relative HTTP/1.1" 200 100
+`
+
+// testStats summarizes a Stats value for comparison.
+type testStats struct {
+	Key          string
+	Requests     int
+	Bytes        int64
+	HostRequests map[string]int
+	FirstSeen    string
+	LastSeen     string
+}
+
+// summarizeStats summarizes the given statistics for comparison.
+func summarizeStats(stats []*Stats) []testStats {
+	summary := make([]testStats, 0, len(stats))
+	for _, s := range stats {
+		summary = append(summary, testStats{
+			Key:          s.Key,
+			Requests:     s.Requests,
+			Bytes:        s.Bytes,
+			HostRequests: s.HostRequests,
+			FirstSeen:    s.FirstSeen.Format("15:04"),
+			LastSeen:     s.LastSeen.Format("15:04"),
+		})
+	}
+	return summary
+}
+
+func readReportFixture(t *testing.T) *Report {
+	t.Helper()
+
+	entries, err := ReadEntries(strings.NewReader(reportFixture), nil)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	if len(entries) != strings.Count(reportFixture, "\n") {
+		t.Fatalf("read %d entries, want %d", len(entries), strings.Count(reportFixture, "\n"))
+	}
+
+	return Aggregate(entries)
+}
+
+func TestReportUsers(t *testing.T) {
+
+	report := readReportFixture(t)
+
+	want := []testStats{
+		{"bob", 1, 2000, map[string]int{"www.example.com": 1}, "09:59", "09:59"},
+		{"alice", 2, 1500, map[string]int{"www.example.com": 1, "journals.example.org": 1}, "10:00", "10:05"},
+		{"carol", 1, 100, map[string]int{}, "10:03", "10:03"},
+	}
+
+	if got := summarizeStats(report.TopUsers()); !reflect.DeepEqual(got, want) {
+		t.Errorf("TopUsers() = %+v, want %+v", got, want)
+	}
+
+	if got := report.Users["alice"].DistinctHosts(); got != 2 {
+		t.Errorf("DistinctHosts() = %d, want 2", got)
+	}
+
+	if got := report.Users["alice"].Duration(); got != 5*time.Minute {
+		t.Errorf("Duration() = %v, want 5m", got)
+	}
+}
+
+func TestReportSessions(t *testing.T) {
+
+	report := readReportFixture(t)
+
+	sessions := report.TopSessions()
+
+	stats := make([]*Stats, 0, len(sessions))
+	userSessions := make(ezproxy.UserSessions, 0, len(sessions))
+	for _, s := range sessions {
+		stats = append(stats, &s.Stats)
+		userSessions = append(userSessions, s.UserSession)
+	}
+
+	wantStats := []testStats{
+		{"bBcDeFgHiJkLmNo", 1, 2000, map[string]int{"www.example.com": 1}, "09:59", "09:59"},
+		{"aBcDeFgHiJkLmNo", 2, 1500, map[string]int{"www.example.com": 1, "journals.example.org": 1}, "10:00", "10:05"},
+	}
+
+	if got := summarizeStats(stats); !reflect.DeepEqual(got, wantStats) {
+		t.Errorf("TopSessions() = %+v, want %+v", got, wantStats)
+	}
+
+	// The most recent IP Address and username are recorded.
+	wantUserSessions := ezproxy.UserSessions{
+		{SessionID: "bBcDeFgHiJkLmNo", IPAddress: "192.0.2.20", Username: "bob"},
+		{SessionID: "aBcDeFgHiJkLmNo", IPAddress: "192.0.2.11", Username: "Alice"},
+	}
+
+	if !reflect.DeepEqual(userSessions, wantUserSessions) {
+		t.Errorf("TopSessions() user sessions = %+v, want %+v", userSessions, wantUserSessions)
+	}
+
+	got := report.UserSessions("ALICE")
+	if len(got) != 1 || got[0].SessionID != "aBcDeFgHiJkLmNo" {
+		t.Errorf("UserSessions() = %+v, want session aBcDeFgHiJkLmNo", got)
+	}
+
+	if got := report.UserSessions("carol"); len(got) != 0 {
+		t.Errorf("UserSessions() = %+v, want no sessions", got)
+	}
+}
+
+func TestReportHosts(t *testing.T) {
+
+	report := readReportFixture(t)
+
+	want := []testStats{
+		{"www.example.com", 3, 3000, map[string]int{"www.example.com": 3}, "09:59", "10:02"},
+		{"journals.example.org", 1, 500, map[string]int{"journals.example.org": 1}, "10:05", "10:05"},
+	}
+
+	if got := summarizeStats(report.TopHosts()); !reflect.DeepEqual(got, want) {
+		t.Errorf("TopHosts() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReportOrdering(t *testing.T) {
+
+	report := NewReport()
+
+	// Entries without a timestamp are counted without affecting the first
+	// and last seen times.
+	report.Add(Entry{Username: "dave", Bytes: 100})
+	report.Add(Entry{Username: "erin", Bytes: 50})
+	report.Add(Entry{Username: "erin", Bytes: 50})
+	report.Add(Entry{Username: "carol", Bytes: 100})
+
+	want := []testStats{
+		{"erin", 2, 100, map[string]int{}, "00:00", "00:00"},
+		{"carol", 1, 100, map[string]int{}, "00:00", "00:00"},
+		{"dave", 1, 100, map[string]int{}, "00:00", "00:00"},
+	}
+
+	if got := summarizeStats(report.TopUsers()); !reflect.DeepEqual(got, want) {
+		t.Errorf("TopUsers() = %+v, want %+v", got, want)
+	}
+
+	if len(report.Sessions) != 0 || len(report.Hosts) != 0 {
+		t.Errorf("got %d sessions and %d hosts, want none", len(report.Sessions), len(report.Hosts))
+	}
+}
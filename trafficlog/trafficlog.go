@@ -0,0 +1,436 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atc0005/go-ezproxy"
+	"github.com/atc0005/go-ezproxy/internal/decompress"
+)
+
+// TimeStampLayout is the layout for timestamps recorded by the %t LogFormat
+// directive. For example, "24/May/2020:00:17:37 -0500" is a timestamp found
+// (within square brackets) in the fourth field of a live traffic log.
+const TimeStampLayout string = "02/Jan/2006:15:04:05 -0700"
+
+// EmptyFieldValue is the placeholder value recorded by EZproxy for fields
+// that have no value to record.
+const EmptyFieldValue string = "-"
+
+// MaxLineLength is the maximum length of a traffic log line that we are
+// willing to process. Request lines recorded by EZproxy include the full
+// proxied URL, so lines are frequently longer than the default bufio.Scanner
+// token size.
+const MaxLineLength int = 1024 * 1024
+
+// sessionIDRegex matches values which look like an EZproxy session ID.
+var sessionIDRegex = regexp.MustCompile("^" + ezproxy.SessionIDRegex + "$")
+
+// Entry reflects a line in an EZproxy traffic log file. Not all LogFormat
+// configurations record all fields; fields which were not recorded (or which
+// were recorded using the "-" placeholder) are left at their zero value.
+type Entry struct {
+
+	// Time is the timestamp recorded for the request.
+	Time time.Time
+
+	// ClientIP is the IP Address of the client making the request.
+	ClientIP string
+
+	// Username is the username associated with the request.
+	Username string
+
+	// SessionID is the EZproxy session ID associated with the request.
+	SessionID string
+
+	// Method is the HTTP method (e.g., GET, POST) of the request.
+	Method string
+
+	// URL is the requested URL. EZproxy records the full URL of the proxied
+	// resource.
+	URL string
+
+	// Protocol is the HTTP protocol version (e.g., HTTP/1.1) of the request.
+	Protocol string
+
+	// Status is the HTTP status code returned to the client.
+	Status int
+
+	// Bytes is the size of the response returned to the client, excluding
+	// headers.
+	Bytes int64
+
+	// Referer is the HTTP Referer header value provided by the client.
+	Referer string
+
+	// UserAgent is the HTTP User-Agent header value provided by the client.
+	UserAgent string
+
+	// Extras holds the values recorded for LogFormat directives without a
+	// matching Entry field, keyed by directive (e.g.,
+	// "%{ezproxy-groups}i"). This map is nil if there were no such values.
+	Extras map[string]string
+
+	// LineNumber is the line number of the entry within the traffic log.
+	LineNumber int
+}
+
+// Entries is a collection of Entry values that is intended for aggregation
+// before bulk processing of some kind.
+type Entries []Entry
+
+// EntryFunc is called for each entry read from a traffic log. Returning a
+// non-nil error stops further processing of the traffic log; that error is
+// returned to the caller.
+type EntryFunc func(Entry) error
+
+// Reader is the API for retrieving entries from a traffic log file.
+type Reader interface {
+
+	// Process streams each parsed entry in the previously provided traffic
+	// log to the given function in the order that entries were recorded.
+	Process(fn EntryFunc) error
+
+	// AllEntries uses the previously provided filename to return a slice of
+	// Entry values which reflect ALL parsed entries in the traffic log.
+	AllEntries() (Entries, error)
+
+	// SetLogFormat is a helper method for setting the EZproxy LogFormat
+	// directive value used to record entries in the traffic log.
+	SetLogFormat(format string) error
+}
+
+// trafficLogReader represents a file reader specific to EZProxy traffic
+// logs.
+type trafficLogReader struct {
+
+	// Filename is the name of the file which will be parsed.
+	Filename string
+
+	// LogFormat is the compiled LogFormat directive value used to parse
+	// entries in the specified file. If not set, entries are parsed using
+	// the NCSA combined or common log formats.
+	LogFormat *LogFormat
+}
+
+// NewReader creates a new instance of a Reader that provides access to the
+// entries in the specified traffic log file.
+func NewReader(filename string) (Reader, error) {
+
+	if filename == "" {
+		return nil, errors.New(
+			"func NewReader: missing filename",
+		)
+	}
+
+	reader := trafficLogReader{
+		Filename: filename,
+	}
+
+	return &reader, nil
+}
+
+// Process streams each parsed entry in the previously provided traffic log to
+// the given function in the order that entries were recorded. Lines which
+// cannot be parsed are skipped.
+func (tlr trafficLogReader) Process(fn EntryFunc) error {
+
+	if fn == nil {
+		return errors.New("func Process: missing entry func")
+	}
+
+	ezproxy.Logger.Printf(
+		"Process: Request to open %q received\n",
+		tlr.Filename,
+	)
+	ezproxy.Logger.Printf(
+		"Process: Attempting to open sanitized version of file %q\n",
+		filepath.Clean(tlr.Filename),
+	)
+
+	f, err := os.Open(filepath.Clean(tlr.Filename))
+	if err != nil {
+		return fmt.Errorf("func Process: error encountered opening file %q: %w", tlr.Filename, err)
+	}
+
+	// #nosec G307
+	// Believed to be a false-positive from recent gosec release
+	// https://github.com/securego/gosec/issues/714
+	defer func() {
+		if err := f.Close(); err != nil {
+			// Ignore "file already closed" errors
+			if !errors.Is(err, os.ErrClosed) {
+				ezproxy.Logger.Printf(
+					"Process: failed to close file %q: %s",
+					tlr.Filename,
+					err.Error(),
+				)
+			}
+		}
+	}()
+
+	if err := tlr.processReader(f, fn); err != nil {
+		return err
+	}
+
+	// explicitly close file, bail if failure occurs
+	if err := f.Close(); err != nil {
+		return fmt.Errorf(
+			"func Process: failed to close file %q: %w",
+			tlr.Filename,
+			err,
+		)
+	}
+
+	return nil
+}
+
+// processReader calls fn for each entry parsed from the provided traffic log
+// content. Compressed content is decompressed as it is read. Processing stops
+// at the first error returned by fn, which is returned as-is.
+func (tlr trafficLogReader) processReader(input io.Reader, fn EntryFunc) error {
+
+	r, err := decompress.NewReader(input)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", tlr.Filename, err)
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLineLength)
+
+	var lineno int
+
+	for s.Scan() {
+		lineno++
+
+		currentLine := strings.TrimSpace(s.Text())
+		if currentLine == "" {
+			continue
+		}
+
+		entry, parseErr := tlr.parseLine(currentLine)
+		if parseErr != nil {
+			ezproxy.Logger.Printf(
+				"Skipping line %d from %q: %v\n",
+				lineno,
+				tlr.Filename,
+				parseErr,
+			)
+			continue
+		}
+		entry.LineNumber = lineno
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	ezproxy.Logger.Println("Exited s.Scan() loop")
+
+	// report any errors encountered while scanning the input
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("errors encountered while scanning the input: %w", err)
+	}
+
+	return nil
+}
+
+// ReadEntries returns the entries parsed from the traffic log provided by the
+// given io.Reader. Compressed content is decompressed as it is read. The
+// provided LogFormat is used to parse each line; if nil, the NCSA combined
+// and common log formats are tried. Lines which cannot be parsed are skipped.
+func ReadEntries(r io.Reader, logFormat *LogFormat) (Entries, error) {
+
+	if r == nil {
+		return nil, errors.New("func ReadEntries: missing reader")
+	}
+
+	tlr := trafficLogReader{
+		Filename:  "input",
+		LogFormat: logFormat,
+	}
+
+	entries := make(Entries, 0, ezproxy.AllUsersSessionsLimit)
+
+	err := tlr.processReader(r, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("func ReadEntries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// SetLogFormat is a helper method for setting the EZproxy LogFormat
+// directive value used to record entries in the traffic log.
+func (tlr *trafficLogReader) SetLogFormat(format string) error {
+	lf, err := CompileLogFormat(format)
+	if err != nil {
+		return fmt.Errorf("func SetLogFormat: %w", err)
+	}
+
+	tlr.LogFormat = lf
+
+	return nil
+}
+
+// parseLine parses a traffic log line using the configured LogFormat, or the
+// NCSA combined or common log formats if a LogFormat was not configured.
+func (tlr trafficLogReader) parseLine(line string) (Entry, error) {
+	if tlr.LogFormat == nil {
+		return ParseLine(line)
+	}
+	return tlr.LogFormat.Parse(line)
+}
+
+// AllEntries uses the previously provided filename to return a slice of
+// Entry values which reflect ALL parsed entries in the traffic log.
+func (tlr trafficLogReader) AllEntries() (Entries, error) {
+
+	entries := make(Entries, 0, ezproxy.AllUsersSessionsLimit)
+
+	err := tlr.Process(func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(
+			"func AllEntries: failed to retrieve traffic log entries: %w",
+			err,
+		)
+	}
+
+	ezproxy.Logger.Printf("Found %d traffic log entries\n", len(entries))
+
+	return entries, nil
+}
+
+// fieldValue returns the given field value, or an empty string if the
+// EZproxy placeholder value for an empty field was recorded.
+func fieldValue(s string) string {
+	if s == EmptyFieldValue {
+		return ""
+	}
+	return s
+}
+
+// sessionIDValue returns the given value if it looks like an EZproxy session
+// ID, otherwise an empty string. The NCSA identd field (%l) is unused by
+// EZproxy, so many sites record %{ezproxy-session}i in that position instead.
+func sessionIDValue(s string) string {
+	if sessionIDRegex.MatchString(s) {
+		return s
+	}
+	return ""
+}
+
+// unescape removes the backslash escaping applied by EZproxy to quoted
+// field values.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+// splitRequestLine splits a recorded request line (%r) into the method, URL
+// and protocol values.
+func splitRequestLine(s string) (method string, target string, protocol string) {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 0:
+		return "", "", ""
+	case 1:
+		return "", fieldValue(fields[0]), ""
+	case 2:
+		return fields[0], fields[1], ""
+	default:
+		return fields[0], strings.Join(fields[1:len(fields)-1], " "), fields[len(fields)-1]
+	}
+}
+
+// parseStatus converts a recorded status code to an integer.
+func parseStatus(s string) (int, error) {
+	if s == "" || s == EmptyFieldValue {
+		return 0, nil
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse status code %q: %w", s, err)
+	}
+	return status, nil
+}
+
+// parseBytes converts a recorded response size to an integer. The "-"
+// placeholder is recorded when no content was returned.
+func parseBytes(s string) (int64, error) {
+	if s == "" || s == EmptyFieldValue {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse response size %q: %w", s, err)
+	}
+	return size, nil
+}
+
+// Host returns the hostname of the requested URL, or an empty string if the
+// URL could not be parsed or does not contain a hostname.
+func (e Entry) Host() string {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// UserSession converts an Entry value to a UserSession value.
+func (e Entry) UserSession() ezproxy.UserSession {
+	return ezproxy.UserSession{
+		SessionID: e.SessionID,
+		IPAddress: e.ClientIP,
+		Username:  e.Username,
+	}
+}
+
+// MatchingEntries returns the entries associated with the specified
+// username.
+func (entries Entries) MatchingEntries(username string) Entries {
+
+	matchingEntries := make(Entries, 0, len(entries))
+
+	for idx := range entries {
+		if strings.EqualFold(entries[idx].Username, username) {
+			matchingEntries = append(matchingEntries, entries[idx])
+		}
+	}
+
+	return matchingEntries
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/atc0005/go-ezproxy/internal/ctxutils"
+)
+
+// Verify confirms that the sessions terminated successfully (those with an
+// OutcomeTerminated outcome) are no longer listed by the provided reader,
+// setting the Verified field for each confirmed result. EZproxy does not
+// immediately write session changes to disk, so sessions are re-read until
+// all terminated sessions are absent or the search attempts are exhausted.
+//
+// The search retries and search delay settings of the reader are used if it
+// implements SearchSettings, otherwise DefaultSearchRetries and
+// DefaultSearchDelay are used. Results which remain unverified are not an
+// error; an error is returned only if the sessions could not be read or the
+// provided context is done.
+func (tusr TerminateUserSessionResults) Verify(ctx context.Context, reader SessionsReader) error {
+
+	if ctx == nil {
+		return errors.New("func Verify: missing context")
+	}
+
+	if reader == nil {
+		return errors.New("func Verify: missing reader")
+	}
+
+	retries, delay := DefaultSearchRetries, DefaultSearchDelay
+	if settings, ok := reader.(SearchSettings); ok {
+		retries, delay = settings.SearchSettings()
+	}
+
+	pending := make(map[string][]int, len(tusr))
+	for idx := range tusr {
+		tusr[idx].Verified = false
+		if tusr[idx].Outcome() == OutcomeTerminated {
+			pending[tusr[idx].SessionID] = append(pending[tusr[idx].SessionID], idx)
+		}
+	}
+
+	searchAttemptsAllowed := retries + 1
+
+	for searchAttempts := 1; searchAttempts <= searchAttemptsAllowed && len(pending) > 0; searchAttempts++ {
+
+		Logger.Printf(
+			"Beginning verification attempt %d of %d for %d terminated sessions\n",
+			searchAttempts,
+			searchAttemptsAllowed,
+			len(pending),
+		)
+
+		// Intentional delay in an effort to better avoid stale data due to
+		// potential race condition with EZproxy write delays.
+		if err := ctxutils.Sleep(ctx, delay); err != nil {
+			return fmt.Errorf("func Verify: verification cancelled: %w", err)
+		}
+
+		var sessions UserSessions
+		var err error
+		if rc, ok := reader.(SessionsReaderContext); ok {
+			sessions, err = rc.AllUserSessionsContext(ctx)
+		} else {
+			sessions, err = reader.AllUserSessions()
+		}
+		if err != nil {
+			return fmt.Errorf("func Verify: failed to retrieve user sessions: %w", err)
+		}
+
+		listed := make(map[string]struct{}, len(sessions))
+		for _, session := range sessions {
+			listed[session.SessionID] = struct{}{}
+		}
+
+		for sessionID, indexes := range pending {
+			if _, ok := listed[sessionID]; ok {
+				continue
+			}
+			for _, idx := range indexes {
+				tusr[idx].Verified = true
+			}
+			delete(pending, sessionID)
+		}
+	}
+
+	Logger.Printf("%d terminated sessions remain unverified\n", len(pending))
+
+	return nil
+}
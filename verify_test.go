@@ -0,0 +1,293 @@
+// Copyright 2020 Adam Chalkley
+//
+// https://github.com/atc0005/go-ezproxy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ezproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSessionsReader is a SessionsReader which lists the provided sessions
+// in turn for each read, repeating the last listing once all have been
+// listed.
+type fakeSessionsReader struct {
+	mu       sync.Mutex
+	listings []UserSessions
+	retries  int
+	err      error
+	reads    int
+}
+
+func (f *fakeSessionsReader) AllUserSessions() (UserSessions, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reads++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	if len(f.listings) == 0 {
+		return nil, nil
+	}
+
+	idx := f.reads - 1
+	if idx >= len(f.listings) {
+		idx = len(f.listings) - 1
+	}
+
+	return f.listings[idx], nil
+}
+
+func (f *fakeSessionsReader) MatchingUserSessions() (UserSessions, error) {
+	return f.AllUserSessions()
+}
+
+func (f *fakeSessionsReader) SetSearchRetries(retries int) error {
+	f.retries = retries
+	return nil
+}
+
+func (f *fakeSessionsReader) SetSearchDelay(int) error {
+	return nil
+}
+
+// SearchSettings disables the search delay so that the tests run quickly.
+func (f *fakeSessionsReader) SearchSettings() (int, time.Duration) {
+	return f.retries, 0
+}
+
+// readCount returns the number of times the sessions have been read.
+func (f *fakeSessionsReader) readCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads
+}
+
+// testSessions returns user sessions with the given session IDs.
+func testSessions(sessionIDs ...string) UserSessions {
+	sessions := make(UserSessions, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		sessions = append(sessions, UserSession{SessionID: sessionID})
+	}
+	return sessions
+}
+
+// terminatedResult returns the result of terminating the specified session
+// using the `kill` subcommand.
+func terminatedResult(sessionID string) TerminateUserSessionResult {
+	return TerminateUserSessionResult{
+		UserSession: UserSession{SessionID: sessionID},
+		ExitCode:    KillSubCmdExitCodeSessionTerminated,
+		StdOut:      fmt.Sprintf(KillSubCmdExitTextTemplateSessionTerminated, sessionID),
+	}
+}
+
+// notFoundResult returns the result of terminating the specified session
+// using the `kill` subcommand when the session does not exist.
+func notFoundResult(sessionID string) TerminateUserSessionResult {
+	return TerminateUserSessionResult{
+		UserSession: UserSession{SessionID: sessionID},
+		ExitCode:    KillSubCmdExitCodeSessionDoesNotExist,
+		StdOut:      fmt.Sprintf(KillSubCmdExitTextTemplateSessionDoesNotExist, sessionID),
+	}
+}
+
+// verifiedFlags returns the Verified field of each result.
+func verifiedFlags(results TerminateUserSessionResults) []bool {
+	verified := make([]bool, 0, len(results))
+	for idx := range results {
+		verified = append(verified, results[idx].Verified)
+	}
+	return verified
+}
+
+func TestVerifySessionsGone(t *testing.T) {
+
+	reader := &fakeSessionsReader{
+		retries: 5,
+		listings: []UserSessions{
+			testSessions("aaaaaaaaaaaaaaa", "ccccccccccccccc", "ddddddddddddddd"),
+			testSessions("ccccccccccccccc", "ddddddddddddddd"),
+			testSessions("ddddddddddddddd"),
+		},
+	}
+
+	results := TerminateUserSessionResults{
+		terminatedResult("aaaaaaaaaaaaaaa"),
+		notFoundResult("bbbbbbbbbbbbbbb"),
+		terminatedResult("ccccccccccccccc"),
+	}
+	results[1].Verified = true
+
+	if err := results.Verify(context.Background(), reader); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// Only terminated sessions are verified; sessions are re-read until all
+	// of them are no longer listed.
+	want := []bool{true, false, true}
+	if got := verifiedFlags(results); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Verified = %v, want %v", got, want)
+	}
+
+	if reads := reader.readCount(); reads != 3 {
+		t.Errorf("sessions read %d times, want 3", reads)
+	}
+}
+
+func TestVerifySessionsStillPresent(t *testing.T) {
+
+	reader := &fakeSessionsReader{
+		retries: 2,
+		listings: []UserSessions{
+			testSessions("aaaaaaaaaaaaaaa", "ccccccccccccccc"),
+			testSessions("aaaaaaaaaaaaaaa"),
+		},
+	}
+
+	results := TerminateUserSessionResults{
+		terminatedResult("aaaaaaaaaaaaaaa"),
+		terminatedResult("ccccccccccccccc"),
+	}
+
+	// Sessions which remain listed are not an error.
+	if err := results.Verify(context.Background(), reader); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	want := []bool{false, true}
+	if got := verifiedFlags(results); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Verified = %v, want %v", got, want)
+	}
+
+	if reads := reader.readCount(); reads != 3 {
+		t.Errorf("sessions read %d times, want 3 (1 attempt and 2 retries)", reads)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+
+	results := TerminateUserSessionResults{terminatedResult("aaaaaaaaaaaaaaa")}
+
+	readErr := errors.New("read failed")
+	if err := results.Verify(context.Background(), &fakeSessionsReader{err: readErr}); !errors.Is(err, readErr) {
+		t.Errorf("Verify() error = %v, want %v", err, readErr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := results.Verify(ctx, &fakeSessionsReader{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Verify() error = %v, want %v", err, context.Canceled)
+	}
+
+	if err := results.Verify(context.Background(), nil); err == nil {
+		t.Error("Verify() error = nil for missing reader, want error")
+	}
+
+	if results[0].Verified {
+		t.Error("Verified = true after failed verification, want false")
+	}
+}
+
+// writeFakeExecutable writes a shell script which stands in for the ezproxy
+// binary, reporting each session passed to the `kill` subcommand as
+// terminated. Each session ID is also recorded in a log file alongside the
+// script (see executedSessions).
+func writeFakeExecutable(t *testing.T) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+
+	executable := filepath.Join(t.TempDir(), "ezproxy")
+	script := "#!/bin/sh\necho \"$2\" >> \"$0.log\"\necho \"Session $2 terminated\"\nexit 0\n"
+
+	// #nosec G306
+	if err := os.WriteFile(executable, []byte(script), 0o700); err != nil {
+		t.Fatalf("failed to write executable: %v", err)
+	}
+
+	return executable
+}
+
+// executedSessions returns the session IDs passed to the `kill` subcommand
+// of the script written by writeFakeExecutable.
+func executedSessions(t *testing.T, executable string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(executable + ".log")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+
+	return strings.Fields(string(content))
+}
+
+func TestTerminateUserSessionWithOptionsVerifier(t *testing.T) {
+
+	executable := writeFakeExecutable(t)
+
+	reader := &fakeSessionsReader{
+		retries: 1,
+		listings: []UserSessions{
+			testSessions("bbbbbbbbbbbbbbb"),
+		},
+	}
+
+	sessions := testSessions("aaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbb")
+
+	results := sessions.TerminateWithOptions(
+		context.Background(),
+		executable,
+		TerminateOptions{Verifier: reader},
+	)
+
+	for idx := range results {
+		if got := results[idx].Outcome(); got != OutcomeTerminated {
+			t.Fatalf("result %d Outcome() = %v, want %v", idx, got, OutcomeTerminated)
+		}
+	}
+
+	want := []bool{true, false}
+	if got := verifiedFlags(results); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Verified = %v, want %v", got, want)
+	}
+
+	if got := executedSessions(t, executable); len(got) != 2 {
+		t.Errorf("executed sessions = %v, want 2 sessions", got)
+	}
+
+	// Without a verifier the results are left unverified.
+	results = TerminateUserSession(executable, sessions...)
+	if got := verifiedFlags(results); fmt.Sprint(got) != fmt.Sprint([]bool{false, false}) {
+		t.Errorf("Verified = %v without verifier, want none verified", got)
+	}
+}